@@ -13,6 +13,10 @@
 
 package graft
 
+import (
+	"sync"
+)
+
 // ChanHandler is a convenience handler when a user wants to simply use
 // channels for the async handling of errors and state changes.
 type ChanHandler struct {
@@ -22,8 +26,18 @@ type ChanHandler struct {
 	stateChangeChan chan<- StateChange
 	// Chan to receive errors.
 	errorChan chan<- error
+
+	// Errors waiting to be received, bounded by errorBuffer.
+	mu          sync.Mutex
+	errors      []error
+	errorBuffer int
+	dropped     uint64
 }
 
+// DEFAULT_ERROR_BUFFER is the default number of errors a ChanHandler
+// holds while its error channel is not read.
+const DEFAULT_ERROR_BUFFER = 256
+
 // StateChange captures "from" and "to" States for the ChanHandler.
 type StateChange struct {
 	// From is the previous state.
@@ -31,6 +45,13 @@ type StateChange struct {
 
 	// To is the new state.
 	To State
+
+	// Reason is why the state changed.
+	Reason Reason
+
+	// Campaign is the id of the campaign run as CANDIDATE, when From or
+	// To is CANDIDATE.
+	Campaign string
 }
 
 // NewChanHandler returns a Handler implementation which uses channels for
@@ -59,7 +80,61 @@ func (chand *ChanHandler) StateChange(from, to State) {
 	chand.stateChangeChan <- StateChange{From: from, To: to}
 }
 
-// Queue the error onto the channel
+// Queue the state change and its reason onto the channel
+func (chand *ChanHandler) StateChangeWithReason(from, to State, reason Reason) {
+	chand.stateChangeChan <- StateChange{From: from, To: to, Reason: reason}
+}
+
+// Queue the full state change onto the channel
+func (chand *ChanHandler) OnStateChange(sc StateChange) {
+	chand.stateChangeChan <- sc
+}
+
+// SetErrorBuffer sets how many errors are held while the error channel
+// is not read, DEFAULT_ERROR_BUFFER by default. Errors beyond that are
+// dropped and counted, so the node never stalls on error reporting.
+func (chand *ChanHandler) SetErrorBuffer(size int) {
+	chand.mu.Lock()
+	defer chand.mu.Unlock()
+	chand.errorBuffer = max(size, 1)
+}
+
+// DroppedErrors returns the number of errors dropped because the error
+// buffer was full.
+func (chand *ChanHandler) DroppedErrors() uint64 {
+	chand.mu.Lock()
+	defer chand.mu.Unlock()
+	return chand.dropped
+}
+
+// Queue the error onto the channel, or drop it if the buffer is full.
 func (chand *ChanHandler) AsyncError(err error) {
-	chand.errorChan <- err
+	chand.mu.Lock()
+	defer chand.mu.Unlock()
+	size := chand.errorBuffer
+	if size == 0 {
+		size = DEFAULT_ERROR_BUFFER
+	}
+	if len(chand.errors) >= size {
+		chand.dropped++
+		return
+	}
+	chand.errors = append(chand.errors, err)
+	// Start forwarding only for the first error added.
+	if len(chand.errors) == 1 {
+		go chand.forwardErrors()
+	}
+}
+
+// forwardErrors sends the buffered errors to the channel in order.
+func (chand *ChanHandler) forwardErrors() {
+	chand.mu.Lock()
+	defer chand.mu.Unlock()
+	for len(chand.errors) > 0 {
+		err := chand.errors[0]
+		chand.mu.Unlock()
+		chand.errorChan <- err
+		chand.mu.Lock()
+		chand.errors = chand.errors[1:]
+	}
 }
@@ -0,0 +1,70 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+import (
+	"time"
+)
+
+// Leadership changes remembered for RecentLeadershipChanges.
+const maxLeadershipChanges = 1024
+
+// A LeadershipChurnHandler is a Handler notified when the leadership of
+// its cluster is unstable: more than the threshold of leadership changes
+// set with WithLeadershipChurnThreshold happened within its window. It is
+// notified once each time the count of changes crosses the threshold,
+// with the Scheduler of its node.
+type LeadershipChurnHandler interface {
+	OnLeadershipChurn(changes int, window time.Duration)
+}
+
+// RecentLeadershipChanges returns the number of times this node learned
+// of a new LEADER, including itself, within the last window. Up to the
+// last 1024 changes are remembered.
+func (n *Node) RecentLeadershipChanges(window time.Duration) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.leaderChangesSince(n.opts.clock.Now().Add(-window))
+}
+
+// leaderChangesSince counts the leadership changes after since.
+// Lock should be held.
+func (n *Node) leaderChangesSince(since time.Time) int {
+	count := 0
+	for i := len(n.leaderChanges) - 1; i >= 0 && n.leaderChanges[i].After(since); i-- {
+		count++
+	}
+	return count
+}
+
+// recordLeaderChange records that we learned of a new LEADER at, and
+// notifies the handler if it makes the leadership churn.
+// Lock should be held.
+func (n *Node) recordLeaderChange(at time.Time) {
+	if len(n.leaderChanges) >= maxLeadershipChanges {
+		n.leaderChanges = n.leaderChanges[1:]
+	}
+	n.leaderChanges = append(n.leaderChanges, at)
+	n.opts.metrics.IncrCounter(METRIC_LEADERSHIP_CHANGES, 1)
+
+	threshold, window := n.opts.churnThreshold, n.opts.churnWindow
+	if threshold <= 0 {
+		return
+	}
+	// Only notify when crossing the threshold.
+	if changes := n.leaderChangesSince(at.Add(-window)); changes == threshold+1 {
+		h := n.handler.(LeadershipChurnHandler)
+		n.opts.scheduler.Go(func() { h.OnLeadershipChurn(changes, window) })
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the time source of a node. The default uses the time
+// package; a FakeClock lets tests and simulations control time.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is the subset of time.Timer used by a node.
+type Timer interface {
+	C() <-chan time.Time
+	Reset(d time.Duration) bool
+	Stop() bool
+}
+
+// Ticker is the subset of time.Ticker used by a node.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// realClock implements Clock with the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return &realTimer{time.NewTimer(d)}
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return &realTicker{time.NewTicker(d)}
+}
+
+type realTimer struct{ t *time.Timer }
+
+func (t *realTimer) C() <-chan time.Time        { return t.t.C }
+func (t *realTimer) Reset(d time.Duration) bool { return t.t.Reset(d) }
+func (t *realTimer) Stop() bool                 { return t.t.Stop() }
+
+type realTicker struct{ t *time.Ticker }
+
+func (t *realTicker) C() <-chan time.Time { return t.t.C }
+func (t *realTicker) Stop()               { t.t.Stop() }
+
+// FakeClock is a Clock that only moves when Advance is called.
+// Timers and tickers fire from within Advance, in deadline order.
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+	// Timers whose channel fired in the last Advance, and AfterFunc
+	// calls not returned yet, see idle.
+	fired []*fakeTimer
+	calls int
+}
+
+// NewFakeClock returns a FakeClock set to start.
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+// Now returns the current time of the clock.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTimer returns a Timer firing once d has elapsed on the clock.
+func (c *FakeClock) NewTimer(d time.Duration) Timer {
+	return c.newTimer(d, 0, nil)
+}
+
+// NewTicker returns a Ticker firing every d on the clock.
+func (c *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("graft: non-positive interval for NewTicker")
+	}
+	return &fakeTicker{c.newTimer(d, d, nil)}
+}
+
+// AfterFunc calls f in its own goroutine once d has elapsed on the clock.
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	return c.newTimer(d, 0, f)
+}
+
+// Advance moves the clock forward by d, firing the timers that expire.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.advance(d)
+}
+
+// advance is Advance returning the number of timers fired.
+func (c *FakeClock) advance(d time.Duration) int {
+	fired := 0
+	c.mu.Lock()
+	c.fired = c.fired[:0]
+	end := c.now.Add(d)
+	for {
+		t := c.next(end)
+		if t == nil {
+			break
+		}
+		c.now = t.when
+		if t.period > 0 {
+			t.when = t.when.Add(t.period)
+		} else {
+			t.active = false
+			c.remove(t)
+		}
+		t.fire(c.now)
+		fired++
+	}
+	c.now = end
+	c.mu.Unlock()
+	return fired
+}
+
+// idle reports whether the expirations fired by the last Advance were
+// received, and all AfterFunc calls returned.
+func (c *FakeClock) idle() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.calls > 0 {
+		return false
+	}
+	for _, t := range c.fired {
+		if len(t.c) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// next returns the active timer expiring first, not after end.
+// Lock is held on entry.
+func (c *FakeClock) next(end time.Time) *fakeTimer {
+	sort.SliceStable(c.timers, func(i, j int) bool {
+		return c.timers[i].when.Before(c.timers[j].when)
+	})
+	for _, t := range c.timers {
+		if t.active && !t.when.After(end) {
+			return t
+		}
+	}
+	return nil
+}
+
+func (c *FakeClock) newTimer(d, period time.Duration, f func()) *fakeTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{
+		clock:  c,
+		c:      make(chan time.Time, 1),
+		when:   c.now.Add(d),
+		period: period,
+		f:      f,
+		active: true,
+	}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// remove forgets about an inactive timer. Lock is held on entry.
+func (c *FakeClock) remove(t *fakeTimer) {
+	for i, ot := range c.timers {
+		if ot == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return
+		}
+	}
+}
+
+type fakeTimer struct {
+	clock  *FakeClock
+	c      chan time.Time
+	when   time.Time
+	period time.Duration
+	f      func()
+	active bool
+}
+
+// fire delivers the expiration. Like the time package, a tick is
+// dropped if the previous one was not received.
+// Clock lock is held on entry.
+func (t *fakeTimer) fire(now time.Time) {
+	if t.f != nil {
+		t.clock.calls++
+		go func() {
+			t.f()
+			t.clock.mu.Lock()
+			t.clock.calls--
+			t.clock.mu.Unlock()
+		}()
+		return
+	}
+	t.clock.fired = append(t.clock.fired, t)
+	select {
+	case t.c <- now:
+	default:
+	}
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+// Reset rearms the timer and, like time.Timer since Go 1.23, discards
+// an expiration that was not received yet.
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	active := t.active
+	if !active {
+		t.clock.timers = append(t.clock.timers, t)
+	}
+	t.when = t.clock.now.Add(d)
+	t.active = true
+	t.drain()
+	return active
+}
+
+// Stop disarms the timer and discards an expiration that was not
+// received yet.
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	active := t.active
+	if active {
+		t.clock.remove(t)
+	}
+	t.active = false
+	t.drain()
+	return active
+}
+
+func (t *fakeTimer) drain() {
+	select {
+	case <-t.c:
+	default:
+	}
+}
+
+type fakeTicker struct{ t *fakeTimer }
+
+func (t *fakeTicker) C() <-chan time.Time { return t.t.C() }
+func (t *fakeTicker) Stop()               { t.t.Stop() }
@@ -0,0 +1,44 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+import (
+	"google.golang.org/protobuf/proto"
+)
+
+// An RpcCodec encodes the messages exchanged between the nodes, i.e. a
+// *pb.VoteRequest, *pb.VoteResponse, *pb.Heartbeat or
+// *pb.HeartbeatResponse, as defined in pb/protocol.proto. All the
+// members of a cluster must use the same codec. A custom codec lets
+// implementations in other languages take part, e.g. with JSON.
+type RpcCodec interface {
+	// Encode returns the encoding of msg.
+	Encode(msg proto.Message) ([]byte, error)
+	// Decode decodes data into msg, which is reset first.
+	Decode(data []byte, msg proto.Message) error
+}
+
+// ProtobufCodec is the default RpcCodec. It encodes the messages in the
+// binary format of protocol buffers, following pb/protocol.proto.
+type ProtobufCodec struct{}
+
+// Encode returns the protocol buffers encoding of msg.
+func (ProtobufCodec) Encode(msg proto.Message) ([]byte, error) {
+	return proto.Marshal(msg)
+}
+
+// Decode decodes the protocol buffers encoding data into msg.
+func (ProtobufCodec) Decode(data []byte, msg proto.Message) error {
+	return proto.Unmarshal(data, msg)
+}
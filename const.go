@@ -20,6 +20,10 @@ import (
 const (
 	VERSION = "0.7"
 
+	// Protocol version advertised in heartbeats and vote requests.
+	// Peers predating it advertise 0.
+	PROTOCOL_VERSION = 1
+
 	// Election timeout MIN and MAX per RAFT spec suggestion.
 	MIN_ELECTION_TIMEOUT = 500 * time.Millisecond
 	MAX_ELECTION_TIMEOUT = 2 * MIN_ELECTION_TIMEOUT
@@ -28,6 +32,21 @@ const (
 	// Should be << MIN_ELECTION_TIMEOUT per RAFT spec.
 	HEARTBEAT_INTERVAL = 100 * time.Millisecond
 
+	// Smallest election timeout, as a multiple of the heartbeat
+	// interval, accepted by WithHeartbeatInterval.
+	MIN_ELECTION_MULTIPLIER = 3
+
+	// Largest campaign jitter, as a multiple of the heartbeat interval,
+	// accepted by WithCampaignJitter.
+	MAX_CAMPAIGN_JITTER_MULTIPLIER = 5
+
+	// Longest leadership extension, as a multiple of the heartbeat
+	// interval, see Node.ExtendLeadership.
+	MAX_LEADERSHIP_EXTENSION_MULTIPLIER = 50
+
+	// Maximum size of the metadata advertised in heartbeats.
+	MAX_ADVERTISED_SIZE = 1024
+
 	NO_LEADER = ""
 	NO_VOTE   = ""
 )
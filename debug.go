@@ -0,0 +1,193 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// DebugInfo is a snapshot of the internal state of a node, taken at
+// once by DebugDump, e.g. to attach to an incident. It is serializable
+// to JSON.
+type DebugInfo struct {
+	At          time.Time `json:"at"`
+	Id          string    `json:"id"`
+	Cluster     string    `json:"cluster"`
+	ClusterSize int       `json:"cluster_size"`
+	State       string    `json:"state"`
+	Term        uint64    `json:"term"`
+	Vote        string    `json:"vote,omitempty"`
+	// When we cast the vote, nil if it was loaded from the log.
+	VotedAt   *time.Time `json:"voted_at,omitempty"`
+	Leader    string     `json:"leader,omitempty"`
+	ElectedAt *time.Time `json:"elected_at,omitempty"`
+	// Peers that granted us their vote, as CANDIDATE or LEADER.
+	Voters []string `json:"voters,omitempty"`
+	// What we know of each peer we heard from.
+	Peers map[string]DebugPeer `json:"peers"`
+	// Consecutive elections started without electing a LEADER, and
+	// the tallies of the last failed campaigns, as many as diagnosed
+	// with WithElectionDiagnostics.
+	ElectionAttempts int             `json:"election_attempts"`
+	FailedCampaigns  []DebugCampaign `json:"failed_campaigns,omitempty"`
+	// Time left before the election timer fires, 0 if stopped.
+	ElectionTimerRemaining time.Duration `json:"election_timer_remaining"`
+	Observer               bool          `json:"observer,omitempty"`
+	Degraded               bool          `json:"degraded,omitempty"`
+	CatchingUp             bool          `json:"catching_up,omitempty"`
+	Disconnected           bool          `json:"disconnected,omitempty"`
+	LogPath                string        `json:"log_path,omitempty"`
+	StateFormat            string        `json:"state_format"`
+}
+
+// DebugPeer is what a node knows of a peer in its DebugInfo.
+type DebugPeer struct {
+	// Last heartbeat round-trip time measured as LEADER, and when.
+	Latency time.Duration `json:"latency,omitempty"`
+	LastAck *time.Time    `json:"last_ack,omitempty"`
+	// Last failure to communicate with the peer, and when.
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+	// Set with the protocol version the peer advertised if it is
+	// incompatible with ours.
+	Incompatible        bool   `json:"incompatible,omitempty"`
+	IncompatibleVersion uint32 `json:"incompatible_version,omitempty"`
+	// Whether the peer granted us its vote in the current term.
+	GrantedVote bool `json:"granted_vote,omitempty"`
+}
+
+// DebugCampaign is the tally of a failed campaign in a DebugInfo.
+type DebugCampaign struct {
+	Votes      int `json:"votes"`
+	Responders int `json:"responders"`
+	Late       int `json:"late"`
+}
+
+// DebugDump returns a snapshot of the internal state of the node, read
+// at once so that it is consistent. It never changes the node, and may
+// be called at any time, even once closed.
+func (n *Node) DebugDump() DebugInfo {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	now := n.opts.clock.Now()
+	info := DebugInfo{
+		At:               now,
+		Id:               n.id,
+		Cluster:          n.info.Name,
+		ClusterSize:      n.size,
+		State:            n.state.String(),
+		Term:             n.term,
+		Vote:             n.vote,
+		Leader:           n.leader,
+		Voters:           slices.Clone(n.voters),
+		Peers:            make(map[string]DebugPeer),
+		ElectionAttempts: n.attempts,
+		Observer:         n.observer,
+		Degraded:         n.degraded,
+		CatchingUp:       n.catchingUp,
+		Disconnected:     n.disconnected,
+		LogPath:          n.logPath,
+		StateFormat:      n.stateFormat.String(),
+	}
+	if n.vote != NO_VOTE && !n.votedAt.IsZero() {
+		at := n.votedAt
+		info.VotedAt = &at
+	}
+	if !n.electedAt.IsZero() {
+		at := n.electedAt
+		info.ElectedAt = &at
+	}
+	if n.electDeadline != nil {
+		info.ElectionTimerRemaining = n.electDeadline.remaining(now)
+	}
+	for _, c := range n.failedCampaigns {
+		info.FailedCampaigns = append(info.FailedCampaigns,
+			DebugCampaign{Votes: c.votes, Responders: c.responders, Late: c.late})
+	}
+
+	// Only the state of peers not pruned yet.
+	cutoff := n.peerCutoff(now)
+	peer := func(id string) DebugPeer { return info.Peers[id] }
+	if n.state == LEADER {
+		for id, pl := range n.latencies {
+			if pl.at.After(cutoff) {
+				p, at := peer(id), pl.at
+				p.Latency, p.LastAck = pl.d, &at
+				info.Peers[id] = p
+			}
+		}
+	}
+	for id, pe := range n.peerErrors {
+		if pe.at.After(cutoff) {
+			p, at := peer(id), pe.at
+			p.LastError, p.LastErrorAt = pe.err.Error(), &at
+			info.Peers[id] = p
+		}
+	}
+	for id, pv := range n.incompatible {
+		if pv.at.After(cutoff) {
+			p := peer(id)
+			p.Incompatible, p.IncompatibleVersion = true, pv.version
+			info.Peers[id] = p
+		}
+	}
+	for _, id := range info.Voters {
+		p := peer(id)
+		p.GrantedVote = true
+		info.Peers[id] = p
+	}
+	return info
+}
+
+// deadlineTimer is a Timer remembering when it fires, for DebugDump.
+type deadlineTimer struct {
+	Timer
+	clock Clock
+
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+// newDeadlineTimer returns a Timer of clock firing once d has elapsed.
+func newDeadlineTimer(clock Clock, d time.Duration) *deadlineTimer {
+	return &deadlineTimer{Timer: clock.NewTimer(d), clock: clock, deadline: clock.Now().Add(d)}
+}
+
+func (t *deadlineTimer) Reset(d time.Duration) bool {
+	t.mu.Lock()
+	t.deadline = t.clock.Now().Add(d)
+	t.mu.Unlock()
+	return t.Timer.Reset(d)
+}
+
+func (t *deadlineTimer) Stop() bool {
+	t.mu.Lock()
+	t.deadline = time.Time{}
+	t.mu.Unlock()
+	return t.Timer.Stop()
+}
+
+// remaining returns the time left at now before the timer fires, 0 if
+// it fired or is stopped.
+func (t *deadlineTimer) remaining(now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.deadline.IsZero() {
+		return 0
+	}
+	return max(t.deadline.Sub(now), 0)
+}
@@ -0,0 +1,127 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+import (
+	"fmt"
+)
+
+// StallCause is the likely cause of elections failing to converge.
+type StallCause int
+
+// Causes of an ElectionStallError.
+const (
+	// Too few peers answered our vote requests to form a quorum.
+	StallPeersUnreachable StallCause = iota
+	// Enough peers answered, but they voted for competing candidates.
+	StallSplitVotes
+	// Vote responses arrived after their campaign was over, the election
+	// timeouts may be too short for the network, or clocks unstable.
+	StallLateResponses
+)
+
+// Convenience for printing, etc.
+func (c StallCause) String() string {
+	switch c {
+	case StallPeersUnreachable:
+		return "PeersUnreachable"
+	case StallSplitVotes:
+		return "SplitVotes"
+	case StallLateResponses:
+		return "LateResponses"
+	default:
+		return fmt.Sprintf("Unknown[%d]", int(c))
+	}
+}
+
+// ElectionStallError summarizes consecutive campaigns of a node that
+// failed to elect a LEADER, see WithElectionDiagnostics. It wraps
+// ErrElectionStalled.
+type ElectionStallError struct {
+	// Consecutive failed campaigns.
+	Campaigns int
+	// Votes needed to win, ours included.
+	Quorum int
+	// Most votes and distinct peers answering in a campaign.
+	MaxVotes      int
+	MaxResponders int
+	// Vote responses received after their campaign was over.
+	LateResponses int
+	// The likely cause.
+	Cause StallCause
+	// Id of the last failed campaign.
+	Campaign string
+}
+
+func (e *ElectionStallError) Error() string {
+	return fmt.Sprintf("%v after %d campaigns, likely %v: at most %d of %d votes, %d peers answered, %d late responses, last campaign %s",
+		ErrElectionStalled, e.Campaigns, e.Cause, e.MaxVotes, e.Quorum, e.MaxResponders, e.LateResponses, e.Campaign)
+}
+
+func (e *ElectionStallError) Unwrap() error {
+	return ErrElectionStalled
+}
+
+// campaignTally is the outcome of a campaign that did not elect us.
+type campaignTally struct {
+	votes      int
+	responders int
+	late       int
+}
+
+// failedCampaign records the tally of a campaign that timed out, and
+// reports an ElectionStallError once enough consecutive ones failed.
+func (n *Node) failedCampaign(tally campaignTally) {
+	if n.opts.stallCampaigns <= 0 {
+		return
+	}
+	n.mu.Lock()
+	n.failedCount++
+	// Keep the tallies of the last campaigns only, however long the
+	// stall lasts.
+	if len(n.failedCampaigns) == n.opts.stallCampaigns {
+		copy(n.failedCampaigns, n.failedCampaigns[1:])
+		n.failedCampaigns = n.failedCampaigns[:len(n.failedCampaigns)-1]
+	}
+	n.failedCampaigns = append(n.failedCampaigns, tally)
+	var err *ElectionStallError
+	// Report once per stall.
+	if n.failedCount == n.opts.stallCampaigns {
+		err = n.diagnoseStall()
+	}
+	n.mu.Unlock()
+	if err != nil {
+		n.handleError(err)
+	}
+}
+
+// diagnoseStall summarizes the failed campaigns.
+// Lock should be held.
+func (n *Node) diagnoseStall() *ElectionStallError {
+	e := &ElectionStallError{Campaigns: n.failedCount, Quorum: Quorum(n.size), Campaign: n.campaign}
+	for _, t := range n.failedCampaigns {
+		e.MaxVotes = max(e.MaxVotes, t.votes)
+		e.MaxResponders = max(e.MaxResponders, t.responders)
+		e.LateResponses += t.late
+	}
+	switch {
+	case e.MaxResponders+1 >= e.Quorum:
+		e.Cause = StallSplitVotes
+	case e.LateResponses > 0:
+		e.Cause = StallLateResponses
+	default:
+		e.Cause = StallPeersUnreachable
+	}
+	return e
+}
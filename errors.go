@@ -14,17 +14,177 @@
 package graft
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io/fs"
 )
 
 var (
-	ErrClusterName  = errors.New("graft: Cluster name can not be empty")
-	ErrClusterSize  = errors.New("graft: Cluster size can not be 0")
-	ErrHandlerReq   = errors.New("graft: Handler is required")
-	ErrRpcDriverReq = errors.New("graft: RPCDriver is required")
-	ErrLogReq       = errors.New("graft: Log is required")
-	ErrLogNoExist   = errors.New("graft: Log file does not exist")
-	ErrLogNoState   = errors.New("graft: Log file does not have any state")
-	ErrLogCorrupt   = errors.New("graft: Encountered corrupt log file")
-	ErrNotImpl      = errors.New("graft: Not implemented")
+	ErrClusterName          = errors.New("graft: Cluster name can not be empty")
+	ErrClusterSize          = errors.New("graft: Cluster size can not be 0")
+	ErrHandlerReq           = errors.New("graft: Handler is required")
+	ErrRpcDriverReq         = errors.New("graft: RPCDriver is required")
+	ErrLogReq               = errors.New("graft: Log is required")
+	ErrLogNoExist           = errors.New("graft: Log file does not exist")
+	ErrLogNoState           = errors.New("graft: Log file does not have any state")
+	ErrLogEmpty             = errors.New("graft: Log file was found empty")
+	ErrLogCorrupt           = errors.New("graft: Encountered corrupt log file")
+	ErrClusterMismatch      = errors.New("graft: Log file belongs to a different cluster")
+	ErrLogClosed            = errors.New("graft: Log is closed")
+	ErrLogVersion           = errors.New("graft: Unsupported log file version")
+	ErrNonAtomicWrite       = errors.New("graft: Log file is written in place, not atomically")
+	ErrLogDirRemoved        = errors.New("graft: Log directory was removed")
+	ErrAdvertisedTooLarge   = errors.New("graft: Advertised metadata is too large")
+	ErrHeartbeatTooLarge    = errors.New("graft: Heartbeat exceeds its size budget")
+	ErrNotImpl              = errors.New("graft: Not implemented")
+	ErrNodeClosed           = errors.New("graft: Node is closed")
+	ErrNodeNotPaused        = errors.New("graft: Node must be paused")
+	ErrNodePaused           = errors.New("graft: Node is paused")
+	ErrGaveUp               = errors.New("graft: Node gave up campaigning")
+	ErrNotServing           = errors.New("graft: Node is not serving yet")
+	ErrNotLeader            = errors.New("graft: Node is not the LEADER")
+	ErrNotExternal          = errors.New("graft: Node does not use external leadership")
+	ErrCannotLead           = errors.New("graft: Node can not become the LEADER")
+	ErrLostElection         = errors.New("graft: Node lost the election")
+	ErrNoSuccessor          = errors.New("graft: No peer to hand the leadership off to")
+	ErrElectionStalled      = errors.New("graft: No LEADER elected")
+	ErrStaleTerm            = errors.New("graft: Term is older than the current term")
+	ErrTermVoted            = errors.New("graft: Already voted for another node in the term")
+	ErrUnknownCandidate     = errors.New("graft: Vote denied to an unknown candidate")
+	ErrIncompatiblePeer     = errors.New("graft: Peer advertised an incompatible protocol version")
+	ErrVoteRequestAsLeader  = errors.New("graft: LEADER received a vote request")
+	ErrLeaseMirror          = errors.New("graft: Failed to mirror the leadership into the lease")
+	ErrDropMessage          = errors.New("graft: Message dropped by the RpcInterceptor")
+	ErrInvalidOption        = errors.New("graft: Invalid option")
+	ErrHeartbeatResponseReq = errors.New("graft: RPCDriver must support heartbeat responses for CheckQuorum")
+	ErrPeerVoteRequesterReq = errors.New("graft: RPCDriver must support per-peer vote requests to bound them")
+	ErrLeaderTickerReq      = errors.New("graft: Handler must implement LeaderTicker for leader ticks")
+	ErrChurnHandlerReq      = errors.New("graft: Handler must implement LeadershipChurnHandler for a churn threshold")
+	ErrPeerProviderReq      = errors.New("graft: PeerProvider is required to know the candidates")
+	ErrMembershipReq        = errors.New("graft: RPCDriver must report its configured members to check them")
+	ErrQuorumUnreachable    = errors.New("graft: Configured members can never reach a quorum")
 )
+
+// ErrorKind classifies the errors returned by the log and RPC subsystems.
+type ErrorKind int
+
+// Kinds of LogError and RPCError.
+const (
+	// The error could not be classified.
+	KindUnknown ErrorKind = iota
+	// The log file or its directory does not exist.
+	KindNotFound
+	// Access to the log file was denied.
+	KindPermission
+	// The log file does not have any state.
+	KindNoState
+	// The log file failed the corruption check.
+	KindCorrupt
+	// The state could not be encoded or decoded.
+	KindEncoding
+	// The RPC transport failed.
+	KindTransport
+	// The log file was written by a different cluster.
+	KindClusterMismatch
+	// The RPC transport failed to connect, after a successful Init.
+	KindConnect
+)
+
+// Convenience for printing, etc.
+func (k ErrorKind) String() string {
+	switch k {
+	case KindUnknown:
+		return "Unknown"
+	case KindNotFound:
+		return "NotFound"
+	case KindPermission:
+		return "Permission"
+	case KindNoState:
+		return "NoState"
+	case KindCorrupt:
+		return "Corrupt"
+	case KindEncoding:
+		return "Encoding"
+	case KindTransport:
+		return "Transport"
+	case KindClusterMismatch:
+		return "ClusterMismatch"
+	case KindConnect:
+		return "Connect"
+	default:
+		return fmt.Sprintf("Unknown[%d]", int(k))
+	}
+}
+
+// LogError records a failure of an operation on the log file.
+// The sentinel errors such as ErrLogCorrupt can be tested for
+// with errors.Is.
+type LogError struct {
+	Kind ErrorKind
+	Op   string
+	Path string
+	Err  error
+}
+
+func (e *LogError) Error() string {
+	return e.Op + " " + e.Path + ": " + e.Err.Error()
+}
+
+func (e *LogError) Unwrap() error {
+	return e.Err
+}
+
+// newLogError wraps err, classifying it by its cause.
+func newLogError(op, path string, err error) *LogError {
+	kind := KindUnknown
+	var serr *json.SyntaxError
+	var terr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, ErrLogNoState):
+		kind = KindNoState
+	case errors.Is(err, ErrLogCorrupt):
+		kind = KindCorrupt
+	case errors.Is(err, ErrClusterMismatch):
+		kind = KindClusterMismatch
+	case errors.Is(err, fs.ErrNotExist):
+		kind = KindNotFound
+	case errors.Is(err, fs.ErrPermission):
+		kind = KindPermission
+	case errors.Is(err, ErrLogVersion), errors.As(err, &serr), errors.As(err, &terr):
+		kind = KindEncoding
+	}
+	return &LogError{Kind: kind, Op: op, Path: path, Err: err}
+}
+
+// CorruptionError details a log file whose content does not match the
+// digest stored with it. It wraps ErrLogCorrupt.
+type CorruptionError struct {
+	// Digest stored in the file.
+	Expected []byte
+	// Digest computed from the content.
+	Detected []byte
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("%v: expected digest %x, detected %x", ErrLogCorrupt, e.Expected, e.Detected)
+}
+
+func (e *CorruptionError) Unwrap() error {
+	return ErrLogCorrupt
+}
+
+// RPCError records a failure of an RPCDriver operation.
+type RPCError struct {
+	Kind ErrorKind
+	Op   string
+	Err  error
+}
+
+func (e *RPCError) Error() string {
+	return "rpc " + e.Op + ": " + e.Err.Error()
+}
+
+func (e *RPCError) Unwrap() error {
+	return e.Err
+}
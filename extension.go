@@ -0,0 +1,60 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+import (
+	"time"
+)
+
+// ExtendLeadership protects the leadership of a LEADER for d, e.g. while
+// the application does heavy initialization that may delay the
+// heartbeats. Meanwhile, the LEADER does not step down for a lost quorum,
+// and its heartbeats ask the followers to extend their election timeouts
+// by what remains of d, so a late heartbeat does not trigger an election.
+// d is capped to MAX_LEADERSHIP_EXTENSION_MULTIPLIER heartbeat intervals,
+// which followers enforce too. A d of 0 ends the extension. A newer term
+// still makes the LEADER step down, which ends it.
+func (n *Node) ExtendLeadership(d time.Duration) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	switch n.state {
+	case CLOSED:
+		return ErrNodeClosed
+	case LEADER:
+	default:
+		return ErrNotLeader
+	}
+	n.extendedUntil = n.opts.clock.Now().Add(min(max(d, 0), n.maxExtension()))
+	return nil
+}
+
+// maxExtension returns the longest leadership extension.
+func (n *Node) maxExtension() time.Duration {
+	return MAX_LEADERSHIP_EXTENSION_MULTIPLIER * n.opts.heartbeat
+}
+
+// leadershipExtension returns what remains of our leadership extension.
+func (n *Node) leadershipExtension() time.Duration {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return max(n.extendedUntil.Sub(n.opts.clock.Now()), 0)
+}
+
+// clearLeadershipExtension ends our leadership extension, so a new
+// leadership does not inherit it.
+func (n *Node) clearLeadershipExtension() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.extendedUntil = time.Time{}
+}
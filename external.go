@@ -0,0 +1,105 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+// externalReq is a request from an external coordinator to assume or
+// relinquish leadership, processed by the node's loop.
+type externalReq struct {
+	lead bool
+	term uint64
+	done chan error
+}
+
+// AssumeLeadership makes a node using WithExternalLeadership the LEADER
+// of term, which must not be older than its current term, else it fails
+// with ErrStaleTerm. Nor may the node have voted for another node in
+// term, else it fails with ErrTermVoted. The term and the node's vote for
+// itself are persisted before it sends heartbeats. The coordinator is
+// responsible for having a single LEADER per term.
+func (n *Node) AssumeLeadership(term uint64) error {
+	return n.externalLeadership(&externalReq{lead: true, term: term})
+}
+
+// RelinquishLeadership makes a node using WithExternalLeadership step
+// down to FOLLOWER. It does nothing if the node is not LEADER.
+func (n *Node) RelinquishLeadership() error {
+	return n.externalLeadership(&externalReq{})
+}
+
+func (n *Node) externalLeadership(req *externalReq) error {
+	if !n.opts.externalLeadership {
+		return ErrNotExternal
+	}
+	if n.State() == CLOSED {
+		return ErrNodeClosed
+	}
+	req.done = make(chan error, 1)
+	select {
+	case n.external <- req:
+	case <-n.closing:
+		return ErrNodeClosed
+	}
+	return <-req.done
+}
+
+// processExternal processes a request of the external coordinator and
+// returns whether the current loop must return to switch state.
+func (n *Node) processExternal(req *externalReq) bool {
+	if !req.lead {
+		if n.State() != LEADER {
+			req.done <- nil
+			return false
+		}
+		n.switchToFollower(NO_LEADER, REASON_EXTERNAL)
+		req.done <- nil
+		return true
+	}
+
+	n.mu.Lock()
+	term, vote, at := n.term, n.vote, n.votedAt
+	n.mu.Unlock()
+	if req.term < term {
+		req.done <- ErrStaleTerm
+		return false
+	}
+	// Our vote in the term may have elected another LEADER.
+	if req.term == term && vote != NO_VOTE && vote != n.id {
+		req.done <- ErrTermVoted
+		return false
+	}
+
+	n.setTerm(req.term)
+	n.castVote(n.id)
+	if err := n.writeState(); err != nil {
+		// Keep our previous state.
+		n.mu.Lock()
+		n.term, n.vote, n.votedAt = term, vote, at
+		n.mu.Unlock()
+		n.handleError(err)
+		req.done <- err
+		return false
+	}
+	if req.term > term {
+		n.termJumped(term, req.term, n.id)
+	}
+
+	// Already LEADER, carry on with the new term.
+	if n.State() == LEADER {
+		req.done <- nil
+		return false
+	}
+	n.switchToLeader(REASON_EXTERNAL)
+	req.done <- nil
+	return true
+}
@@ -0,0 +1,89 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExternalLeadership(t *testing.T) {
+	ci := ClusterInfo{Name: "external", Size: 3}
+	nodes := make([]*Node, 3)
+	for i := range nodes {
+		hand, rpc, log := genNodeArgs(t)
+		node, err := New(ci, hand, rpc, log,
+			WithHeartbeatInterval(5*time.Millisecond, 10, 20), WithExternalLeadership())
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		defer node.Close()
+		nodes[i] = node
+	}
+	noElection := func() {
+		t.Helper()
+		// Well past the election timeouts.
+		time.Sleep(300 * time.Millisecond)
+		for _, n := range nodes {
+			if state := n.State(); state != FOLLOWER {
+				t.Fatalf("Expected no election, got a %s", state)
+			}
+		}
+	}
+	noElection()
+
+	leader := nodes[0]
+	if err := leader.AssumeLeadership(3); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if state := leader.State(); state != LEADER {
+		t.Fatalf("Expected Node to be in Leader state, got: %s", state)
+	}
+	// Our term is persisted, and the others hear our heartbeats.
+	testStateOfNode(t, leader)
+	for _, n := range nodes[1:] {
+		if l := waitForLeader(n, leader.Id()); l != leader.Id() {
+			t.Fatalf("Expected leader %s, got %q", leader.Id(), l)
+		}
+		if term := n.CurrentTerm(); term != 3 {
+			t.Fatalf("Expected term 3, got %d", term)
+		}
+	}
+	if err := nodes[1].AssumeLeadership(2); err != ErrStaleTerm {
+		t.Fatalf("Expected %v, got: %v", ErrStaleTerm, err)
+	}
+	// Nor can it lead a term it voted for another node in.
+	nodes[1].setVote(leader.Id())
+	if err := nodes[1].AssumeLeadership(3); err != ErrTermVoted {
+		t.Fatalf("Expected %v, got: %v", ErrTermVoted, err)
+	}
+	if state := nodes[1].State(); state != FOLLOWER {
+		t.Fatalf("Expected Node to stay a Follower, got: %s", state)
+	}
+
+	if err := leader.RelinquishLeadership(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	noElection()
+
+	hand, rpc, log := genNodeArgs(t)
+	node, err := New(ci, hand, rpc, log)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+	if err := node.AssumeLeadership(1); err != ErrNotExternal {
+		t.Fatalf("Expected %v, got: %v", ErrNotExternal, err)
+	}
+}
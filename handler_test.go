@@ -14,9 +14,11 @@
 package graft
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -81,6 +83,50 @@ func TestStateChangeHandler(t *testing.T) {
 	}
 }
 
+func TestStateChangeReasons(t *testing.T) {
+	expect := func(sc *StateChange, from, to State, reason Reason) {
+		t.Helper()
+		if sc.From != from || sc.To != to || sc.Reason != reason {
+			t.Fatalf("Expected %s to %s for %s, got %s to %s for %s",
+				from, to, reason, sc.From, sc.To, sc.Reason)
+		}
+	}
+
+	// A single node wins its campaign.
+	ci := ClusterInfo{Name: "foo", Size: 1}
+	_, rpc, log := genNodeArgs(t)
+	scCh := make(chan StateChange)
+	errCh := make(chan error)
+	node, err := New(ci, NewChanHandler(scCh, errCh), rpc, log)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+
+	expect(wait(t, scCh), FOLLOWER, CANDIDATE, REASON_ELECTION_TIMEOUT)
+	expect(wait(t, scCh), CANDIDATE, LEADER, REASON_WON_ELECTION)
+
+	newTerm := node.CurrentTerm() + 1
+	node.HeartBeats <- &pb.Heartbeat{Term: newTerm, Leader: "new"}
+	expect(wait(t, scCh), LEADER, FOLLOWER, REASON_HIGHER_TERM)
+	node.Close()
+
+	// A lone member of a larger cluster hears from the LEADER
+	// elected for its term.
+	ci = ClusterInfo{Name: "foo", Size: 3}
+	_, rpc, log = genNodeArgs(t)
+	scCh2 := make(chan StateChange)
+	node2, err := New(ci, NewChanHandler(scCh2, errCh), rpc, log)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node2.Close()
+
+	expect(wait(t, scCh2), FOLLOWER, CANDIDATE, REASON_ELECTION_TIMEOUT)
+	node2.HeartBeats <- &pb.Heartbeat{Term: node2.CurrentTerm(), Leader: "other"}
+	expect(wait(t, scCh2), CANDIDATE, FOLLOWER, REASON_NEW_LEADER)
+}
+
 // The only real errors right now are log based or RPC.
 func TestErrorHandler(t *testing.T) {
 	ci := ClusterInfo{Name: "foo", Size: 1}
@@ -103,8 +149,8 @@ func TestErrorHandler(t *testing.T) {
 
 	err = errWait(t, errCh)
 
-	perr, ok := err.(*os.PathError)
-	if !ok {
+	var perr *os.PathError
+	if !errors.As(err, &perr) {
 		t.Fatalf("Got wrong error type")
 	}
 	if perr.Op != "open" {
@@ -166,7 +212,7 @@ func TestChandHandlerNotBlockingNode(t *testing.T) {
 		}
 		// This call expects lock to be held on entry
 		node.mu.Lock()
-		node.switchState(to)
+		node.switchState(to, REASON_UNKNOWN)
 		node.mu.Unlock()
 		// This call does not
 		node.handleError(fmt.Errorf("%d", i))
@@ -203,3 +249,597 @@ func TestChandHandlerNotBlockingNode(t *testing.T) {
 		}
 	}
 }
+
+// vetoHandler vetoes leadership while veto is set.
+type vetoHandler struct {
+	dummyHandler
+	veto atomic.Bool
+}
+
+func (h *vetoHandler) CanBecomeLeader() bool {
+	return !h.veto.Load()
+}
+
+func TestLeadershipVetoPassesLeadership(t *testing.T) {
+	ci := ClusterInfo{Name: "veto", Size: 3}
+	nodes := make([]*Node, 3)
+	vetoer := &vetoHandler{}
+	vetoer.veto.Store(true)
+	for i := range nodes {
+		hand, rpc, log := genNodeArgs(t)
+		if i == 0 {
+			hand = vetoer
+		}
+		node, err := New(ci, hand, rpc, log)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		defer node.Close()
+		nodes[i] = node
+	}
+
+	// Make the vetoing node the first one to time out.
+	nodes[0].mu.Lock()
+	nodes[0].electTimer.Reset(time.Millisecond)
+	nodes[0].mu.Unlock()
+
+	expectedClusterState(t, nodes, 1, 2, 0)
+	if leader := findLeader(nodes); leader == nodes[0] {
+		t.Fatalf("Expected the vetoing node not to be the leader")
+	}
+}
+
+func TestLeadershipVetoAfterWinningElection(t *testing.T) {
+	ci := ClusterInfo{Name: "veto", Size: 3}
+	_, rpc, log := genNodeArgs(t)
+	hand := &vetoHandler{}
+	node, err := New(ci, hand, rpc, log)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+
+	// Create fake node to watch VoteRequests.
+	fake := fakeNode("fake")
+	mockRegisterPeer(fake)
+	defer mockUnregisterPeer(fake.id)
+
+	node.mu.Lock()
+	node.electTimer.Reset(time.Millisecond)
+	node.mu.Unlock()
+
+	vreq := <-fake.VoteRequests
+
+	// The precondition is lost during the election.
+	hand.veto.Store(true)
+	node.VoteResponses <- &pb.VoteResponse{Term: vreq.Term, Granted: true}
+
+	if state := waitForState(node, FOLLOWER); state != FOLLOWER {
+		t.Fatalf("Expected Node to step down to Follower, got: %s", state)
+	}
+	if leader := node.Leader(); leader != NO_LEADER {
+		t.Fatalf("Expected no leader, got: %s", leader)
+	}
+}
+
+// lossHandler blocks in OnLostLeadership until released.
+type lossHandler struct {
+	dummyHandler
+	lost    chan uint64
+	release chan struct{}
+}
+
+func (h *lossHandler) OnLostLeadership(term uint64) {
+	h.lost <- term
+	<-h.release
+}
+
+func TestLostLeadershipHandler(t *testing.T) {
+	ci := ClusterInfo{Name: "loss", Size: 3}
+	_, rpc, log := genNodeArgs(t)
+	hand := &lossHandler{lost: make(chan uint64, 1), release: make(chan struct{})}
+	node, err := New(ci, hand, rpc, log)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+
+	// Create fake node to elect the Leader.
+	fake := fakeNode("fake")
+	mockRegisterPeer(fake)
+	defer mockUnregisterPeer(fake.id)
+
+	node.mu.Lock()
+	node.electTimer.Reset(time.Millisecond)
+	node.mu.Unlock()
+
+	vreq := <-fake.VoteRequests
+	node.VoteResponses <- &pb.VoteResponse{Term: vreq.Term, Granted: true}
+	if state := waitForState(node, LEADER); state != LEADER {
+		t.Fatalf("Expected Node to be in Leader state, got: %s", state)
+	}
+
+	// A newer leader makes us step down.
+	node.HeartBeats <- &pb.Heartbeat{Term: vreq.Term + 1, Leader: "other"}
+
+	select {
+	case term := <-hand.lost:
+		if term != vreq.Term {
+			t.Fatalf("Expected lost leadership of term %d, got %d", vreq.Term, term)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting on lost leadership")
+	}
+
+	// The node must not act as a follower until the handler returns.
+	node.VoteRequests <- &pb.VoteRequest{Term: vreq.Term + 2, Candidate: fake.id}
+	select {
+	case <-fake.VoteResponses:
+		t.Fatal("Expected no vote response before the handler returned")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(hand.release)
+	select {
+	case vresp := <-fake.VoteResponses:
+		if !vresp.Granted {
+			t.Fatalf("Expected the vote to be granted")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting on vote response")
+	}
+}
+
+// asyncFailRpc is a driver whose connection fails after Init succeeded.
+type asyncFailRpc struct {
+	*MockRpcDriver
+}
+
+func (r *asyncFailRpc) Init(n *Node) error {
+	if err := r.MockRpcDriver.Init(n); err != nil {
+		return err
+	}
+	go n.ReportRPCError("connect", errors.New("connection refused"))
+	return nil
+}
+
+func TestRPCConnectErrorHandler(t *testing.T) {
+	ci := ClusterInfo{Name: "foo", Size: 1}
+	_, _, log := genNodeArgs(t)
+
+	scCh := make(chan StateChange, 1)
+	errCh := make(chan error)
+	chHand := NewChanHandler(scCh, errCh)
+
+	node, err := New(ci, chHand, &asyncFailRpc{NewMockRpc()}, log)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+
+	err = errWait(t, errCh)
+	var rerr *RPCError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("Expected an RPCError, got: %v", err)
+	}
+	if rerr.Kind != KindConnect || rerr.Op != "connect" {
+		t.Fatalf("Expected a %s error for connect, got %s for %s", KindConnect, rerr.Kind, rerr.Op)
+	}
+}
+
+func TestChanHandlerErrorBuffer(t *testing.T) {
+	ci := ClusterInfo{Name: "foo", Size: 1}
+	_, rpc, log := genNodeArgs(t)
+
+	scCh := make(chan StateChange, 8)
+	errCh := make(chan error)
+	chHand := NewChanHandler(scCh, errCh)
+	chHand.SetErrorBuffer(2)
+
+	node, err := New(ci, chHand, rpc, log)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+
+	// Nobody reads the errors.
+	total := 10
+	for i := 0; i < total; i++ {
+		node.handleError(fmt.Errorf("%d", i))
+	}
+
+	// The node's error queue drains, errors beyond the buffer are dropped.
+	deadline := time.Now().Add(time.Second)
+	for chHand.DroppedErrors() != uint64(total-2) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if dropped := chHand.DroppedErrors(); dropped != uint64(total-2) {
+		t.Fatalf("Expected %d dropped errors, got %d", total-2, dropped)
+	}
+	node.mu.Lock()
+	pending := len(node.errors)
+	node.mu.Unlock()
+	if pending != 0 {
+		t.Fatalf("Expected the node's errors to be drained, got %d", pending)
+	}
+
+	// The loops keep running: the single node elects itself.
+	if state := waitForState(node, LEADER); state != LEADER {
+		t.Fatalf("Expected Node to be in Leader state, got: %s", state)
+	}
+
+	// The buffered errors are delivered in order.
+	for i := 0; i < 2; i++ {
+		if err := errWait(t, errCh); err.Error() != strconv.Itoa(i) {
+			t.Fatalf("Expected error %d, got %v", i, err)
+		}
+	}
+}
+
+// tickHandler counts the leader ticks.
+type tickHandler struct {
+	dummyHandler
+	ticks atomic.Int64
+}
+
+func (h *tickHandler) OnLeaderTick() {
+	h.ticks.Add(1)
+}
+
+func TestLeaderTick(t *testing.T) {
+	ci := ClusterInfo{Name: "tick", Size: 3}
+	_, rpc, log := genNodeArgs(t)
+	if _, err := New(ci, &dummyHandler{}, rpc, log, WithLeaderTick(time.Millisecond)); err != ErrLeaderTickerReq {
+		t.Fatalf("Expected %v, got: %v", ErrLeaderTickerReq, err)
+	}
+	hand := &tickHandler{}
+	node, err := New(ci, hand, rpc, log, WithLeaderTick(5*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+
+	// No ticks as a FOLLOWER.
+	time.Sleep(50 * time.Millisecond)
+	if ticks := hand.ticks.Load(); ticks != 0 {
+		t.Fatalf("Expected no ticks as Follower, got %d", ticks)
+	}
+
+	// Create fake node to elect the Leader.
+	fake := fakeNode("fake")
+	mockRegisterPeer(fake)
+	defer mockUnregisterPeer(fake.id)
+
+	node.mu.Lock()
+	node.electTimer.Reset(time.Millisecond)
+	node.mu.Unlock()
+
+	vreq := <-fake.VoteRequests
+	node.VoteResponses <- &pb.VoteResponse{Term: vreq.Term, Granted: true}
+	if state := waitForState(node, LEADER); state != LEADER {
+		t.Fatalf("Expected Node to be in Leader state, got: %s", state)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if ticks := hand.ticks.Load(); ticks == 0 {
+		t.Fatal("Expected ticks as Leader")
+	}
+
+	// Step down, the ticks cease.
+	node.HeartBeats <- &pb.Heartbeat{Term: vreq.Term + 1, Leader: "new"}
+	if state := waitForState(node, FOLLOWER); state != FOLLOWER {
+		t.Fatalf("Expected Node to step down to Follower, got: %s", state)
+	}
+	ticks := hand.ticks.Load()
+	time.Sleep(50 * time.Millisecond)
+	if after := hand.ticks.Load(); after != ticks {
+		t.Fatalf("Expected no ticks after stepping down, got %d more", after-ticks)
+	}
+}
+
+// churnHandler records the churn notifications.
+type churnHandler struct {
+	dummyHandler
+	churns chan int
+}
+
+func (h *churnHandler) OnLeadershipChurn(changes int, window time.Duration) {
+	h.churns <- changes
+}
+
+func TestLeadershipChurn(t *testing.T) {
+	ci := ClusterInfo{Name: "churn", Size: 3}
+	_, rpc, log := genNodeArgs(t)
+	if _, err := New(ci, &dummyHandler{}, rpc, log, WithLeadershipChurnThreshold(3, time.Minute)); err != ErrChurnHandlerReq {
+		t.Fatalf("Expected %v, got: %v", ErrChurnHandlerReq, err)
+	}
+	hand := &churnHandler{churns: make(chan int, 4)}
+	metrics := &counterMetrics{counters: make(map[string]int64)}
+	node, err := New(ci, hand, rpc, log, WithMetrics(metrics),
+		WithLeadershipChurnThreshold(3, time.Minute))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+	// Delay elections
+	node.mu.Lock()
+	node.electTimer.Reset(10 * time.Second)
+	node.mu.Unlock()
+
+	// The leadership flaps between two LEADERs.
+	leaders := []string{"a", "b", "a", "b"}
+	for i, leader := range leaders {
+		node.HeartBeats <- &pb.Heartbeat{Term: uint64(i + 1), Leader: leader}
+		if l := waitForLeader(node, leader); l != leader {
+			t.Fatalf("Expected leader %q, got %q", leader, l)
+		}
+		// The same LEADER is not a change.
+		node.HeartBeats <- &pb.Heartbeat{Term: uint64(i + 1), Leader: leader}
+	}
+
+	if changes := node.RecentLeadershipChanges(time.Minute); changes != len(leaders) {
+		t.Fatalf("Expected %d leadership changes, got %d", len(leaders), changes)
+	}
+	if changes := node.RecentLeadershipChanges(0); changes != 0 {
+		t.Fatalf("Expected no leadership changes in an empty window, got %d", changes)
+	}
+	if c := metrics.counter(METRIC_LEADERSHIP_CHANGES); c != int64(len(leaders)) {
+		t.Fatalf("Expected the leadership changes counter to be %d, got %d", len(leaders), c)
+	}
+
+	// Notified once, when crossing the threshold.
+	select {
+	case changes := <-hand.churns:
+		if changes != 4 {
+			t.Fatalf("Expected 4 changes, got %d", changes)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a churn notification")
+	}
+	select {
+	case changes := <-hand.churns:
+		t.Fatalf("Expected a single churn notification, got another with %d", changes)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestElectionDiagnostics(t *testing.T) {
+	ci := ClusterInfo{Name: "stall", Size: 3}
+	_, rpc, log := genNodeArgs(t)
+	errCh := make(chan error, 4)
+	hand := NewChanHandler(make(chan StateChange, 32), errCh)
+	node, err := New(ci, hand, rpc, log, WithTimeoutStrategy(FixedTimeout(20*time.Millisecond)),
+		WithElectionDiagnostics(3))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+
+	// The peers answer, but voted for someone else.
+	done := make(chan struct{})
+	defer close(done)
+	for _, fake := range []*Node{fakeNode("fake1"), fakeNode("fake2")} {
+		mockRegisterPeer(fake)
+		defer mockUnregisterPeer(fake.id)
+		go func() {
+			for {
+				select {
+				case vreq := <-fake.VoteRequests:
+					select {
+					case node.VoteResponses <- &pb.VoteResponse{Term: vreq.Term, Voter: fake.id}:
+					case <-done:
+						return
+					}
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	var serr *ElectionStallError
+	if err := errWait(t, errCh); !errors.As(err, &serr) {
+		t.Fatalf("Expected an ElectionStallError, got: %v", err)
+	}
+	if !errors.Is(serr, ErrElectionStalled) {
+		t.Fatalf("Expected the error to wrap %v", ErrElectionStalled)
+	}
+	if serr.Campaigns != 3 || serr.Quorum != 2 || serr.MaxVotes != 1 || serr.MaxResponders != 2 {
+		t.Fatalf("Unexpected tally: %+v", serr)
+	}
+	if serr.Cause != StallSplitVotes {
+		t.Fatalf("Expected %v, got %v", StallSplitVotes, serr.Cause)
+	}
+
+	// Reported once per stall.
+	select {
+	case err := <-errCh:
+		t.Fatalf("Expected a single report, got: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// However long the stall, only the last tallies are kept.
+	node.mu.Lock()
+	count, tallies := node.failedCount, len(node.failedCampaigns)
+	node.mu.Unlock()
+	if count <= 3 || tallies != 3 {
+		t.Fatalf("Expected the last 3 tallies of more campaigns, got %d of %d", tallies, count)
+	}
+}
+
+// quorumHandler records the quorum heartbeats.
+type quorumHandler struct {
+	dummyHandler
+	count atomic.Int64
+	term  atomic.Uint64
+}
+
+func (h *quorumHandler) OnQuorumHeartbeat(term uint64, at time.Time) {
+	h.term.Store(term)
+	h.count.Add(1)
+}
+
+func TestQuorumHeartbeat(t *testing.T) {
+	ci := ClusterInfo{Name: "quorumhb", Size: 3}
+	nodes := make([]*Node, 3)
+	hands := make(map[*Node]*quorumHandler)
+	for i := range nodes {
+		_, rpc, log := genNodeArgs(t)
+		hand := &quorumHandler{}
+		node, err := New(ci, hand, rpc, log, WithHeartbeatInterval(5*time.Millisecond, 10, 20))
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		defer node.Close()
+		nodes[i] = node
+		hands[node] = hand
+	}
+
+	var leader *Node
+	deadline := time.Now().Add(3 * time.Second)
+	for leader == nil && time.Now().Before(deadline) {
+		for _, n := range nodes {
+			if n.State() == LEADER {
+				leader = n
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if leader == nil {
+		t.Fatal("Expected a LEADER to be elected")
+	}
+	hand := hands[leader]
+	time.Sleep(50 * time.Millisecond)
+	if hand.count.Load() == 0 {
+		t.Fatal("Expected quorum heartbeats")
+	}
+	if term := hand.term.Load(); term != leader.CurrentTerm() {
+		t.Fatalf("Expected term %d, got %d", leader.CurrentTerm(), term)
+	}
+
+	// Cut off from the majority, the LEADER no longer gets them.
+	for _, n := range nodes {
+		if n != leader {
+			mockBlockLink(leader, n)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	count := hand.count.Load()
+	time.Sleep(50 * time.Millisecond)
+	if after := hand.count.Load(); after != count {
+		t.Fatalf("Expected no quorum heartbeats, got %d more", after-count)
+	}
+}
+
+// grantHook aborts the first grants.
+type termJump struct {
+	from, to uint64
+	source   string
+}
+
+type jumpHandler struct {
+	dummyHandler
+	jumps chan termJump
+}
+
+func (h *jumpHandler) OnTermJump(from, to uint64, source string) {
+	h.jumps <- termJump{from, to, source}
+}
+
+func TestTermJumpHandler(t *testing.T) {
+	ci := ClusterInfo{Name: "jump", Size: 3}
+	hand := &jumpHandler{jumps: make(chan termJump, 16)}
+	_, rpc, log := genNodeArgs(t)
+	node, err := New(ci, hand, rpc, log)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+	// Delay elections
+	node.mu.Lock()
+	node.electTimer.Reset(10 * time.Second)
+	node.mu.Unlock()
+
+	expectJump := func(expected termJump) {
+		t.Helper()
+		select {
+		case jump := <-hand.jumps:
+			if jump != expected {
+				t.Fatalf("Expected term jump %+v, got %+v", expected, jump)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected term jump %+v", expected)
+		}
+	}
+
+	// Adopting the term of a LEADER's heartbeat.
+	node.HeartBeats <- &pb.Heartbeat{Term: 3, Leader: "a"}
+	expectJump(termJump{0, 3, "a"})
+	// The same term is not a jump.
+	node.HeartBeats <- &pb.Heartbeat{Term: 3, Leader: "a"}
+	if leader := waitForLeader(node, "a"); leader != "a" {
+		t.Fatalf("Expected leader %q, got %q", "a", leader)
+	}
+
+	// Adopting the term of a candidate.
+	node.VoteRequests <- &pb.VoteRequest{Term: 5, Candidate: "c"}
+	expectJump(termJump{3, 5, "c"})
+
+	// Campaigning.
+	node.mu.Lock()
+	node.electTimer.Reset(time.Millisecond)
+	node.mu.Unlock()
+	expectJump(termJump{5, 6, node.id})
+}
+
+type grantHook struct {
+	dummyHandler
+	aborts atomic.Int64
+	calls  atomic.Int64
+}
+
+func (h *grantHook) BeforeGrantVote(term uint64, candidate string) error {
+	h.calls.Add(1)
+	if h.aborts.Add(-1) >= 0 {
+		return errors.New("quiescing")
+	}
+	return nil
+}
+
+func TestVoteGrantHook(t *testing.T) {
+	ci := ClusterInfo{Name: "grant", Size: 3}
+	_, rpc, log := genNodeArgs(t)
+	hand := &grantHook{}
+	hand.aborts.Store(1)
+	node, err := New(ci, hand, rpc, log)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+	// Delay elections
+	node.mu.Lock()
+	node.electTimer.Reset(10 * time.Second)
+	node.mu.Unlock()
+
+	fake := fakeNode("fake")
+	mockRegisterPeer(fake)
+	defer mockUnregisterPeer(fake.id)
+
+	// The hook aborts the grant, after the vote was recorded.
+	node.VoteRequests <- &pb.VoteRequest{Term: 1, Candidate: fake.id}
+	if vresp := <-fake.VoteResponses; vresp.Granted {
+		t.Fatal("Expected the VoteResponse to have Granted of false")
+	}
+	if calls := hand.calls.Load(); calls != 1 {
+		t.Fatalf("Expected the hook to be called once, got %d", calls)
+	}
+	if vote := node.CurrentVote(); vote != fake.id {
+		t.Fatalf("Expected the vote for %s to be recorded, got %q", fake.id, vote)
+	}
+	testStateOfNode(t, node)
+
+	// The next request is granted.
+	node.VoteRequests <- &pb.VoteRequest{Term: 1, Candidate: fake.id}
+	if vresp := <-fake.VoteResponses; !vresp.Granted {
+		t.Fatal("Expected the VoteResponse to have been Granted")
+	}
+}
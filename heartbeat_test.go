@@ -14,7 +14,12 @@
 package graft
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"os"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -307,3 +312,681 @@ func TestHeartBeatAsLeaderErrorOnWrite(t *testing.T) {
 	// Reset the permission
 	os.Chmod(node.logPath, 0660)
 }
+
+func TestCheckQuorumGracePeriod(t *testing.T) {
+	ci := ClusterInfo{Name: "cq", Size: 3}
+	hand, rpc, log := genNodeArgs(t)
+	grace := 5 * HEARTBEAT_INTERVAL
+	node, err := New(ci, hand, rpc, log, WithCheckQuorum(), WithQuorumLossGracePeriod(grace))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+
+	// Create fake node to elect the Leader and ack its heartbeats.
+	fake := fakeNode("fake")
+	fake.HeartBeats = make(chan *pb.Heartbeat, 32)
+
+	// Hook up to MockRPC layer
+	mockRegisterPeer(fake)
+	defer mockUnregisterPeer(fake.id)
+
+	node.mu.Lock()
+	node.electTimer.Reset(1 * time.Millisecond)
+	node.mu.Unlock()
+
+	vreq := <-fake.VoteRequests
+
+	// Send Fake VoteResponse to promote node to Leader
+	node.VoteResponses <- &pb.VoteResponse{Term: vreq.Term, Granted: true}
+
+	if state := waitForState(node, LEADER); state != LEADER {
+		t.Fatalf("Expected Node to be in Leader state, got: %s", state)
+	}
+
+	// Acknowledge the heartbeats unless asked to drop them.
+	var drop atomic.Bool
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case hb := <-fake.HeartBeats:
+				if drop.Load() {
+					continue
+				}
+				select {
+				case node.HeartBeatResponses <- &pb.HeartbeatResponse{Term: hb.Term, Follower: fake.id}:
+				case <-done:
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// Dropping the acks for less than the grace period is tolerated.
+	time.Sleep(3 * HEARTBEAT_INTERVAL)
+	drop.Store(true)
+	time.Sleep(grace / 2)
+	drop.Store(false)
+	time.Sleep(3 * HEARTBEAT_INTERVAL)
+
+	if state := node.State(); state != LEADER {
+		t.Fatalf("Expected Node to still be in Leader state, got: %s", state)
+	}
+
+	// Dropping them past the grace period makes the leader step down.
+	drop.Store(true)
+	end := time.Now().Add(2 * grace)
+	for node.State() == LEADER && time.Now().Before(end) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if state := node.State(); state == LEADER {
+		t.Fatalf("Expected Node to have stepped down, got: %s", state)
+	}
+}
+
+func TestCheckQuorumRequiresHeartbeatResponder(t *testing.T) {
+	ci := ClusterInfo{Name: "cq", Size: 3}
+	hand, _, log := genNodeArgs(t)
+	rpc := &noResponseRpc{NewMockRpc()}
+	if _, err := New(ci, hand, rpc, log, WithCheckQuorum()); err != ErrHeartbeatResponseReq {
+		t.Fatalf("Expected %v, got: %v", ErrHeartbeatResponseReq, err)
+	}
+	if _, err := New(ci, hand, rpc, log, WithQuorumLossGracePeriod(-1)); err != ErrInvalidOption {
+		t.Fatalf("Expected %v, got: %v", ErrInvalidOption, err)
+	}
+}
+
+// noResponseRpc hides the HeartbeatResponder support of the MockRpcDriver.
+type noResponseRpc struct {
+	mock *MockRpcDriver
+}
+
+func (r *noResponseRpc) Init(n *Node) error { return r.mock.Init(n) }
+func (r *noResponseRpc) Close()             { r.mock.Close() }
+func (r *noResponseRpc) SendVoteResponse(candidate string, vresp *pb.VoteResponse) error {
+	return r.mock.SendVoteResponse(candidate, vresp)
+}
+func (r *noResponseRpc) RequestVote(vr *pb.VoteRequest) error { return r.mock.RequestVote(vr) }
+func (r *noResponseRpc) HeartBeat(hb *pb.Heartbeat) error     { return r.mock.HeartBeat(hb) }
+
+// latencyMetrics records the peer latencies reported to the metrics hook.
+type latencyMetrics struct {
+	nopMetrics
+	mu        sync.Mutex
+	latencies map[string]time.Duration
+}
+
+func (m *latencyMetrics) ObserveDuration(name string, d time.Duration, labels ...Label) {
+	if name != METRIC_PEER_LATENCY || len(labels) != 1 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.latencies[labels[0].Value] = d
+}
+
+func (m *latencyMetrics) latency(peer string) time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.latencies[peer]
+}
+
+func TestPeerLatencies(t *testing.T) {
+	ci := ClusterInfo{Name: "latency", Size: 3}
+	metrics := &latencyMetrics{latencies: make(map[string]time.Duration)}
+	nodes := make([]*Node, 3)
+	for i := range nodes {
+		hand, rpc, log := genNodeArgs(t)
+		node, err := New(ci, hand, rpc, log, WithMetrics(metrics))
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		defer node.Close()
+		nodes[i] = node
+	}
+	expectedClusterState(t, nodes, 1, 2, 0)
+
+	leader := findLeader(nodes)
+	var fast, slow *Node
+	for _, n := range nodes {
+		if n == leader {
+			continue
+		}
+		if fast == nil {
+			fast = n
+		} else {
+			slow = n
+		}
+	}
+
+	// Slow down the responses of one follower.
+	delay := HEARTBEAT_INTERVAL / 2
+	slow.rpc.(*MockRpcDriver).setHeartbeatResponseDelay(delay)
+	time.Sleep(5 * HEARTBEAT_INTERVAL)
+
+	latencies := leader.PeerLatencies()
+	if _, ok := latencies[fast.id]; !ok {
+		t.Fatalf("Expected a latency for %s, got %v", fast.id, latencies)
+	}
+	if latencies[slow.id] < delay {
+		t.Fatalf("Expected a latency of at least %v for %s, got %v", delay, slow.id, latencies[slow.id])
+	}
+	if latencies[slow.id] <= latencies[fast.id] {
+		t.Fatalf("Expected the slow peer latency %v to be higher than %v",
+			latencies[slow.id], latencies[fast.id])
+	}
+	if l := metrics.latency(slow.id); l < delay {
+		t.Fatalf("Expected the metrics hook to receive a latency of at least %v, got %v", delay, l)
+	}
+
+	// Followers do not measure latencies.
+	if l := fast.PeerLatencies(); len(l) != 0 {
+		t.Fatalf("Expected no latencies on a follower, got %v", l)
+	}
+}
+
+func TestPeerStateBounded(t *testing.T) {
+	ci := ClusterInfo{Name: "bounded", Size: 3}
+	hand, rpc, log := genNodeArgs(t)
+	clock := NewFakeClock(time.Unix(0, 0))
+	node, err := New(ci, hand, rpc, log, WithClock(clock), WithPeerStateRetention(time.Minute))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+	// Track latencies as a LEADER does.
+	node.mu.Lock()
+	node.latencies = make(map[string]peerLatency)
+	node.mu.Unlock()
+
+	tracked := func() (int, int) {
+		node.mu.Lock()
+		defer node.mu.Unlock()
+		return len(node.latencies), len(node.peerErrors)
+	}
+
+	// Many transient peers show up on the transport.
+	errUnreachable := errors.New("unreachable")
+	transient := 5 * maxTrackedPeers
+	for i := 0; i < transient; i++ {
+		peer := fmt.Sprintf("ghost-%d", i)
+		node.recordLatency(peer, time.Millisecond)
+		node.ReportPeerError(peer, errUnreachable)
+		clock.Advance(time.Microsecond)
+	}
+	if l, e := tracked(); l != maxTrackedPeers || e != maxTrackedPeers {
+		t.Fatalf("Expected %d tracked peers, got %d latencies and %d errors", maxTrackedPeers, l, e)
+	}
+	// The least recently seen are pruned first.
+	if err, _ := node.LastPeerError("ghost-0"); err != nil {
+		t.Fatalf("Expected the oldest peer to be pruned, got: %v", err)
+	}
+	last := fmt.Sprintf("ghost-%d", transient-1)
+	if err, _ := node.LastPeerError(last); err != errUnreachable {
+		t.Fatalf("Expected %v for the most recent peer, got: %v", errUnreachable, err)
+	}
+
+	// Peers not heard from within the window are pruned.
+	clock.Advance(2 * time.Minute)
+	if err, at := node.LastPeerError(last); err != nil || !at.IsZero() {
+		t.Fatalf("Expected the stale peer to be forgotten, got: %v at %v", err, at)
+	}
+	node.recordLatency("member", time.Millisecond)
+	node.ReportPeerError("member", errUnreachable)
+	if l, e := tracked(); l != 1 || e != 1 {
+		t.Fatalf("Expected 1 tracked peer, got %d latencies and %d errors", l, e)
+	}
+
+	if _, err := New(ci, hand, rpc, log, WithPeerStateRetention(0)); err != ErrInvalidOption {
+		t.Fatalf("Expected %v, got: %v", ErrInvalidOption, err)
+	}
+}
+
+func TestLeaderMetadata(t *testing.T) {
+	nodes := createNodes(t, "metadata", 3)
+	for _, n := range nodes {
+		defer n.Close()
+	}
+	expectedClusterState(t, nodes, 1, 2, 0)
+
+	leader := findLeader(nodes)
+	follower := firstFollower(nodes)
+
+	if err := leader.SetAdvertised(make([]byte, MAX_ADVERTISED_SIZE+1)); err != ErrAdvertisedTooLarge {
+		t.Fatalf("Expected %v, got: %v", ErrAdvertisedTooLarge, err)
+	}
+
+	addr := []byte("nats://10.0.0.1:4222")
+	if err := leader.SetAdvertised(addr); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if md := leader.LeaderMetadata(); !bytes.Equal(md, addr) {
+		t.Fatalf("Expected the leader to return its metadata %q, got %q", addr, md)
+	}
+
+	// Wait for the next heartbeats.
+	time.Sleep(2 * HEARTBEAT_INTERVAL)
+	if md := follower.LeaderMetadata(); !bytes.Equal(md, addr) {
+		t.Fatalf("Expected the follower to have metadata %q, got %q", addr, md)
+	}
+}
+
+func TestHeartbeatTimeoutWithFakeClock(t *testing.T) {
+	ci := ClusterInfo{Name: "clock", Size: 3}
+	hand, rpc, log := genNodeArgs(t)
+	clock := NewFakeClock(time.Unix(0, 0))
+	timeout := FixedTimeout(MIN_ELECTION_TIMEOUT)
+	node, err := New(ci, hand, rpc, log, WithClock(clock), WithTimeoutStrategy(timeout))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+
+	// The fake acts as the leader.
+	fake := fakeNode("leader")
+	fake.HeartBeatResponses = make(chan *pb.HeartbeatResponse, 1)
+	mockRegisterPeer(fake)
+	defer mockUnregisterPeer(fake.id)
+
+	expectCampaign := func(expected bool) {
+		t.Helper()
+		wait := 50 * time.Millisecond
+		if expected {
+			wait = time.Second
+		}
+		select {
+		case <-fake.VoteRequests:
+			if !expected {
+				t.Fatalf("Expected no campaign at %v", clock.Now().Sub(time.Unix(0, 0)))
+			}
+		case <-time.After(wait):
+			if expected {
+				t.Fatalf("Expected a campaign at %v", clock.Now().Sub(time.Unix(0, 0)))
+			}
+		}
+	}
+
+	// A heartbeat before the timeout restarts it.
+	clock.Advance(MIN_ELECTION_TIMEOUT - 100*time.Millisecond)
+	node.HeartBeats <- &pb.Heartbeat{Term: 1, Leader: fake.id}
+	<-fake.HeartBeatResponses
+
+	// The leader missed its heartbeats, campaign exactly at the timeout.
+	clock.Advance(MIN_ELECTION_TIMEOUT - time.Millisecond)
+	expectCampaign(false)
+	if state := node.State(); state != FOLLOWER {
+		t.Fatalf("Expected Node to be in Follower state, got: %s", state)
+	}
+	clock.Advance(time.Millisecond)
+	expectCampaign(true)
+	if state := waitForState(node, CANDIDATE); state != CANDIDATE {
+		t.Fatalf("Expected Node to be in Candidate state, got: %s", state)
+	}
+}
+
+func TestHeartbeatFloodFromNonLeader(t *testing.T) {
+	ci := ClusterInfo{Name: "flood", Size: 3}
+	hand, rpc, log := genNodeArgs(t)
+	clock := NewFakeClock(time.Unix(0, 0))
+	timeout := FixedTimeout(MIN_ELECTION_TIMEOUT)
+	node, err := New(ci, hand, rpc, log, WithClock(clock), WithTimeoutStrategy(timeout))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+
+	leader := fakeNode("leader")
+	leader.HeartBeatResponses = make(chan *pb.HeartbeatResponse, 1)
+	mockRegisterPeer(leader)
+	defer mockUnregisterPeer(leader.id)
+	spammer := fakeNode("spammer")
+	spammer.HeartBeatResponses = make(chan *pb.HeartbeatResponse, 1)
+	mockRegisterPeer(spammer)
+	defer mockUnregisterPeer(spammer.id)
+
+	node.HeartBeats <- &pb.Heartbeat{Term: 1, Leader: leader.id}
+	<-leader.HeartBeatResponses
+	if l := node.Leader(); l != leader.id {
+		t.Fatalf("Expected leader %q, got %q", leader.id, l)
+	}
+
+	// The LEADER goes silent while another peer floods heartbeats for
+	// the same term.
+	for elapsed := time.Duration(0); elapsed < MIN_ELECTION_TIMEOUT-HEARTBEAT_INTERVAL; elapsed += HEARTBEAT_INTERVAL {
+		clock.Advance(HEARTBEAT_INTERVAL)
+		node.HeartBeats <- &pb.Heartbeat{Term: 1, Leader: spammer.id}
+		<-spammer.HeartBeatResponses
+	}
+	if l := node.Leader(); l != leader.id {
+		t.Fatalf("Expected leader %q, got %q", leader.id, l)
+	}
+	clock.Advance(HEARTBEAT_INTERVAL)
+	select {
+	case vreq := <-spammer.VoteRequests:
+		if vreq.Term != 2 {
+			t.Fatalf("Expected a campaign for term 2, got %d", vreq.Term)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a campaign once the LEADER went silent")
+	}
+}
+
+func TestMaxMissedHeartbeats(t *testing.T) {
+	ci := ClusterInfo{Name: "missed", Size: 3}
+	newNode := func(opts ...Option) *Node {
+		t.Helper()
+		hand, rpc, log := genNodeArgs(t)
+		opts = append(opts, WithHeartbeatInterval(5*time.Millisecond, 10, 20))
+		node, err := New(ci, hand, rpc, log, opts...)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		return node
+	}
+	// Tolerates gaps of up to 40 heartbeat intervals, i.e. 200ms,
+	// longer than its election timeouts of 50 to 100ms.
+	tolerant := newNode(WithMaxMissedHeartbeats(40))
+	defer tolerant.Close()
+	node := newNode()
+	defer node.Close()
+	mockBlockLink(tolerant, node)
+
+	// Sporadic heartbeats, with gaps longer than the election timeouts.
+	for i := 0; i < 6; i++ {
+		for _, n := range []*Node{tolerant, node} {
+			if n.State() == FOLLOWER {
+				n.HeartBeats <- &pb.Heartbeat{Term: 1, Leader: "leader"}
+			}
+		}
+		time.Sleep(150 * time.Millisecond)
+		if state := tolerant.State(); state != FOLLOWER {
+			t.Fatalf("Expected Node to stay a Follower, got: %s", state)
+		}
+	}
+	if state := node.State(); state == FOLLOWER {
+		t.Fatal("Expected Node without the option to campaign")
+	}
+
+	// Granting its vote restarts the count too.
+	for term := uint64(2); term < 5; term++ {
+		tolerant.VoteRequests <- &pb.VoteRequest{Term: term, Candidate: "candidate"}
+		time.Sleep(150 * time.Millisecond)
+		if state := tolerant.State(); state != FOLLOWER {
+			t.Fatalf("Expected Node to stay a Follower, got: %s", state)
+		}
+	}
+
+	// Without heartbeats, it still campaigns.
+	if state := waitForState(tolerant, CANDIDATE); state != CANDIDATE {
+		t.Fatalf("Expected Node to campaign, got: %s", state)
+	}
+
+	hand, rpc, log := genNodeArgs(t)
+	if _, err := New(ci, hand, rpc, log, WithMaxMissedHeartbeats(0)); err != ErrInvalidOption {
+		t.Fatalf("Expected %v, got: %v", ErrInvalidOption, err)
+	}
+}
+
+func TestMaxMissedHeartbeatsBeforeTimeout(t *testing.T) {
+	ci := ClusterInfo{Name: "missed_clock", Size: 3}
+	hand, rpc, log := genNodeArgs(t)
+	clock := NewFakeClock(time.Unix(0, 0))
+	// 3 missed intervals, well before the election timeout.
+	node, err := New(ci, hand, rpc, log, WithClock(clock),
+		WithTimeoutStrategy(FixedTimeout(MIN_ELECTION_TIMEOUT)), WithMaxMissedHeartbeats(3))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+
+	fake := fakeNode("leader")
+	fake.HeartBeatResponses = make(chan *pb.HeartbeatResponse, 1)
+	mockRegisterPeer(fake)
+	defer mockUnregisterPeer(fake.id)
+
+	heartbeat := func() {
+		node.HeartBeats <- &pb.Heartbeat{Term: 1, Leader: fake.id}
+		<-fake.HeartBeatResponses
+	}
+	// Advance one heartbeat interval and let the node process it.
+	tick := func() {
+		clock.Advance(HEARTBEAT_INTERVAL)
+		for deadline := time.Now().Add(time.Second); !clock.idle(); {
+			if time.Now().After(deadline) {
+				t.Fatal("Expected the node to receive its ticks")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	expectCampaign := func(expected bool) {
+		t.Helper()
+		wait := 50 * time.Millisecond
+		if expected {
+			wait = time.Second
+		}
+		select {
+		case <-fake.VoteRequests:
+			if !expected {
+				t.Fatalf("Expected no campaign at %v", clock.Now().Sub(time.Unix(0, 0)))
+			}
+		case <-time.After(wait):
+			if expected {
+				t.Fatalf("Expected a campaign at %v", clock.Now().Sub(time.Unix(0, 0)))
+			}
+		}
+	}
+
+	// A heartbeat every other interval, past the election timeout.
+	heartbeat()
+	for i := 0; i < 4*int(MIN_ELECTION_TIMEOUT/HEARTBEAT_INTERVAL); i++ {
+		tick()
+		if i%2 == 1 {
+			heartbeat()
+		}
+	}
+	expectCampaign(false)
+	if state := node.State(); state != FOLLOWER {
+		t.Fatalf("Expected Node to stay a Follower, got: %s", state)
+	}
+
+	// The interval of the last heartbeat, then 2 missed ones, and the
+	// third one starts the campaign.
+	for i := 0; i < 3; i++ {
+		tick()
+	}
+	expectCampaign(false)
+	tick()
+	expectCampaign(true)
+	if state := waitForState(node, CANDIDATE); state != CANDIDATE {
+		t.Fatalf("Expected Node to be in Candidate state, got: %s", state)
+	}
+}
+
+func TestMaxHeartbeatSize(t *testing.T) {
+	ci := ClusterInfo{Name: "hbsize", Size: 3}
+	_, rpc, log := genNodeArgs(t)
+	errCh := make(chan error, 8)
+	hand := NewChanHandler(make(chan StateChange, 8), errCh)
+	node, err := New(ci, hand, rpc, log, WithMaxHeartbeatSize(128))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+	if err := node.SetAdvertised(make([]byte, 512)); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	// The fake elects the node and receives its heartbeats.
+	fake := fakeNode("fake")
+	fake.HeartBeats = make(chan *pb.Heartbeat, 64)
+	mockRegisterPeer(fake)
+	defer mockUnregisterPeer(fake.id)
+	node.mu.Lock()
+	node.electTimer.Reset(time.Millisecond)
+	node.mu.Unlock()
+	vreq := <-fake.VoteRequests
+	node.VoteResponses <- &pb.VoteResponse{Term: vreq.Term, Granted: true}
+
+	nextHeartbeat := func() *pb.Heartbeat {
+		t.Helper()
+		select {
+		case hb := <-fake.HeartBeats:
+			return hb
+		case <-time.After(time.Second):
+			t.Fatal("Expected a heartbeat")
+		}
+		return nil
+	}
+	for i := 0; i < 3; i++ {
+		if hb := nextHeartbeat(); len(hb.Metadata) != 0 {
+			t.Fatalf("Expected the oversized metadata to be dropped, got %d bytes", len(hb.Metadata))
+		}
+	}
+	// Warned once.
+	if err := errWait(t, errCh); !errors.Is(err, ErrHeartbeatTooLarge) {
+		t.Fatalf("Expected %v, got: %v", ErrHeartbeatTooLarge, err)
+	}
+	select {
+	case err := <-errCh:
+		t.Fatalf("Expected a single warning, got: %v", err)
+	default:
+	}
+
+	// Metadata within the budget is sent.
+	addr := []byte("nats://10.0.0.1:4222")
+	if err := node.SetAdvertised(addr); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	for len(fake.HeartBeats) > 0 {
+		<-fake.HeartBeats
+	}
+	nextHeartbeat()
+	if hb := nextHeartbeat(); !bytes.Equal(hb.Metadata, addr) {
+		t.Fatalf("Expected metadata %q, got %q", addr, hb.Metadata)
+	}
+
+	if _, err := New(ci, hand, rpc, log, WithMaxHeartbeatSize(0)); err != ErrInvalidOption {
+		t.Fatalf("Expected %v, got: %v", ErrInvalidOption, err)
+	}
+}
+
+func TestExtendLeadership(t *testing.T) {
+	ci := ClusterInfo{Name: "extend", Size: 3}
+	interceptors := make(map[*Node]*dropHeartbeats)
+	nodes := make([]*Node, 3)
+	for i := range nodes {
+		hand, rpc, log := genNodeArgs(t)
+		interceptor := &dropHeartbeats{}
+		node, err := New(ci, hand, rpc, log,
+			WithHeartbeatInterval(5*time.Millisecond, 10, 20), WithRpcInterceptor(interceptor))
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		defer node.Close()
+		nodes[i] = node
+		interceptors[node] = interceptor
+	}
+	expectedClusterState(t, nodes, 1, 2, 0)
+	leader := findLeader(nodes)
+	follower := firstFollower(nodes)
+	term := leader.CurrentTerm()
+
+	if err := follower.ExtendLeadership(time.Second); err != ErrNotLeader {
+		t.Fatalf("Expected %v, got: %v", ErrNotLeader, err)
+	}
+	// Capped to 250ms.
+	if err := leader.ExtendLeadership(time.Minute); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	// Let the followers hear about it, then stall the heartbeats for
+	// longer than the election timeouts.
+	time.Sleep(20 * time.Millisecond)
+	interceptors[leader].enabled.Store(true)
+	time.Sleep(150 * time.Millisecond)
+	for _, n := range nodes {
+		if cur := n.CurrentTerm(); cur != term {
+			t.Fatalf("Expected no campaign during the extension, got term %d", cur)
+		}
+	}
+	if state := leader.State(); state != LEADER {
+		t.Fatalf("Expected Node to stay Leader, got: %s", state)
+	}
+
+	// Once it ends, the stalled LEADER is replaced.
+	if got := waitForTerm(follower, term+1); got <= term {
+		t.Fatalf("Expected a campaign past term %d, got %d", term, got)
+	}
+}
+
+// currentHandler records when its node becomes current.
+type currentHandler struct {
+	dummyHandler
+	current chan uint64
+}
+
+func (h *currentHandler) OnCurrent(term uint64, leader string) {
+	h.current <- term
+}
+
+func TestIsCurrentAfterRestart(t *testing.T) {
+	// A node restarting from a stale term.
+	ci := ClusterInfo{Name: "current", Size: 3}
+	_, rpc, log := genNodeArgs(t)
+	stale := &Node{opts: defaultOptions(), info: ci, logPath: log, term: 2}
+	if err := stale.writeState(); err != nil {
+		t.Fatalf("Unexpected error writing state: %v", err)
+	}
+	hand := &currentHandler{current: make(chan uint64, 4)}
+	node, err := New(ci, hand, rpc, log)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+	// Delay elections
+	node.mu.Lock()
+	node.electTimer.Reset(10 * time.Second)
+	node.mu.Unlock()
+	if node.CurrentTerm() != 2 || node.IsCurrent() {
+		t.Fatalf("Expected a stale node at term 2, got term %d and current %v", node.CurrentTerm(), node.IsCurrent())
+	}
+
+	fake := fakeNode("fake")
+	fake.HeartBeatResponses = make(chan *pb.HeartbeatResponse, 1)
+	mockRegisterPeer(fake)
+	defer mockUnregisterPeer(fake.id)
+
+	// The LEADER's heartbeat brings it to the current term.
+	node.HeartBeats <- &pb.Heartbeat{Term: 5, Leader: fake.id}
+	<-fake.HeartBeatResponses
+	if node.CurrentTerm() != 5 || !node.IsCurrent() {
+		t.Fatalf("Expected a current node at term 5, got term %d and current %v", node.CurrentTerm(), node.IsCurrent())
+	}
+	select {
+	case term := <-hand.current:
+		if term != 5 {
+			t.Fatalf("Expected to become current at term 5, got %d", term)
+		}
+	default:
+		t.Fatal("Expected to be notified of becoming current")
+	}
+
+	// Notified once per term.
+	node.HeartBeats <- &pb.Heartbeat{Term: 5, Leader: fake.id}
+	<-fake.HeartBeatResponses
+	if len(hand.current) != 0 {
+		t.Fatalf("Expected a single notification, got %d more", len(hand.current))
+	}
+
+	// A campaign starts a term we know no LEADER of.
+	node.mu.Lock()
+	node.electTimer.Reset(time.Millisecond)
+	node.mu.Unlock()
+	<-fake.VoteRequests
+	if node.IsCurrent() {
+		t.Fatal("Expected a CANDIDATE not to be current")
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// StateRecord is a single entry of the state history.
+type StateRecord struct {
+	Term uint64
+	Vote string
+	Time time.Time
+
+	// Line is the 1-based line of the record in the history file.
+	Line int
+	// Corrupt is set when the record could not be decoded. Only Line
+	// is valid for a corrupt record.
+	Corrupt bool
+}
+
+// historyRecord is the persisted form of a StateRecord.
+type historyRecord struct {
+	Term uint64
+	Vote string
+	Time time.Time
+}
+
+// appendHistory appends the given state to the history file. Each line
+// holds an envelope so records can be verified independently.
+// Must be called with n.wmu held.
+func (n *Node) appendHistory(path string, ps PersistentState) error {
+	buf, err := json.Marshal(historyRecord{
+		Term: ps.CurrentTerm,
+		Vote: ps.VotedFor,
+		Time: time.Now().UTC(),
+	})
+	if err != nil {
+		return newLogError("append", path, err)
+	}
+	sha := sha1.Sum(buf)
+	line, err := json.Marshal(envelope{SHA: sha[:], Data: buf})
+	if err != nil {
+		return newLogError("append", path, err)
+	}
+	line = append(line, '\n')
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0660)
+	if err != nil {
+		return newLogError("append", path, err)
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return newLogError("append", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return newLogError("append", path, err)
+	}
+
+	// Compact once we appended as many records as we retain, which
+	// bounds the file to twice the retention.
+	if keep := n.opts.historyRetention; keep > 0 {
+		n.historyAppends++
+		if n.historyAppends >= keep {
+			n.historyAppends = 0
+			return CompactStateHistory(path, keep)
+		}
+	}
+	return nil
+}
+
+// CompactStateHistory rewrites the state history file at path with only
+// its last keep valid records, at least one, dropping older and corrupt
+// ones. The file is replaced atomically, so a crash leaves either the
+// old or the compacted history, and the latest record is never lost.
+// If the history is on another filesystem than its directory, e.g. bind
+// mounted, it is rewritten in place instead, and a crash can tear it.
+func CompactStateHistory(path string, keep int) error {
+	keep = max(keep, 1)
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return newLogError("compact", path, err)
+	}
+	var lines [][]byte
+	for _, line := range bytes.Split(buf, []byte("\n")) {
+		if _, ok := decodeHistoryRecord(line); ok {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) > keep {
+		lines = lines[len(lines)-keep:]
+	}
+	var out []byte
+	for _, line := range lines {
+		out = append(out, line...)
+		out = append(out, '\n')
+	}
+
+	if _, err := writeFileAtomic(path, out, 0660); err != nil {
+		return newLogError("compact", path, err)
+	}
+	return nil
+}
+
+// ReadStateHistory returns the records of the state history file at
+// path, in the order they were written. Records that fail to decode or
+// do not match their digest are returned with Corrupt set, so gaps in
+// the timeline remain visible.
+func ReadStateHistory(path string) ([]StateRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, newLogError("read", path, err)
+	}
+	defer f.Close()
+
+	var records []StateRecord
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		rec, ok := decodeHistoryRecord(scanner.Bytes())
+		if !ok {
+			records = append(records, StateRecord{Line: line, Corrupt: true})
+			continue
+		}
+		records = append(records, StateRecord{
+			Term: rec.Term,
+			Vote: rec.Vote,
+			Time: rec.Time,
+			Line: line,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return records, newLogError("read", path, err)
+	}
+	return records, nil
+}
+
+func decodeHistoryRecord(buf []byte) (*historyRecord, bool) {
+	env := &envelope{}
+	if err := json.Unmarshal(buf, env); err != nil {
+		return nil, false
+	}
+	sha := sha1.Sum(env.Data)
+	if !bytes.Equal(sha[:], env.SHA) {
+		return nil, false
+	}
+	rec := &historyRecord{}
+	if err := json.Unmarshal(env.Data, rec); err != nil {
+		return nil, false
+	}
+	return rec, true
+}
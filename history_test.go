@@ -0,0 +1,201 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStateHistory(t *testing.T) {
+	_, _, log := genNodeArgs(t)
+	history := filepath.Join(t.TempDir(), "history")
+
+	node := &Node{logPath: log, opts: options{historyPath: history, metrics: nopMetrics{}}}
+	states := []struct {
+		term uint64
+		vote string
+	}{
+		{1, "a"}, {2, "b"}, {3, NO_VOTE},
+	}
+	for _, s := range states {
+		node.term, node.vote = s.term, s.vote
+		if err := node.writeState(); err != nil {
+			t.Fatalf("Unexpected error writing state: %v", err)
+		}
+	}
+
+	records, err := ReadStateHistory(history)
+	if err != nil {
+		t.Fatalf("Unexpected error reading history: %v", err)
+	}
+	if len(records) != len(states) {
+		t.Fatalf("Expected %d records, got %d", len(states), len(records))
+	}
+	for i, r := range records {
+		if r.Corrupt {
+			t.Fatalf("Expected record %d to be valid", i)
+		}
+		if r.Term != states[i].term || r.Vote != states[i].vote {
+			t.Fatalf("Expected record %d to be %d/%q, got %d/%q",
+				i, states[i].term, states[i].vote, r.Term, r.Vote)
+		}
+		if r.Line != i+1 {
+			t.Fatalf("Expected record %d on line %d, got %d", i, i+1, r.Line)
+		}
+		if i > 0 && r.Time.Before(records[i-1].Time) {
+			t.Fatalf("Expected records to be ordered in time")
+		}
+	}
+
+	// Corrupt the middle record.
+	buf, err := os.ReadFile(history)
+	if err != nil {
+		t.Fatalf("Unexpected error reading history file: %v", err)
+	}
+	lines := bytes.Split(buf, []byte("\n"))
+	lines[1] = bytes.Replace(lines[1], []byte("SHA"), []byte("ABC"), 1)
+	if err := os.WriteFile(history, bytes.Join(lines, []byte("\n")), 0660); err != nil {
+		t.Fatalf("Unexpected error writing history file: %v", err)
+	}
+
+	records, err = ReadStateHistory(history)
+	if err != nil {
+		t.Fatalf("Unexpected error reading history: %v", err)
+	}
+	if len(records) != len(states) {
+		t.Fatalf("Expected %d records, got %d", len(states), len(records))
+	}
+	if !records[1].Corrupt || records[1].Line != 2 {
+		t.Fatalf("Expected the second record to be flagged corrupt, got %+v", records[1])
+	}
+	if records[0].Corrupt || records[2].Corrupt {
+		t.Fatalf("Expected the other records to be valid")
+	}
+	if records[2].Term != 3 {
+		t.Fatalf("Expected the last record to have term 3, got %d", records[2].Term)
+	}
+}
+
+func TestStateHistoryMissing(t *testing.T) {
+	_, err := ReadStateHistory(filepath.Join(t.TempDir(), "missing"))
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected %v, got: %v", fs.ErrNotExist, err)
+	}
+}
+
+func TestStateHistoryOption(t *testing.T) {
+	ci := ClusterInfo{Name: "foo", Size: 3}
+	hand, rpc, log := genNodeArgs(t)
+	if _, err := New(ci, hand, rpc, log, WithStateHistory("")); err != ErrInvalidOption {
+		t.Fatalf("Expected %v, got: %v", ErrInvalidOption, err)
+	}
+}
+
+func TestStateHistoryFailure(t *testing.T) {
+	ci := ClusterInfo{Name: "foo", Size: 3}
+	_, rpc, log := genNodeArgs(t)
+	errCh := make(chan error, 4)
+	history := filepath.Join(t.TempDir(), "missing", "history")
+	node, err := New(ci, NewChanHandler(make(chan StateChange, 4), errCh), rpc, log, WithStateHistory(history))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+	// Delay elections
+	node.mu.Lock()
+	node.electTimer.Reset(10 * time.Second)
+	node.mu.Unlock()
+
+	// The state is written, and the history failure only reported.
+	node.setTerm(3)
+	if err := node.Flush(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if ps, err := node.readState(log); err != nil || ps.CurrentTerm != 3 {
+		t.Fatalf("Expected term 3 to be written, got %+v, %v", ps, err)
+	}
+	if err := errWait(t, errCh); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected %v, got: %v", fs.ErrNotExist, err)
+	}
+}
+
+func TestStateHistoryCompaction(t *testing.T) {
+	_, _, log := genNodeArgs(t)
+	history := filepath.Join(t.TempDir(), "history")
+
+	node := &Node{logPath: log, opts: options{historyPath: history, historyRetention: 5, metrics: nopMetrics{}}}
+	for term := uint64(1); term <= 23; term++ {
+		node.term, node.vote = term, "a"
+		if err := node.writeState(); err != nil {
+			t.Fatalf("Unexpected error writing state: %v", err)
+		}
+		records, err := ReadStateHistory(history)
+		if err != nil {
+			t.Fatalf("Unexpected error reading history: %v", err)
+		}
+		if len(records) > 10 {
+			t.Fatalf("Expected at most 10 records, got %d", len(records))
+		}
+		if last := records[len(records)-1]; last.Term != term {
+			t.Fatalf("Expected the last record to have term %d, got %d", term, last.Term)
+		}
+	}
+
+	// Compact the rest, dropping a corrupt record.
+	f, err := os.OpenFile(history, os.O_WRONLY|os.O_APPEND, 0660)
+	if err != nil {
+		t.Fatalf("Unexpected error opening history file: %v", err)
+	}
+	f.WriteString("{not json\n")
+	f.Close()
+	if err := CompactStateHistory(history, 3); err != nil {
+		t.Fatalf("Unexpected error compacting history: %v", err)
+	}
+	records, err := ReadStateHistory(history)
+	if err != nil {
+		t.Fatalf("Unexpected error reading history: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("Expected 3 records, got %d", len(records))
+	}
+	for i, r := range records {
+		if r.Corrupt || r.Term != uint64(21+i) {
+			t.Fatalf("Expected record %d to have term %d, got %+v", i, 21+i, r)
+		}
+	}
+
+	// Nothing is left behind.
+	entries, err := os.ReadDir(filepath.Dir(history))
+	if err != nil {
+		t.Fatalf("Unexpected error reading dir: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected only the history file, got %d files", len(entries))
+	}
+
+	ci := ClusterInfo{Name: "foo", Size: 3}
+	hand, rpc, log := genNodeArgs(t)
+	if _, err := New(ci, hand, rpc, log, WithStateHistoryRetention(0)); err != ErrInvalidOption {
+		t.Fatalf("Expected %v, got: %v", ErrInvalidOption, err)
+	}
+	if _, err := New(ci, hand, rpc, log, WithStateHistoryRetention(5)); err != ErrInvalidOption {
+		t.Fatalf("Expected %v, got: %v", ErrInvalidOption, err)
+	}
+}
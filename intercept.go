@@ -0,0 +1,61 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+import (
+	"errors"
+	"fmt"
+)
+
+// An RpcInterceptor observes or transforms the messages of a node as
+// they leave and arrive, whatever its RPCDriver, e.g. for logging, fault
+// injection or tagging. The messages are a *pb.VoteRequest,
+// *pb.VoteResponse, *pb.Heartbeat or *pb.HeartbeatResponse, and the
+// returned message must have the same type. Returning ErrDropMessage
+// drops the message, and any other error drops it and is reported to the
+// handler. The hooks are called by the node's loop and must not block.
+type RpcInterceptor interface {
+	// Outgoing is called before a message is passed to the RPCDriver.
+	Outgoing(msg any) (any, error)
+	// Incoming is called before a received message is processed.
+	Incoming(msg any) (any, error)
+}
+
+// intercept passes msg through the RpcInterceptor, if any. It returns
+// nil if the message must be dropped.
+func intercept[T any](n *Node, msg *T, outgoing bool) *T {
+	i := n.opts.interceptor
+	if i == nil || msg == nil {
+		return msg
+	}
+	var out any
+	var err error
+	if outgoing {
+		out, err = i.Outgoing(msg)
+	} else {
+		out, err = i.Incoming(msg)
+	}
+	if err != nil {
+		if !errors.Is(err, ErrDropMessage) {
+			n.handleError(err)
+		}
+		return nil
+	}
+	m, ok := out.(*T)
+	if !ok {
+		n.handleError(fmt.Errorf("graft: RpcInterceptor returned a %T for a %T", out, msg))
+		return nil
+	}
+	return m
+}
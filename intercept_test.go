@@ -0,0 +1,97 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/graft/pb"
+)
+
+// dropHeartbeats drops the outgoing heartbeats once enabled.
+type dropHeartbeats struct {
+	enabled atomic.Bool
+	dropped atomic.Int32
+}
+
+func (d *dropHeartbeats) Outgoing(msg any) (any, error) {
+	if _, ok := msg.(*pb.Heartbeat); ok && d.enabled.Load() {
+		d.dropped.Add(1)
+		return nil, ErrDropMessage
+	}
+	return msg, nil
+}
+
+func (d *dropHeartbeats) Incoming(msg any) (any, error) {
+	return msg, nil
+}
+
+func TestRpcInterceptor(t *testing.T) {
+	ci := ClusterInfo{Name: "intercept", Size: 3}
+	interceptors := make([]*dropHeartbeats, 3)
+	nodes := make([]*Node, 3)
+	for i := range nodes {
+		interceptors[i] = &dropHeartbeats{}
+		hand, rpc, log := genNodeArgs(t)
+		node, err := New(ci, hand, rpc, log,
+			WithHeartbeatInterval(5*time.Millisecond, 10, 20), WithRpcInterceptor(interceptors[i]))
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		defer node.Close()
+		nodes[i] = node
+	}
+
+	var leader *Node
+	deadline := time.Now().Add(3 * time.Second)
+	for leader == nil && time.Now().Before(deadline) {
+		for _, n := range nodes {
+			if n.State() == LEADER {
+				leader = n
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if leader == nil {
+		t.Fatal("Expected a LEADER to be elected")
+	}
+	term := leader.CurrentTerm()
+
+	// Without heartbeats, the followers campaign.
+	for i, n := range nodes {
+		if n == leader {
+			interceptors[i].enabled.Store(true)
+		}
+	}
+	for _, n := range nodes {
+		if n == leader {
+			continue
+		}
+		if got := waitForTerm(n, term+1); got <= term {
+			t.Fatalf("Expected a follower to campaign past term %d, got %d", term, got)
+		}
+	}
+	for i, n := range nodes {
+		if n == leader && interceptors[i].dropped.Load() == 0 {
+			t.Fatal("Expected heartbeats to be dropped")
+		}
+	}
+
+	hand, rpc, log := genNodeArgs(t)
+	if _, err := New(ci, hand, rpc, log, WithRpcInterceptor(nil)); err != ErrInvalidOption {
+		t.Fatalf("Expected %v, got: %v", ErrInvalidOption, err)
+	}
+}
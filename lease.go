@@ -0,0 +1,94 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+import (
+	"fmt"
+	"sync"
+)
+
+// leaseMirror mirrors our leadership into an external lease with the
+// functions given to WithLeaseMirror. They are called on their own
+// goroutine, so a slow lease store never delays our heartbeats, and only
+// the latest leadership is mirrored when calls pile up.
+type leaseMirror struct {
+	renew   func(term uint64) error
+	release func(term uint64) error
+
+	mu sync.Mutex
+	// Term we lead, or 0 when we are not LEADER.
+	term uint64
+	kick chan struct{}
+	done chan struct{}
+}
+
+func newLeaseMirror(renew, release func(term uint64) error) *leaseMirror {
+	return &leaseMirror{
+		renew:   renew,
+		release: release,
+		kick:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+}
+
+// lead asks to renew the lease for term, or to release it if term is 0.
+func (lm *leaseMirror) lead(term uint64) {
+	if lm == nil {
+		return
+	}
+	lm.mu.Lock()
+	lm.term = term
+	lm.mu.Unlock()
+	select {
+	case lm.kick <- struct{}{}:
+	default:
+	}
+}
+
+// mirrorLease calls the lease functions until Close() joined the loop,
+// releasing a lease still held then.
+func (n *Node) mirrorLease(lm *leaseMirror) {
+	// Term of the lease we hold, 0 if none.
+	var held uint64
+	for {
+		select {
+		case <-lm.kick:
+			held = n.syncLease(lm, held)
+		case <-lm.done:
+			n.syncLease(lm, held)
+			return
+		}
+	}
+}
+
+// syncLease renews or releases the lease, and returns the term of the
+// lease held afterwards.
+func (n *Node) syncLease(lm *leaseMirror, held uint64) uint64 {
+	lm.mu.Lock()
+	term := lm.term
+	lm.mu.Unlock()
+	var err error
+	switch {
+	case term != 0:
+		err = lm.renew(term)
+		held = term
+	case held != 0:
+		err = lm.release(held)
+		held = 0
+	}
+	if err != nil {
+		n.handleError(fmt.Errorf("%w: %w", ErrLeaseMirror, err))
+	}
+	return held
+}
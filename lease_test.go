@@ -0,0 +1,131 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// leaseCall records a call of the lease functions.
+type leaseCall struct {
+	release bool
+	term    uint64
+}
+
+func waitLease(t *testing.T, ch chan leaseCall) leaseCall {
+	t.Helper()
+	select {
+	case c := <-ch:
+		return c
+	case <-time.After(MAX_ELECTION_TIMEOUT + time.Second):
+		t.Fatal("Timeout waiting for the lease")
+	}
+	return leaseCall{}
+}
+
+func TestLeaseMirror(t *testing.T) {
+	ci := ClusterInfo{Name: "lease", Size: 1}
+	hand, rpc, log := genNodeArgs(t)
+	calls := make(chan leaseCall, 64)
+	renew := func(term uint64) error {
+		calls <- leaseCall{term: term}
+		return nil
+	}
+	release := func(term uint64) error {
+		calls <- leaseCall{release: true, term: term}
+		return nil
+	}
+	node, err := New(ci, hand, rpc, log, WithLeaseMirror(10*time.Millisecond, renew, release))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+
+	// Acquired as LEADER, and renewed periodically.
+	c := waitLease(t, calls)
+	if c.release || c.term == 0 || c.term != node.CurrentTerm() || node.State() != LEADER {
+		t.Fatalf("Expected the lease to be acquired by the LEADER of term %d, got %+v", node.CurrentTerm(), c)
+	}
+	term := c.term
+	for i := 0; i < 3; i++ {
+		if c := waitLease(t, calls); c != (leaseCall{term: term}) {
+			t.Fatalf("Expected the lease to be renewed for term %d, got %+v", term, c)
+		}
+	}
+
+	// Released once we are not LEADER anymore.
+	if err := node.Pause(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	for c := waitLease(t, calls); c != (leaseCall{release: true, term: term}); c = waitLease(t, calls) {
+		if c != (leaseCall{term: term}) {
+			t.Fatalf("Expected the lease of term %d to be released, got %+v", term, c)
+		}
+	}
+	select {
+	case c := <-calls:
+		t.Fatalf("Expected no lease call while PAUSED, got %+v", c)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Released when closed as LEADER.
+	if err := node.Resume(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	c = waitLease(t, calls)
+	if c.release || c.term <= term {
+		t.Fatalf("Expected the lease to be acquired for a term after %d, got %+v", term, c)
+	}
+	term = c.term
+	node.Close()
+	var last leaseCall
+	for len(calls) > 0 {
+		last = <-calls
+	}
+	if last != (leaseCall{release: true, term: term}) {
+		t.Fatalf("Expected the lease of term %d to be released on Close, got %+v", term, last)
+	}
+}
+
+func TestLeaseMirrorErrors(t *testing.T) {
+	ci := ClusterInfo{Name: "leaseerr", Size: 1}
+	_, rpc, log := genNodeArgs(t)
+	errCh := make(chan error, 8)
+	hand := NewChanHandler(make(chan StateChange, 8), errCh)
+	failed := errors.New("lease conflict")
+	renew := func(term uint64) error { return failed }
+	release := func(term uint64) error { return nil }
+	if _, err := New(ci, hand, rpc, log, WithLeaseMirror(0, renew, release)); err != ErrInvalidOption {
+		t.Fatalf("Expected %v, got: %v", ErrInvalidOption, err)
+	}
+	node, err := New(ci, hand, rpc, log, WithLeaseMirror(time.Hour, renew, release))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+
+	if state := waitForState(node, LEADER); state != LEADER {
+		t.Fatalf("Expected Node to be in Leader state, got: %s", state)
+	}
+	err = errWait(t, errCh)
+	if !errors.Is(err, ErrLeaseMirror) || !errors.Is(err, failed) {
+		t.Fatalf("Expected %v wrapping %v, got: %v", ErrLeaseMirror, failed, err)
+	}
+	// The lease does not affect the leadership.
+	if state := node.State(); state != LEADER {
+		t.Fatalf("Expected Node to stay LEADER, got: %s", state)
+	}
+}
@@ -15,22 +15,109 @@ package graft
 
 import (
 	"bytes"
+	"cmp"
 	"crypto/sha1"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"time"
 )
 
+// writeFile writes the log file. Tests replace it to simulate failures.
+var writeFile = os.WriteFile
+
 type envelope struct {
 	SHA, Data []byte
 }
-type persistentState struct {
+
+// compactEnvelope is the envelope of the compact state, which embeds the
+// digested JSON as is rather than encoded in base64.
+type compactEnvelope struct {
+	SHA  []byte          `json:"s"`
+	Data json.RawMessage `json:"d"`
+}
+
+// Version of the PersistentState written to the log file.
+const STATE_VERSION = 2
+
+// Version of the PersistentState written with WithCompactState.
+const STATE_VERSION_COMPACT = 3
+
+// PersistentState is the state a node keeps in its log file.
+type PersistentState struct {
+	// Encoded first. Absent from version 1 files.
+	Version     int
 	CurrentTerm uint64
 	VotedFor    string
+	// Empty for logs written before the name was recorded.
+	ClusterName string `json:",omitempty"`
+	// Set by a StateWriteHook.
+	Metadata map[string]string `json:",omitempty"`
+}
+
+// A StateWriteHook is a Handler called before its node writes its state,
+// e.g. to mirror it to another store or to add Metadata. The state
+// written is the one returned, with the digest computed over it, but
+// changes to anything other than the Metadata are ignored. Returning an
+// error aborts the write, and the action that required it, e.g. a vote.
+type StateWriteHook interface {
+	BeforeWriteState(ps PersistentState) (PersistentState, error)
+}
+
+// A StateWrittenHook is a Handler called after its node durably wrote
+// its state, e.g. to ship it off-host for backups. It receives the exact
+// bytes of the log file, which DecodeState parses, and their generation:
+// the count of writes since the node started, so that an older push can
+// be told apart. Writes are serialized: AfterWriteState is called before
+// the next write starts. The bytes are not used by the node afterwards.
+type StateWrittenHook interface {
+	AfterWriteState(data []byte, gen uint64)
+}
+
+// persistentStateV1 is the shape of the state before it was versioned.
+type persistentStateV1 struct {
+	CurrentTerm uint64
+	VotedFor    string
+	ClusterName string
+}
+
+// persistentStateCompact is the shape of the compact state, with short
+// field names and without the empty ones.
+type persistentStateCompact struct {
+	Version     int               `json:"v"`
+	CurrentTerm uint64            `json:"t,omitempty"`
+	VotedFor    string            `json:"f,omitempty"`
+	ClusterName string            `json:"c,omitempty"`
+	Metadata    map[string]string `json:"m,omitempty"`
 }
 
 func (n *Node) initLog(path string) error {
+	// Without persistence, we always start without state.
+	if n.opts.ephemeral {
+		if n.opts.lostStateGuard {
+			n.mu.Lock()
+			n.catchingUp = true
+			n.mu.Unlock()
+		}
+		return nil
+	}
+
+	// Keep using the same file if the working directory changes.
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return newLogError("open", path, err)
+	}
+
+	// Tell a log we create from one found empty, maybe truncated.
+	_, serr := os.Stat(path)
+	existed := serr == nil
+
 	if log, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0660); err != nil {
-		return err
+		return newLogError("open", path, err)
 	} else {
 		log.Close()
 	}
@@ -38,33 +125,377 @@ func (n *Node) initLog(path string) error {
 	n.logPath = path
 
 	ps, err := n.readState(path)
-	if err != nil && err != ErrLogNoState {
+	if existed && n.opts.strictEmptyLog && errors.Is(err, ErrLogNoState) {
+		err = newLogError("read", path, fmt.Errorf("%w: %w", ErrLogCorrupt, ErrLogEmpty))
+	}
+	if errors.Is(err, ErrLogCorrupt) {
+		// Signal it separately from ordinary startup failures.
+		n.opts.metrics.IncrCounter(METRIC_STATE_CORRUPT, 1, Label{Name: "path", Value: path})
+		n.handleError(err)
+		if n.opts.resetOnCorrupt {
+			if err := n.quarantineLog(path); err != nil {
+				return err
+			}
+			ps, err = nil, nil
+		}
+	}
+	if err != nil && !errors.Is(err, ErrLogNoState) {
 		return err
 	}
 
+	// Do not silently adopt the state of another cluster.
+	if ps != nil && ps.ClusterName != "" && ps.ClusterName != n.info.Name {
+		if !n.opts.resetOnClusterMismatch {
+			return newLogError("read", path, ErrClusterMismatch)
+		}
+		ps = nil
+	}
+
 	if ps != nil {
 		n.setTerm(ps.CurrentTerm)
 		n.setVote(ps.VotedFor)
+	} else if n.opts.lostStateGuard {
+		// A known node without state lost it, e.g. to a wiped disk.
+		n.mu.Lock()
+		n.catchingUp = true
+		n.mu.Unlock()
+	}
+
+	// Never leave the log empty, so it is not found empty on restart.
+	if ps == nil && n.opts.strictEmptyLog {
+		return n.writeState()
 	}
 
 	return nil
 }
 
+// quarantineLog copies the corrupt log file at path to the quarantine
+// directory, and empties it so it is not found corrupt again.
+func (n *Node) quarantineLog(path string) error {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return newLogError("read", path, err)
+	}
+	dir := n.opts.quarantineDir
+	if dir == "" {
+		dir = filepath.Dir(path)
+	}
+	name := fmt.Sprintf("%s.corrupt.%d", filepath.Base(path), time.Now().UnixNano())
+	dst := filepath.Join(dir, name)
+	if err := os.WriteFile(dst, buf, 0660); err != nil {
+		return newLogError("quarantine", dst, err)
+	}
+	if err := os.Truncate(path, 0); err != nil {
+		return newLogError("truncate", path, err)
+	}
+	return nil
+}
+
 func (n *Node) closeLog() error {
-	err := os.Remove(n.logPath)
+	// Wait for an in-flight write and reject the next ones, so the
+	// file is not recreated once removed.
+	n.wmu.Lock()
+	defer n.wmu.Unlock()
+	n.logClosed = true
+	if n.opts.ephemeral {
+		return nil
+	}
+
+	n.mu.Lock()
+	logPath := n.logPath
 	n.logPath = ""
-	return err
+	n.mu.Unlock()
+
+	if err := os.Remove(logPath); err != nil {
+		return newLogError("remove", logPath, err)
+	}
+	return nil
 }
 
 func (n *Node) writeState() error {
+	// Serialize writers so the file is never torn.
+	n.wmu.Lock()
+	defer n.wmu.Unlock()
+	if n.logClosed {
+		return ErrLogClosed
+	}
+	if n.opts.ephemeral {
+		return nil
+	}
+
+	version := STATE_VERSION
+	if n.opts.compactState {
+		version = STATE_VERSION_COMPACT
+	}
 	n.mu.Lock()
-	ps := persistentState{
+	ps := PersistentState{
+		Version:     version,
 		CurrentTerm: n.term,
 		VotedFor:    n.vote,
+		ClusterName: n.info.Name,
 	}
 	logPath := n.logPath
+	historyPath := n.opts.historyPath
 	n.mu.Unlock()
 
+	if h, ok := n.handler.(StateWriteHook); ok {
+		hooked, err := h.BeforeWriteState(ps)
+		if err != nil {
+			return newLogError("write", logPath, err)
+		}
+		ps.Metadata = hooked.Metadata
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeState(&buf, ps); err != nil {
+		return newLogError("write", logPath, err)
+	}
+
+	start := time.Now()
+	inPlace, err := n.writeWithRetry(logPath, buf.Bytes())
+	if err != nil && logDirRemoved(logPath, err) {
+		err = n.recreateLogDir(logPath, buf.Bytes(), err)
+	}
+	n.opts.metrics.ObserveDuration(METRIC_STATE_SAVE_LATENCY, time.Since(start))
+	if err != nil {
+		n.opts.metrics.IncrCounter(METRIC_STATE_SAVE_ERRORS, 1)
+		// Do not vote or campaign until we can write again.
+		n.setDegraded(true)
+		return newLogError("write", logPath, err)
+	}
+	n.setDegraded(false)
+	// Warn once that the writes are no longer atomic.
+	if inPlace && !n.inPlace {
+		n.handleError(newLogError("write", logPath, ErrNonAtomicWrite))
+	}
+	n.inPlace = inPlace
+	n.trace(traceStateWritten, ps.CurrentTerm)
+	n.writeGen++
+	if h, ok := n.handler.(StateWrittenHook); ok {
+		h.AfterWriteState(buf.Bytes(), n.writeGen)
+	}
+
+	// The state is durable, failing to record it in the history must
+	// not undo the vote or campaign that wrote it.
+	if historyPath != "" {
+		if err := n.appendHistory(historyPath, ps); err != nil {
+			n.handleError(err)
+		}
+	}
+	return nil
+}
+
+// logDirRemoved returns whether err, from writing the log at logPath,
+// is due to its directory being removed, e.g. by an operator.
+func logDirRemoved(logPath string, err error) bool {
+	if !errors.Is(err, fs.ErrNotExist) {
+		return false
+	}
+	_, serr := os.Stat(filepath.Dir(logPath))
+	return errors.Is(serr, fs.ErrNotExist)
+}
+
+// recreateLogDir handles the removal of the directory of the log, which
+// failed to write data with err. With WithLogDirRecreation, the directory
+// is recreated and data written again, and the handler is warned with
+// ErrLogDirRemoved. Otherwise, or if that fails, it returns an error
+// wrapping ErrLogDirRemoved.
+func (n *Node) recreateLogDir(logPath string, data []byte, err error) error {
+	if n.opts.recreateLogDir {
+		if err = os.MkdirAll(filepath.Dir(logPath), 0750); err == nil {
+			if _, err = writeFileAtomic(logPath, data, 0660); err == nil {
+				n.handleError(newLogError("write", logPath, ErrLogDirRemoved))
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w: %w", ErrLogDirRemoved, err)
+}
+
+// Flush forces the current term and vote to be written to the log
+// synchronously, so the log reflects the latest in-memory state.
+func (n *Node) Flush() error {
+	return n.writeState()
+}
+
+// ExportState flushes the current state and writes the content of
+// the log to w. This can be used to take backups of a running node.
+// Without persistence, it returns ErrLogNoState.
+func (n *Node) ExportState(w io.Writer) error {
+	if n.opts.ephemeral {
+		return ErrLogNoState
+	}
+	if err := n.Flush(); err != nil {
+		return err
+	}
+
+	n.wmu.Lock()
+	logPath := n.LogPath()
+	buf, err := os.ReadFile(logPath)
+	n.wmu.Unlock()
+	if err != nil {
+		return newLogError("read", logPath, err)
+	}
+
+	_, err = w.Write(buf)
+	return err
+}
+
+// ReloadState re-reads the log file and adopts its term and vote, e.g.
+// after an operator edited the file. The node must be paused, so the
+// state does not change under its running loops.
+func (n *Node) ReloadState() error {
+	switch n.State() {
+	case CLOSED:
+		return ErrNodeClosed
+	case PAUSED:
+	default:
+		return ErrNodeNotPaused
+	}
+
+	n.wmu.Lock()
+	defer n.wmu.Unlock()
+	logPath := n.LogPath()
+	ps, err := n.readState(logPath)
+	if err != nil {
+		return err
+	}
+	if ps.ClusterName != "" && ps.ClusterName != n.info.Name {
+		return newLogError("read", logPath, ErrClusterMismatch)
+	}
+	n.setTerm(ps.CurrentTerm)
+	n.setVote(ps.VotedFor)
+	return nil
+}
+
+// ResetState wipes the term and vote of the node, in memory and in its
+// log file, e.g. to re-provision it with the same identity and path. The
+// node must be paused, like for ReloadState. It then campaigns as a new
+// member once resumed.
+func (n *Node) ResetState() error {
+	switch n.State() {
+	case CLOSED:
+		return ErrNodeClosed
+	case PAUSED:
+	default:
+		return ErrNodeNotPaused
+	}
+
+	n.mu.Lock()
+	term, vote, at := n.term, n.vote, n.votedAt
+	n.mu.Unlock()
+	n.setTerm(0)
+	n.setVote(NO_VOTE)
+	if err := n.writeState(); err != nil {
+		// Keep our previous state, as on disk.
+		n.mu.Lock()
+		n.term, n.vote, n.votedAt = term, vote, at
+		n.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// StateFormat is the format of the state loaded from a log file.
+type StateFormat int8
+
+// Formats of the log files.
+const (
+	// No state was loaded.
+	STATE_FORMAT_NONE StateFormat = iota
+	// Verified with the digest used before the state was versioned.
+	STATE_FORMAT_LEGACY_DIGEST
+	// Written before the state was versioned.
+	STATE_FORMAT_V1
+	// Written with STATE_VERSION.
+	STATE_FORMAT_CURRENT
+)
+
+// Convenience for printing, etc.
+func (f StateFormat) String() string {
+	switch f {
+	case STATE_FORMAT_NONE:
+		return "None"
+	case STATE_FORMAT_LEGACY_DIGEST:
+		return "LegacyDigest"
+	case STATE_FORMAT_V1:
+		return "V1"
+	case STATE_FORMAT_CURRENT:
+		return "Current"
+	default:
+		return fmt.Sprintf("Unknown[%d]", f)
+	}
+}
+
+func (n *Node) readState(path string) (*PersistentState, error) {
+	if n.opts.ephemeral {
+		return nil, ErrLogNoState
+	}
+	start := time.Now()
+	ps, legacy, err := loadState(path)
+	n.opts.metrics.ObserveDuration(METRIC_STATE_LOAD_LATENCY, time.Since(start))
+	if err != nil && !errors.Is(err, ErrLogNoState) {
+		n.opts.metrics.IncrCounter(METRIC_STATE_LOAD_ERRORS, 1)
+	}
+	if legacy {
+		// The file should be migrated by writing it again.
+		n.opts.metrics.IncrCounter(METRIC_STATE_LEGACY_DIGEST, 1, Label{Name: "path", Value: path})
+	}
+	format := STATE_FORMAT_NONE
+	switch {
+	case err != nil:
+	case legacy:
+		format = STATE_FORMAT_LEGACY_DIGEST
+	case ps.Version < STATE_VERSION:
+		format = STATE_FORMAT_V1
+	default:
+		format = STATE_FORMAT_CURRENT
+	}
+	n.mu.Lock()
+	n.stateFormat = format
+	n.mu.Unlock()
+	return ps, err
+}
+
+// StateFormat returns the format of the state the node loaded from its
+// log file, at startup or with ReloadState, e.g. to find the files that
+// should be migrated. It is not updated when the node writes its state.
+func (n *Node) StateFormat() StateFormat {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.stateFormat
+}
+
+// LoadPersistentState reads and verifies the log file at path without
+// creating a node, e.g. for offline inspection. Errors are LogErrors.
+func LoadPersistentState(path string) (*PersistentState, error) {
+	ps, _, err := loadState(path)
+	return ps, err
+}
+
+// loadState reads and verifies the log file at path, and reports if it
+// was verified with the legacy digest.
+func loadState(path string) (ps *PersistentState, legacy bool, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false, newLogError("read", path, err)
+	}
+	defer f.Close()
+	ps, legacy, err = decodeEnvelope(f)
+	if err != nil {
+		return nil, false, newLogError("read", path, err)
+	}
+	return ps, legacy, nil
+}
+
+// EncodeState writes ps to w in the format of the log file, with the
+// digest verified by DecodeState. It can be used to keep the state in
+// another store than a file. A ps of version STATE_VERSION_COMPACT is
+// written in the compact format, see WithCompactState.
+func EncodeState(w io.Writer, ps PersistentState) error {
+	if ps.Version == STATE_VERSION_COMPACT {
+		return encodeCompactState(w, ps)
+	}
 	buf, err := json.Marshal(ps)
 	if err != nil {
 		return err
@@ -80,22 +511,61 @@ func (n *Node) writeState() error {
 	if err != nil {
 		return err
 	}
+	_, err = w.Write(toWrite)
+	return err
+}
+
+// encodeCompactState writes ps to w in the compact format.
+func encodeCompactState(w io.Writer, ps PersistentState) error {
+	buf, err := json.Marshal(persistentStateCompact(ps))
+	if err != nil {
+		return err
+	}
+	sha := sha1.Sum(buf)
+	toWrite, err := json.Marshal(compactEnvelope{SHA: sha[:], Data: buf})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(toWrite)
+	return err
+}
 
-	return os.WriteFile(logPath, toWrite, 0660)
+// DecodeState reads a state written by EncodeState, or a log file, from
+// r and verifies it. A state that fails the verification returns a
+// CorruptionError, one that can not be parsed an error wrapping
+// ErrLogCorrupt, and an empty one ErrLogNoState.
+func DecodeState(r io.Reader) (*PersistentState, error) {
+	ps, _, err := decodeEnvelope(r)
+	return ps, err
 }
 
-func (n *Node) readState(path string) (*persistentState, error) {
-	buf, err := os.ReadFile(path)
+// decodeEnvelope reads and verifies the state from r, and reports if it
+// was verified with the legacy digest.
+func decodeEnvelope(r io.Reader) (ps *PersistentState, legacy bool, err error) {
+	buf, err := io.ReadAll(r)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
+	// Ignore the artifacts of editors around the envelope. The digested
+	// data inside it is left untouched.
+	buf = bytes.TrimPrefix(buf, []byte("\xef\xbb\xbf"))
+	buf = bytes.TrimRight(buf, " \t\r\n")
 	if len(buf) <= 0 {
-		return nil, ErrLogNoState
+		return nil, false, ErrLogNoState
 	}
 
+	// An envelope that can not be parsed was truncated or damaged.
 	env := &envelope{}
 	if err := json.Unmarshal(buf, env); err != nil {
-		return nil, err
+		return nil, false, fmt.Errorf("%w: %w", ErrLogCorrupt, err)
+	}
+	// Otherwise, the state may be compact.
+	if env.SHA == nil && env.Data == nil {
+		cenv := &compactEnvelope{}
+		if err := json.Unmarshal(buf, cenv); err != nil {
+			return nil, false, fmt.Errorf("%w: %w", ErrLogCorrupt, err)
+		}
+		env.SHA, env.Data = cenv.SHA, cenv.Data
 	}
 
 	// Test for corruption
@@ -109,13 +579,53 @@ func (n *Node) readState(path string) (*persistentState, error) {
 		legacyDigest := append(bytes.Clone(env.Data), hashOfNothing[:]...)
 
 		if !bytes.Equal(legacyDigest, env.SHA) {
-			return nil, ErrLogCorrupt
+			return nil, false, &CorruptionError{Expected: env.SHA, Detected: sha[:]}
 		}
+		legacy = true
 	}
 
-	ps := &persistentState{}
-	if err := json.Unmarshal(env.Data, ps); err != nil {
+	ps, err = decodeState(env.Data)
+	if err != nil {
+		return nil, false, err
+	}
+	return ps, legacy, nil
+}
+
+// decodeState decodes the state according to its version.
+func decodeState(data []byte) (*PersistentState, error) {
+	var v struct {
+		Version int
+		Compact int `json:"v"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
 		return nil, err
 	}
-	return ps, nil
+	switch cmp.Or(v.Version, v.Compact) {
+	case 0, 1:
+		old := &persistentStateV1{}
+		if err := json.Unmarshal(data, old); err != nil {
+			return nil, err
+		}
+		return &PersistentState{
+			Version:     1,
+			CurrentTerm: old.CurrentTerm,
+			VotedFor:    old.VotedFor,
+			ClusterName: old.ClusterName,
+		}, nil
+	case 2:
+		ps := &PersistentState{}
+		if err := json.Unmarshal(data, ps); err != nil {
+			return nil, err
+		}
+		return ps, nil
+	case STATE_VERSION_COMPACT:
+		c := &persistentStateCompact{}
+		if err := json.Unmarshal(data, c); err != nil {
+			return nil, err
+		}
+		ps := PersistentState(*c)
+		return &ps, nil
+	default:
+		return nil, fmt.Errorf("%w: %d", ErrLogVersion, cmp.Or(v.Version, v.Compact))
+	}
 }
@@ -17,9 +17,18 @@ import (
 	"bytes"
 	"crypto/sha1"
 	"encoding/json"
+	"errors"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
+
+	"github.com/nats-io/graft/pb"
 )
 
 func TestLogPermissions(t *testing.T) {
@@ -54,6 +63,45 @@ func TestLogCleanupOnClose(t *testing.T) {
 	}
 }
 
+func TestCloseWithConcurrentWrites(t *testing.T) {
+	ci := ClusterInfo{Name: "foo", Size: 3}
+	for i := 0; i < 20; i++ {
+		hand, rpc, log := genNodeArgs(t)
+		node, err := New(ci, hand, rpc, log)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		var wg sync.WaitGroup
+		for g := 0; g < 4; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for term := uint64(1); ; term++ {
+					node.setTerm(term)
+					if err := node.writeState(); err != nil {
+						if !errors.Is(err, ErrLogClosed) {
+							t.Errorf("Expected %v, got: %v", ErrLogClosed, err)
+						}
+						return
+					}
+				}
+			}()
+		}
+
+		time.Sleep(time.Millisecond)
+		node.Close()
+		wg.Wait()
+
+		if _, err := os.Stat(log); !os.IsNotExist(err) {
+			t.Fatal("Expected log to stay removed after Close()")
+		}
+		if err := node.Flush(); !errors.Is(err, ErrLogClosed) {
+			t.Fatalf("Expected %v, got: %v", ErrLogClosed, err)
+		}
+	}
+}
+
 func TestLogPresenceOnNew(t *testing.T) {
 	// Make sure to clean us up from wonly state
 	defer mockResetPeers()
@@ -168,9 +216,426 @@ func TestCorruption(t *testing.T) {
 	if err == nil {
 		t.Fatal("Expected an error reading corrupt state")
 	}
-	if err != ErrLogCorrupt {
+	if !errors.Is(err, ErrLogCorrupt) {
 		t.Fatalf("Expected corrupt error, got %q", err)
 	}
+	var lerr *LogError
+	if !errors.As(err, &lerr) || lerr.Kind != KindCorrupt {
+		t.Fatalf("Expected a LogError of kind %s, got %v", KindCorrupt, err)
+	}
+}
+
+// counterMetrics records the counters, gauges and longest durations
+// reported to the metrics hook.
+type counterMetrics struct {
+	mu        sync.Mutex
+	counters  map[string]int64
+	gauges    map[string]float64
+	durations map[string]time.Duration
+}
+
+func (m *counterMetrics) IncrCounter(name string, delta int64, labels ...Label) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counters[name] += delta
+}
+
+func (m *counterMetrics) counter(name string) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.counters[name]
+}
+
+func (m *counterMetrics) SetGauge(name string, value float64, labels ...Label) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.gauges == nil {
+		m.gauges = make(map[string]float64)
+	}
+	m.gauges[name] = value
+}
+
+func (m *counterMetrics) gauge(name string) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.gauges[name]
+}
+
+func (m *counterMetrics) ObserveDuration(name string, d time.Duration, labels ...Label) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.durations == nil {
+		m.durations = make(map[string]time.Duration)
+	}
+	m.durations[name] = max(m.durations[name], d)
+}
+
+func (m *counterMetrics) duration(name string) time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.durations[name]
+}
+
+func TestCorruptionReported(t *testing.T) {
+	ci := ClusterInfo{Name: "foo", Size: 3}
+	_, rpc, log := genNodeArgs(t)
+
+	node := &Node{opts: defaultOptions(), info: ci, logPath: log, term: 1, vote: "foo"}
+	if err := node.writeState(); err != nil {
+		t.Fatalf("Unexpected error writing state: %v", err)
+	}
+	buf, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatalf("Could not read logfile: %v", err)
+	}
+	env := &envelope{}
+	if err := json.Unmarshal(buf, env); err != nil {
+		t.Fatalf("Error unmarshalling envelope: %v", err)
+	}
+	expected := env.SHA
+	env.Data = []byte("ZZZZ")
+	toWrite, err := json.Marshal(env)
+	if err != nil {
+		t.Fatalf("Error Marshaling envelope: %v", err)
+	}
+	if err := os.WriteFile(log, toWrite, 0660); err != nil {
+		t.Fatalf("Error writing envelope: %v", err)
+	}
+
+	scCh := make(chan StateChange, 1)
+	errCh := make(chan error, 1)
+	metrics := &counterMetrics{counters: make(map[string]int64)}
+	_, err = New(ci, NewChanHandler(scCh, errCh), rpc, log, WithMetrics(metrics))
+	if !errors.Is(err, ErrLogCorrupt) {
+		t.Fatalf("Expected %v, got: %v", ErrLogCorrupt, err)
+	}
+	if c := metrics.counter(METRIC_STATE_CORRUPT); c != 1 {
+		t.Fatalf("Expected the corruption counter to be 1, got %d", c)
+	}
+
+	// The error handler gets the details.
+	aerr := errWait(t, errCh)
+	var lerr *LogError
+	if !errors.As(aerr, &lerr) || lerr.Path != log {
+		t.Fatalf("Expected a LogError for %q, got %v", log, aerr)
+	}
+	var cerr *CorruptionError
+	if !errors.As(aerr, &cerr) {
+		t.Fatalf("Expected a CorruptionError, got %v", aerr)
+	}
+	detected := sha1.Sum([]byte("ZZZZ"))
+	if !bytes.Equal(cerr.Expected, expected) || !bytes.Equal(cerr.Detected, detected[:]) {
+		t.Fatalf("Unexpected digests: %x %x", cerr.Expected, cerr.Detected)
+	}
+}
+
+func TestResetOnCorruptLog(t *testing.T) {
+	ci := ClusterInfo{Name: "foo", Size: 3}
+	hand, rpc, log := genNodeArgs(t)
+	corrupt := []byte(`{"SHA":"AAAA","Data":"eyJDdXJyZW50VGVybSI6M30="}`)
+	if err := os.WriteFile(log, corrupt, 0660); err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+	quarantine := t.TempDir()
+	if _, err := New(ci, hand, rpc, log, WithQuarantineDir(quarantine)); err != ErrInvalidOption {
+		t.Fatalf("Expected %v, got: %v", ErrInvalidOption, err)
+	}
+	node, err := New(ci, hand, rpc, log, WithResetOnCorruptLog(), WithQuarantineDir(quarantine))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+	if term := node.CurrentTerm(); term != 0 {
+		t.Fatalf("Expected the state to be reset, got term %d", term)
+	}
+
+	// The original bytes are in the configured directory.
+	matches, err := filepath.Glob(filepath.Join(quarantine, filepath.Base(log)+".corrupt.*"))
+	if err != nil || len(matches) != 1 {
+		t.Fatalf("Expected a quarantined copy, got %v, %v", matches, err)
+	}
+	buf, err := os.ReadFile(matches[0])
+	if err != nil {
+		t.Fatalf("Could not read the quarantined copy: %v", err)
+	}
+	if !bytes.Equal(buf, corrupt) {
+		t.Fatalf("Expected the original bytes, got %q", buf)
+	}
+	// And not alongside the log.
+	if matches, _ := filepath.Glob(log + ".corrupt.*"); len(matches) != 0 {
+		t.Fatalf("Expected no copy alongside the log, got %v", matches)
+	}
+}
+
+func TestLogErrorKinds(t *testing.T) {
+	node := &Node{opts: defaultOptions()}
+	dir := t.TempDir()
+
+	// Missing file
+	missing := filepath.Join(dir, "missing")
+	_, err := node.readState(missing)
+	var lerr *LogError
+	if !errors.As(err, &lerr) || lerr.Kind != KindNotFound {
+		t.Fatalf("Expected a LogError of kind %s, got %v", KindNotFound, err)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected the underlying error to be preserved, got %v", err)
+	}
+	if lerr.Path != missing || lerr.Op != "read" {
+		t.Fatalf("Unexpected Op/Path: %q %q", lerr.Op, lerr.Path)
+	}
+
+	// Empty file
+	empty := filepath.Join(dir, "empty")
+	if err := os.WriteFile(empty, nil, 0660); err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+	_, err = node.readState(empty)
+	if !errors.As(err, &lerr) || lerr.Kind != KindNoState || !errors.Is(err, ErrLogNoState) {
+		t.Fatalf("Expected a LogError of kind %s, got %v", KindNoState, err)
+	}
+
+	// Garbage file
+	garbage := filepath.Join(dir, "garbage")
+	if err := os.WriteFile(garbage, []byte("{not json"), 0660); err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+	_, err = node.readState(garbage)
+	var serr *json.SyntaxError
+	if !errors.As(err, &lerr) || lerr.Kind != KindCorrupt || !errors.Is(err, ErrLogCorrupt) || !errors.As(err, &serr) {
+		t.Fatalf("Expected a LogError of kind %s, got %v", KindCorrupt, err)
+	}
+	if errors.Is(err, ErrLogNoState) {
+		t.Fatalf("Expected kinds to be distinguishable, got %v", err)
+	}
+
+	// Unsupported version, with a valid digest.
+	version := filepath.Join(dir, "version")
+	var buf bytes.Buffer
+	if err := EncodeState(&buf, PersistentState{Version: STATE_VERSION + 100}); err != nil {
+		t.Fatalf("Unexpected error encoding state: %v", err)
+	}
+	if err := os.WriteFile(version, buf.Bytes(), 0660); err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+	_, err = node.readState(version)
+	if !errors.As(err, &lerr) || lerr.Kind != KindEncoding || errors.Is(err, ErrLogCorrupt) {
+		t.Fatalf("Expected a LogError of kind %s, got %v", KindEncoding, err)
+	}
+}
+
+func TestTruncatedLog(t *testing.T) {
+	ci := ClusterInfo{Name: "truncated", Size: 3}
+	hand, rpc, log := genNodeArgs(t)
+	var buf bytes.Buffer
+	if err := EncodeState(&buf, PersistentState{Version: STATE_VERSION, CurrentTerm: 3, VotedFor: "a", ClusterName: ci.Name}); err != nil {
+		t.Fatalf("Unexpected error encoding state: %v", err)
+	}
+	// A cut in the envelope, and a bit flip in the base64 of its data.
+	flipped := bytes.Clone(buf.Bytes())
+	flipped[bytes.Index(flipped, []byte(`"Data":"`))+10] ^= 0x40
+	for _, data := range [][]byte{buf.Bytes()[:buf.Len()/2], flipped} {
+		if err := os.WriteFile(log, data, 0660); err != nil {
+			t.Fatalf("Error writing file: %v", err)
+		}
+		if _, err := LoadPersistentState(log); !errors.Is(err, ErrLogCorrupt) {
+			t.Fatalf("Expected %v for %q, got: %v", ErrLogCorrupt, data, err)
+		}
+		if _, err := New(ci, hand, rpc, log); !errors.Is(err, ErrLogCorrupt) {
+			t.Fatalf("Expected %v for %q, got: %v", ErrLogCorrupt, data, err)
+		}
+	}
+
+	// And is reset like any corrupt log.
+	node, err := New(ci, hand, rpc, log, WithResetOnCorruptLog())
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+	if term := node.CurrentTerm(); term != 0 {
+		t.Fatalf("Expected a fresh state, got term %d", term)
+	}
+}
+
+func TestEditorArtifacts(t *testing.T) {
+	log := filepath.Join(t.TempDir(), "state")
+	node := &Node{opts: defaultOptions(), info: ClusterInfo{Name: "foo", Size: 3}, logPath: log, term: 3, vote: "bar"}
+	if err := node.writeState(); err != nil {
+		t.Fatalf("Unexpected error writing state: %v", err)
+	}
+	buf, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatalf("Could not read logfile: %v", err)
+	}
+
+	for _, edited := range [][]byte{
+		append(bytes.Clone(buf), '\n'),
+		append(bytes.Clone(buf), "\r\n  \n"...),
+		append([]byte("\xef\xbb\xbf"), buf...),
+	} {
+		if err := os.WriteFile(log, edited, 0660); err != nil {
+			t.Fatalf("Error writing file: %v", err)
+		}
+		ps, err := LoadPersistentState(log)
+		if err != nil {
+			t.Fatalf("Expected no error for %q, got: %v", edited, err)
+		}
+		if ps.CurrentTerm != 3 || ps.VotedFor != "bar" {
+			t.Fatalf("Expected term 3 and vote bar, got %+v", ps)
+		}
+	}
+}
+
+func TestLoadPersistentState(t *testing.T) {
+	dir := t.TempDir()
+
+	// Valid file
+	valid := filepath.Join(dir, "valid")
+	node := &Node{opts: defaultOptions(), info: ClusterInfo{Name: "foo", Size: 3}, logPath: valid, term: 3, vote: "bar"}
+	if err := node.writeState(); err != nil {
+		t.Fatalf("Unexpected error writing state: %v", err)
+	}
+	ps, err := LoadPersistentState(valid)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if ps.CurrentTerm != 3 || ps.VotedFor != "bar" || ps.ClusterName != "foo" {
+		t.Fatalf("Unexpected state: %+v", ps)
+	}
+
+	// Empty file
+	empty := filepath.Join(dir, "empty")
+	if err := os.WriteFile(empty, nil, 0660); err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+	if _, err := LoadPersistentState(empty); !errors.Is(err, ErrLogNoState) {
+		t.Fatalf("Expected %v, got: %v", ErrLogNoState, err)
+	}
+
+	// Corrupt file
+	buf, err := os.ReadFile(valid)
+	if err != nil {
+		t.Fatalf("Could not read logfile: %v", err)
+	}
+	env := &envelope{}
+	if err := json.Unmarshal(buf, env); err != nil {
+		t.Fatalf("Error unmarshalling envelope: %v", err)
+	}
+	env.Data = []byte("ZZZZ")
+	toWrite, err := json.Marshal(env)
+	if err != nil {
+		t.Fatalf("Error Marshaling envelope: %v", err)
+	}
+	corrupt := filepath.Join(dir, "corrupt")
+	if err := os.WriteFile(corrupt, toWrite, 0660); err != nil {
+		t.Fatalf("Error writing envelope: %v", err)
+	}
+	if _, err := LoadPersistentState(corrupt); !errors.Is(err, ErrLogCorrupt) {
+		t.Fatalf("Expected %v, got: %v", ErrLogCorrupt, err)
+	}
+}
+
+func TestEncodeDecodeState(t *testing.T) {
+	ps := PersistentState{
+		Version:     STATE_VERSION,
+		CurrentTerm: 7,
+		VotedFor:    "bar",
+		ClusterName: "foo",
+		Metadata:    map[string]string{"zone": "a"},
+	}
+	var buf bytes.Buffer
+	if err := EncodeState(&buf, ps); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	encoded := bytes.Clone(buf.Bytes())
+	got, err := DecodeState(&buf)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if !reflect.DeepEqual(*got, ps) {
+		t.Fatalf("Expected %+v, got %+v", ps, *got)
+	}
+
+	// Empty
+	if _, err := DecodeState(&bytes.Buffer{}); err != ErrLogNoState {
+		t.Fatalf("Expected %v, got: %v", ErrLogNoState, err)
+	}
+
+	// Corrupt the data covered by the digest.
+	env := &envelope{}
+	if err := json.Unmarshal(encoded, env); err != nil {
+		t.Fatalf("Error unmarshalling envelope: %v", err)
+	}
+	env.Data = bytes.Replace(env.Data, []byte("7"), []byte("8"), 1)
+	toRead, err := json.Marshal(env)
+	if err != nil {
+		t.Fatalf("Error Marshaling envelope: %v", err)
+	}
+	_, err = DecodeState(bytes.NewReader(toRead))
+	var cerr *CorruptionError
+	if !errors.Is(err, ErrLogCorrupt) || !errors.As(err, &cerr) {
+		t.Fatalf("Expected %v, got: %v", ErrLogCorrupt, err)
+	}
+}
+
+func TestStateVersions(t *testing.T) {
+	dir := t.TempDir()
+	writeEnvelope := func(name string, data []byte) string {
+		t.Helper()
+		sha := sha1.Sum(data)
+		buf, err := json.Marshal(envelope{SHA: sha[:], Data: data})
+		if err != nil {
+			t.Fatalf("Error Marshaling envelope: %v", err)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, buf, 0660); err != nil {
+			t.Fatalf("Error writing envelope: %v", err)
+		}
+		return path
+	}
+
+	// Version 1 files have no version.
+	v1 := writeEnvelope("v1", []byte(`{"CurrentTerm":3,"VotedFor":"a"}`))
+	ps, err := LoadPersistentState(v1)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if ps.Version != 1 || ps.CurrentTerm != 3 || ps.VotedFor != "a" || ps.ClusterName != "" {
+		t.Fatalf("Unexpected v1 state: %+v", ps)
+	}
+
+	// Version 2 files are written by writeState.
+	v2 := filepath.Join(dir, "v2")
+	node := &Node{opts: defaultOptions(), info: ClusterInfo{Name: "foo", Size: 3}, logPath: v2, term: 4, vote: "b"}
+	if err := node.writeState(); err != nil {
+		t.Fatalf("Unexpected error writing state: %v", err)
+	}
+	buf, err := os.ReadFile(v2)
+	if err != nil {
+		t.Fatalf("Could not read logfile: %v", err)
+	}
+	env := &envelope{}
+	if err := json.Unmarshal(buf, env); err != nil {
+		t.Fatalf("Error unmarshalling envelope: %v", err)
+	}
+	if !bytes.HasPrefix(env.Data, []byte(`{"Version":2,`)) {
+		t.Fatalf("Expected the version to be encoded first, got %s", env.Data)
+	}
+	ps, err = LoadPersistentState(v2)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if ps.Version != STATE_VERSION || ps.CurrentTerm != 4 || ps.VotedFor != "b" || ps.ClusterName != "foo" {
+		t.Fatalf("Unexpected v2 state: %+v", ps)
+	}
+
+	// Unknown versions are rejected.
+	v99 := writeEnvelope("v99", []byte(`{"Version":99,"Term":"x"}`))
+	_, err = LoadPersistentState(v99)
+	var lerr *LogError
+	if !errors.Is(err, ErrLogVersion) || !errors.As(err, &lerr) || lerr.Kind != KindEncoding {
+		t.Fatalf("Expected %v, got: %v", ErrLogVersion, err)
+	}
 }
 
 func TestVerification(t *testing.T) {
@@ -268,21 +733,949 @@ func TestVerificationFallback(t *testing.T) {
 	}
 }
 
-// This will test that we have the correct saved state at any point in time.
-func testStateOfNode(t *testing.T, node *Node) {
-	if node == nil {
-		stackFatalf(t, "Expected a non-nil Node")
+func TestLegacyDigestReported(t *testing.T) {
+	ci := ClusterInfo{Name: "foo", Size: 3}
+	hand, rpc, log := genNodeArgs(t)
+
+	// Write a file with the legacy digest.
+	node := &Node{opts: defaultOptions(), info: ci, logPath: log, term: 2, vote: "foo"}
+	if err := node.writeState(); err != nil {
+		t.Fatalf("Unexpected error writing state: %v", err)
 	}
-	ps, err := node.readState(node.logPath)
+	buf, err := os.ReadFile(log)
 	if err != nil {
-		stackFatalf(t, "Err reading state: %q\n", err)
+		t.Fatalf("Could not read logfile: %v", err)
 	}
-	if ps.CurrentTerm != node.CurrentTerm() {
-		stackFatalf(t, "Expected CurrentTerm of %d, got %d\n",
-			node.CurrentTerm(), ps.CurrentTerm)
+	env := &envelope{}
+	if err := json.Unmarshal(buf, env); err != nil {
+		t.Fatalf("Error unmarshalling envelope: %v", err)
 	}
-	if ps.VotedFor != node.CurrentVote() {
-		stackFatalf(t, "Expected a vote for %q, got %q\n",
-			node.CurrentVote(), ps.VotedFor)
+	hashOfNothing := sha1.Sum(nil)
+	env.SHA = append(bytes.Clone(env.Data), hashOfNothing[:]...)
+	toWrite, err := json.Marshal(env)
+	if err != nil {
+		t.Fatalf("Error Marshaling envelope: %v", err)
+	}
+	if err := os.WriteFile(log, toWrite, 0660); err != nil {
+		t.Fatalf("Error writing envelope: %v", err)
+	}
+
+	metrics := &counterMetrics{counters: make(map[string]int64)}
+	node, err = New(ci, hand, rpc, log, WithMetrics(metrics))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+
+	if term := node.CurrentTerm(); term != 2 {
+		t.Fatalf("Expected term 2, got %d", term)
+	}
+	if c := metrics.counter(METRIC_STATE_LEGACY_DIGEST); c != 1 {
+		t.Fatalf("Expected the legacy digest to be reported once, got %d", c)
+	}
+}
+
+func TestFlushAndExportState(t *testing.T) {
+	ci := ClusterInfo{Name: "foo", Size: 3}
+	hand, rpc, log := genNodeArgs(t)
+	node, err := New(ci, hand, rpc, log)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+
+	// Delay elections
+	node.mu.Lock()
+	node.electTimer.Reset(10 * time.Second)
+	node.mu.Unlock()
+
+	// Dirty the state without writing it.
+	node.setTerm(7)
+	node.setVote("foo")
+
+	if err := node.Flush(); err != nil {
+		t.Fatalf("Unexpected error flushing state: %v", err)
+	}
+	testStateOfNode(t, node)
+
+	// The export matches the log.
+	var buf bytes.Buffer
+	if err := node.ExportState(&buf); err != nil {
+		t.Fatalf("Unexpected error exporting state: %v", err)
+	}
+	onDisk, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatalf("Could not read logfile: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), onDisk) {
+		t.Fatalf("Expected export %q to match the log %q", buf.Bytes(), onDisk)
+	}
+}
+
+func TestLogClusterMismatch(t *testing.T) {
+	ci := ClusterInfo{Name: "foo", Size: 3}
+	hand, rpc, log := genNodeArgs(t)
+
+	// Write some state under the cluster name "foo".
+	node := &Node{opts: defaultOptions(), info: ci, logPath: log, term: 5, vote: "fake"}
+	if err := node.writeState(); err != nil {
+		t.Fatalf("Unexpected error writing state: %v", err)
+	}
+
+	// A node of another cluster refuses the log.
+	other := ClusterInfo{Name: "bar", Size: 3}
+	_, err := New(other, hand, rpc, log)
+	if !errors.Is(err, ErrClusterMismatch) {
+		t.Fatalf("Expected %v, got: %v", ErrClusterMismatch, err)
+	}
+	var lerr *LogError
+	if !errors.As(err, &lerr) || lerr.Kind != KindClusterMismatch {
+		t.Fatalf("Expected a LogError of kind %s, got %v", KindClusterMismatch, err)
+	}
+
+	// Unless asked to reset the state.
+	node2, err := New(other, hand, rpc, log, WithResetOnClusterMismatch())
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node2.Close()
+	if term := node2.CurrentTerm(); term != 0 {
+		t.Fatalf("Expected the term to be reset, got %d", term)
+	}
+	if vote := node2.CurrentVote(); vote != NO_VOTE {
+		t.Fatalf("Expected the vote to be reset, got %q", vote)
+	}
+}
+
+func TestLogWithoutClusterName(t *testing.T) {
+	ci := ClusterInfo{Name: "foo", Size: 3}
+	hand, rpc, log := genNodeArgs(t)
+
+	// Logs written before the cluster name was recorded are adopted.
+	node := &Node{opts: defaultOptions(), logPath: log, term: 5, vote: "fake"}
+	if err := node.writeState(); err != nil {
+		t.Fatalf("Unexpected error writing state: %v", err)
+	}
+	node2, err := New(ci, hand, rpc, log)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node2.Close()
+	if term := node2.CurrentTerm(); term != 5 {
+		t.Fatalf("Expected the term to be adopted, got %d", term)
+	}
+}
+
+// This will test that we have the correct saved state at any point in time.
+func testStateOfNode(t *testing.T, node *Node) {
+	if node == nil {
+		stackFatalf(t, "Expected a non-nil Node")
+	}
+	ps, err := node.readState(node.logPath)
+	if err != nil {
+		stackFatalf(t, "Err reading state: %q\n", err)
+	}
+	if ps.CurrentTerm != node.CurrentTerm() {
+		stackFatalf(t, "Expected CurrentTerm of %d, got %d\n",
+			node.CurrentTerm(), ps.CurrentTerm)
+	}
+	if ps.VotedFor != node.CurrentVote() {
+		stackFatalf(t, "Expected a vote for %q, got %q\n",
+			node.CurrentVote(), ps.VotedFor)
+	}
+}
+
+func TestStateStoreMetrics(t *testing.T) {
+	// Simulate a slow, then failing, disk.
+	var full atomic.Bool
+	defer func(wf func(string, []byte, fs.FileMode) error) { writeFile = wf }(writeFile)
+	writeFile = func(name string, data []byte, perm fs.FileMode) error {
+		time.Sleep(20 * time.Millisecond)
+		if full.Load() {
+			return syscall.ENOSPC
+		}
+		return os.WriteFile(name, data, perm)
+	}
+
+	ci := ClusterInfo{Name: "slow", Size: 3}
+	hand, rpc, log := genNodeArgs(t)
+	metrics := &counterMetrics{counters: make(map[string]int64)}
+	node, err := New(ci, hand, rpc, log, WithMetrics(metrics))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+	metrics.mu.Lock()
+	_, loaded := metrics.durations[METRIC_STATE_LOAD_LATENCY]
+	metrics.mu.Unlock()
+	if !loaded {
+		t.Fatal("Expected the load latency to be recorded")
+	}
+	// A log without state is not a failed read.
+	if c := metrics.counter(METRIC_STATE_LOAD_ERRORS); c != 0 {
+		t.Fatalf("Expected no load errors, got %d", c)
+	}
+
+	if err := node.Flush(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if d := metrics.duration(METRIC_STATE_SAVE_LATENCY); d < 20*time.Millisecond {
+		t.Fatalf("Expected a save latency of at least 20ms, got %v", d)
+	}
+	full.Store(true)
+	if err := node.Flush(); err == nil {
+		t.Fatal("Expected an error writing to a full disk")
+	}
+	if c := metrics.counter(METRIC_STATE_SAVE_ERRORS); c != 1 {
+		t.Fatalf("Expected 1 save error, got %d", c)
+	}
+
+	// A corrupt log is a failed read.
+	if err := os.WriteFile(log, []byte("ZZZZ"), 0660); err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+	node.readState(log)
+	if c := metrics.counter(METRIC_STATE_LOAD_ERRORS); c != 1 {
+		t.Fatalf("Expected 1 load error, got %d", c)
+	}
+}
+
+func TestStateWriteFailure(t *testing.T) {
+	// Simulate a full disk.
+	var full atomic.Bool
+	defer func(wf func(string, []byte, fs.FileMode) error) { writeFile = wf }(writeFile)
+	writeFile = func(name string, data []byte, perm fs.FileMode) error {
+		if full.Load() {
+			return syscall.ENOSPC
+		}
+		return os.WriteFile(name, data, perm)
+	}
+
+	ci := ClusterInfo{Name: "full", Size: 3}
+	_, rpc, log := genNodeArgs(t)
+	scCh := make(chan StateChange, 1)
+	errCh := make(chan error, 8)
+	metrics := &counterMetrics{counters: make(map[string]int64)}
+	node, err := New(ci, NewChanHandler(scCh, errCh), rpc, log, WithMetrics(metrics))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+
+	fake := fakeNode("fake")
+	fake.VoteResponses = make(chan *pb.VoteResponse, 1)
+	mockRegisterPeer(fake)
+	defer mockUnregisterPeer(fake.id)
+
+	expectVote := func(term uint64, granted bool) {
+		t.Helper()
+		node.VoteRequests <- &pb.VoteRequest{Term: term, Candidate: fake.id}
+		vresp := <-fake.VoteResponses
+		if vresp.Granted != granted {
+			t.Fatalf("Expected vote for term %d to be granted=%v", term, granted)
+		}
+	}
+
+	full.Store(true)
+	expectVote(1, false)
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, syscall.ENOSPC) {
+			t.Fatalf("Expected %v, got: %v", syscall.ENOSPC, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the write failure to be reported")
+	}
+	if g := metrics.gauge(METRIC_STATE_DEGRADED); g != 1 {
+		t.Fatalf("Expected the degraded gauge to be 1, got %v", g)
+	}
+
+	// We keep refusing to vote, and do not campaign.
+	expectVote(2, false)
+	node.mu.Lock()
+	node.electTimer.Reset(time.Millisecond)
+	node.mu.Unlock()
+	select {
+	case <-fake.VoteRequests:
+		t.Fatal("Expected no campaign while the state can not be written")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// We recover once writes succeed again.
+	full.Store(false)
+	expectVote(3, true)
+	if g := metrics.gauge(METRIC_STATE_DEGRADED); g != 0 {
+		t.Fatalf("Expected the degraded gauge to be 0, got %v", g)
+	}
+}
+
+func TestLogDirRemoved(t *testing.T) {
+	ci := ClusterInfo{Name: "removed", Size: 3}
+	fake := fakeNode("fake")
+	fake.VoteResponses = make(chan *pb.VoteResponse, 1)
+	mockRegisterPeer(fake)
+	defer mockUnregisterPeer(fake.id)
+
+	newNode := func(opts ...Option) (*Node, string, chan error) {
+		t.Helper()
+		dir := filepath.Join(t.TempDir(), "data")
+		if err := os.Mkdir(dir, 0750); err != nil {
+			t.Fatalf("Error creating the log directory: %v", err)
+		}
+		log := filepath.Join(dir, "log")
+		errCh := make(chan error, 8)
+		node, err := New(ci, NewChanHandler(make(chan StateChange, 8), errCh), NewMockRpc(), log, opts...)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		// Delay elections
+		node.mu.Lock()
+		node.electTimer.Reset(10 * time.Second)
+		node.mu.Unlock()
+		return node, dir, errCh
+	}
+	expectVote := func(node *Node, term uint64, granted bool) {
+		t.Helper()
+		node.VoteRequests <- &pb.VoteRequest{Term: term, Candidate: fake.id}
+		if vresp := <-fake.VoteResponses; vresp.Granted != granted {
+			t.Fatalf("Expected vote for term %d to be granted=%v", term, granted)
+		}
+	}
+	expectErr := func(errCh chan error) {
+		t.Helper()
+		if err := errWait(t, errCh); !errors.Is(err, ErrLogDirRemoved) {
+			t.Fatalf("Expected %v, got: %v", ErrLogDirRemoved, err)
+		}
+	}
+
+	// By default, we refuse to vote rather than vote without a record.
+	node, dir, errCh := newNode()
+	defer node.Close()
+	os.RemoveAll(dir)
+	expectVote(node, 1, false)
+	expectErr(errCh)
+	if !node.isDegraded() {
+		t.Fatal("Expected the node to be degraded")
+	}
+	if _, err := os.Stat(dir); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected the directory to stay removed, got: %v", err)
+	}
+
+	// The directory is recreated and the vote recorded.
+	node, dir, errCh = newNode(WithLogDirRecreation())
+	defer node.Close()
+	os.RemoveAll(dir)
+	expectVote(node, 1, true)
+	expectErr(errCh)
+	ps, err := node.readState(node.LogPath())
+	if err != nil {
+		t.Fatalf("Expected the state to be written, got: %v", err)
+	}
+	if ps.CurrentTerm != 1 || ps.VotedFor != fake.id {
+		t.Fatalf("Expected the vote for %q at term 1 to be recorded, got %+v", fake.id, ps)
+	}
+}
+
+func TestWithoutPersistence(t *testing.T) {
+	ci := ClusterInfo{Name: "ephemeral", Size: 3}
+	hub := NewMockHub()
+	dir := t.TempDir()
+	nodes := make([]*Node, 3)
+	for i := range nodes {
+		// The path is ignored, and may be empty.
+		log := ""
+		if i == 0 {
+			log = filepath.Join(dir, "log")
+		}
+		node, err := New(ci, &dummyHandler{}, hub.NewRpc(), log, WithoutPersistence())
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		defer node.Close()
+		nodes[i] = node
+	}
+	expectedClusterState(t, nodes, 1, 2, 0)
+
+	leader := findLeader(nodes)
+	if err := leader.Flush(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if err := leader.ExportState(&bytes.Buffer{}); err != ErrLogNoState {
+		t.Fatalf("Expected %v, got: %v", ErrLogNoState, err)
+	}
+	for _, n := range nodes {
+		n.Close()
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Fatalf("Expected no file to be created, got %v: %v", entries, err)
+	}
+}
+
+// hookHandler adds metadata to the state written, or fails the write.
+type hookHandler struct {
+	dummyHandler
+	fail atomic.Bool
+}
+
+var errHook = errors.New("hook failed")
+
+func (h *hookHandler) BeforeWriteState(ps PersistentState) (PersistentState, error) {
+	if h.fail.Load() {
+		return ps, errHook
+	}
+	ps.Metadata = map[string]string{"zone": "a"}
+	// This is ignored.
+	ps.CurrentTerm = 42
+	return ps, nil
+}
+
+func TestStateWriteHook(t *testing.T) {
+	ci := ClusterInfo{Name: "hook", Size: 3}
+	_, rpc, log := genNodeArgs(t)
+	hand := &hookHandler{}
+	node, err := New(ci, hand, rpc, log)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+
+	node.setTerm(3)
+	if err := node.Flush(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	ps, err := LoadPersistentState(log)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if zone := ps.Metadata["zone"]; zone != "a" {
+		t.Fatalf("Expected the hook metadata to be written, got %v", ps.Metadata)
+	}
+	if ps.CurrentTerm != 3 {
+		t.Fatalf("Expected term 3, got %d", ps.CurrentTerm)
+	}
+
+	// A failing hook aborts the vote.
+	fake := fakeNode("fake")
+	fake.VoteResponses = make(chan *pb.VoteResponse, 1)
+	mockRegisterPeer(fake)
+	defer mockUnregisterPeer(fake.id)
+
+	hand.fail.Store(true)
+	if err := node.Flush(); !errors.Is(err, errHook) {
+		t.Fatalf("Expected %v, got: %v", errHook, err)
+	}
+	node.VoteRequests <- &pb.VoteRequest{Term: 4, Candidate: fake.id}
+	if vresp := <-fake.VoteResponses; vresp.Granted {
+		t.Fatal("Expected the vote to be denied when the hook fails")
+	}
+}
+
+// writtenHandler records the bytes of the state written.
+type writtenHandler struct {
+	dummyHandler
+	mu      sync.Mutex
+	written [][]byte
+	gens    []uint64
+}
+
+func (h *writtenHandler) AfterWriteState(data []byte, gen uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.written = append(h.written, data)
+	h.gens = append(h.gens, gen)
+}
+
+func TestStateWrittenHook(t *testing.T) {
+	ci := ClusterInfo{Name: "written", Size: 3}
+	_, rpc, log := genNodeArgs(t)
+	hand := &writtenHandler{}
+	node, err := New(ci, hand, rpc, log)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+	// Delay elections
+	node.mu.Lock()
+	node.electTimer.Reset(10 * time.Second)
+	node.mu.Unlock()
+
+	hand.mu.Lock()
+	start := len(hand.written)
+	hand.mu.Unlock()
+	for term := uint64(3); term <= 4; term++ {
+		node.setTerm(term)
+		node.setVote("a")
+		if err := node.Flush(); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+	}
+
+	hand.mu.Lock()
+	written, gens := hand.written[start:], hand.gens[start:]
+	hand.mu.Unlock()
+	if len(written) != 2 {
+		t.Fatalf("Expected 2 writes, got %d", len(written))
+	}
+	for i, data := range written {
+		ps, err := DecodeState(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Expected the bytes to parse, got: %v", err)
+		}
+		if term := uint64(3 + i); ps.CurrentTerm != term || ps.VotedFor != "a" || ps.ClusterName != ci.Name {
+			t.Fatalf("Expected the state written at term %d, got %+v", term, ps)
+		}
+		if i > 0 && gens[i] != gens[i-1]+1 {
+			t.Fatalf("Expected increasing generations, got %v", gens)
+		}
+	}
+	// The last bytes are those of the log file.
+	if content, err := os.ReadFile(log); err != nil || !bytes.Equal(content, written[1]) {
+		t.Fatalf("Expected the bytes of the log file, got: %v", err)
+	}
+
+	// A failed write is not reported.
+	if err := os.Chmod(log, 0400); err != nil {
+		t.Fatalf("Error changing the log permissions: %v", err)
+	}
+	if err := node.Flush(); err == nil {
+		t.Fatal("Expected the write to fail")
+	}
+	hand.mu.Lock()
+	defer hand.mu.Unlock()
+	if len(hand.written) != start+2 {
+		t.Fatalf("Expected no report of the failed write, got %d writes", len(hand.written)-start)
+	}
+}
+
+func TestStateFormat(t *testing.T) {
+	ci := ClusterInfo{Name: "foo", Size: 3}
+	envelopeOf := func(data, sha []byte) []byte {
+		t.Helper()
+		buf, err := json.Marshal(envelope{SHA: sha, Data: data})
+		if err != nil {
+			t.Fatalf("Error Marshaling envelope: %v", err)
+		}
+		return buf
+	}
+	v1 := []byte(`{"CurrentTerm":3,"VotedFor":"a"}`)
+	v1Sum := sha1.Sum(v1)
+	hashOfNothing := sha1.Sum(nil)
+	v2 := []byte(`{"Version":2,"CurrentTerm":3,"VotedFor":"a","ClusterName":"foo"}`)
+	v2Sum := sha1.Sum(v2)
+
+	tests := []struct {
+		content []byte
+		format  StateFormat
+	}{
+		{nil, STATE_FORMAT_NONE},
+		{envelopeOf(v1, append(bytes.Clone(v1), hashOfNothing[:]...)), STATE_FORMAT_LEGACY_DIGEST},
+		{envelopeOf(v1, v1Sum[:]), STATE_FORMAT_V1},
+		{envelopeOf(v2, v2Sum[:]), STATE_FORMAT_CURRENT},
+	}
+	for _, tc := range tests {
+		hand, rpc, log := genNodeArgs(t)
+		if err := os.WriteFile(log, tc.content, 0660); err != nil {
+			t.Fatalf("Error writing file: %v", err)
+		}
+		node, err := New(ci, hand, rpc, log)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if format := node.StateFormat(); format != tc.format {
+			t.Fatalf("Expected format %s, got %s", tc.format, format)
+		}
+		node.Close()
+	}
+}
+
+func TestStateWrittenBeforeVote(t *testing.T) {
+	type event struct {
+		ev   traceEvent
+		term uint64
+	}
+	var mu sync.Mutex
+	events := make(map[*Node][]event)
+	hook := traceFunc(func(n *Node, ev traceEvent, term uint64) {
+		mu.Lock()
+		defer mu.Unlock()
+		events[n] = append(events[n], event{ev, term})
+	})
+	traceHook.Store(&hook)
+	defer traceHook.Store(nil)
+
+	// A lone member of a cluster keeps campaigning.
+	ci := ClusterInfo{Name: "trace", Size: 3}
+	hand, rpc, log := genNodeArgs(t)
+	node, err := New(ci, hand, rpc, log, WithHeartbeatInterval(5*time.Millisecond, 10, 20))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+	if term := waitForTerm(node, 4); term < 4 {
+		t.Fatalf("Expected several campaigns, got term %d", term)
+	}
+
+	// And grants its vote to a newer candidate.
+	fake := fakeNode("fake")
+	mockRegisterPeer(fake)
+	defer mockUnregisterPeer(fake.id)
+	node.VoteRequests <- &pb.VoteRequest{Term: 100, Candidate: fake.id}
+	if vresp := <-fake.VoteResponses; !vresp.Granted {
+		t.Fatal("Expected the vote to be granted")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	written := make(map[uint64]bool)
+	votes := 0
+	for _, e := range events[node] {
+		switch e.ev {
+		case traceStateWritten:
+			written[e.term] = true
+		case traceVoteRequested, traceVoteGranted:
+			if !written[e.term] {
+				t.Fatalf("Expected the state of term %d to be written before voting", e.term)
+			}
+			votes++
+		}
+	}
+	if votes < 4 {
+		t.Fatalf("Expected at least 4 votes, got %d", votes)
+	}
+}
+
+func waitForTerm(node *Node, term uint64) uint64 {
+	timeout := time.Now().Add(5 * time.Second)
+	for time.Now().Before(timeout) && node.CurrentTerm() < term {
+		time.Sleep(5 * time.Millisecond)
+	}
+	return node.CurrentTerm()
+}
+
+func TestStateWriteAcrossFilesystems(t *testing.T) {
+	// Simulate a log bind mounted from another filesystem.
+	var crossDevice atomic.Bool
+	defer func(rf func(string, string) error) { renameFile = rf }(renameFile)
+	renameFile = func(from, to string) error {
+		if crossDevice.Load() {
+			return &os.LinkError{Op: "rename", Old: from, New: to, Err: syscall.EXDEV}
+		}
+		return os.Rename(from, to)
+	}
+
+	ci := ClusterInfo{Name: "xdev", Size: 3}
+	_, rpc, log := genNodeArgs(t)
+	errCh := make(chan error, 8)
+	node, err := New(ci, NewChanHandler(make(chan StateChange, 8), errCh), rpc, log)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+	// Delay elections
+	node.mu.Lock()
+	node.electTimer.Reset(10 * time.Second)
+	node.mu.Unlock()
+
+	expectState := func(term uint64, vote string) {
+		t.Helper()
+		ps, _, err := loadState(log)
+		if err != nil {
+			t.Fatalf("Expected no error loading the state, got: %v", err)
+		}
+		if ps.CurrentTerm != term || ps.VotedFor != vote {
+			t.Fatalf("Expected term %d and vote %q, got %d and %q", term, vote, ps.CurrentTerm, ps.VotedFor)
+		}
+		// No temporary file is left behind.
+		if entries, _ := os.ReadDir(filepath.Dir(log)); len(entries) != 1 {
+			t.Fatalf("Expected only the log in its directory, got %d entries", len(entries))
+		}
+	}
+
+	// The log is rewritten in place, with a single warning.
+	crossDevice.Store(true)
+	for term := uint64(1); term <= 2; term++ {
+		node.setTerm(term)
+		node.setVote("a")
+		if err := node.Flush(); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		expectState(term, "a")
+	}
+	if err := errWait(t, errCh); !errors.Is(err, ErrNonAtomicWrite) {
+		t.Fatalf("Expected %v, got: %v", ErrNonAtomicWrite, err)
+	}
+	select {
+	case err := <-errCh:
+		t.Fatalf("Expected a single warning, got: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// Atomic again once the rename works.
+	crossDevice.Store(false)
+	node.setTerm(3)
+	if err := node.Flush(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	expectState(3, "a")
+}
+
+func TestRelativeLogPath(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer os.Chdir(wd)
+
+	hand, rpc, _ := genNodeArgs(t)
+	ci := ClusterInfo{Name: "relative", Size: 3}
+	node, err := New(ci, hand, rpc, "graft.log")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+	// Delay elections
+	node.mu.Lock()
+	node.electTimer.Reset(10 * time.Second)
+	node.mu.Unlock()
+	logPath := filepath.Join(dir, "graft.log")
+	if node.LogPath() != logPath {
+		t.Fatalf("Expected log path %q, got %q", logPath, node.LogPath())
+	}
+
+	// Move elsewhere and save a new term.
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	node.setTerm(42)
+	if err := node.writeState(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if _, err := os.Stat("graft.log"); !os.IsNotExist(err) {
+		t.Fatalf("Expected no log in the new working directory, got: %v", err)
+	}
+	ps, err := node.readState(logPath)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if ps.CurrentTerm != 42 {
+		t.Fatalf("Expected term 42 in the original log, got %d", ps.CurrentTerm)
+	}
+}
+
+func TestCompactState(t *testing.T) {
+	dir := t.TempDir()
+	opts := defaultOptions()
+	opts.compactState = true
+	compact := filepath.Join(dir, "compact")
+	node := &Node{opts: opts, info: ClusterInfo{Name: "foo", Size: 3}, logPath: compact, term: 4, vote: "b"}
+	if err := node.writeState(); err != nil {
+		t.Fatalf("Unexpected error writing state: %v", err)
+	}
+	buf, err := os.ReadFile(compact)
+	if err != nil {
+		t.Fatalf("Could not read logfile: %v", err)
+	}
+	if want := `"d":{"v":3,"t":4,"f":"b","c":"foo"}}`; !bytes.HasSuffix(buf, []byte(want)) {
+		t.Fatalf("Expected the compact state to end with %s, got %s", want, buf)
+	}
+	ps, err := LoadPersistentState(compact)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if ps.Version != STATE_VERSION_COMPACT || ps.CurrentTerm != 4 || ps.VotedFor != "b" || ps.ClusterName != "foo" {
+		t.Fatalf("Unexpected compact state: %+v", ps)
+	}
+
+	// The digest still covers the compact state.
+	tampered := bytes.Replace(buf, []byte(`"t":4`), []byte(`"t":5`), 1)
+	if err := os.WriteFile(compact, tampered, 0660); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if _, err := LoadPersistentState(compact); !errors.Is(err, ErrLogCorrupt) {
+		t.Fatalf("Expected %v, got: %v", ErrLogCorrupt, err)
+	}
+	if err := os.WriteFile(compact, buf, 0660); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	// Either format is read whichever is written.
+	hand, rpc, _ := genNodeArgs(t)
+	ci := ClusterInfo{Name: "foo", Size: 3}
+	defaulted, err := New(ci, hand, rpc, compact)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if term, vote, _ := defaulted.VoteRecord(); term != 4 || vote != "b" {
+		t.Fatalf("Expected term 4 and vote %q from the compact state, got %d and %q", "b", term, vote)
+	}
+	defaulted.Close()
+
+	full := filepath.Join(dir, "full")
+	node = &Node{opts: defaultOptions(), info: ci, logPath: full, term: 7, vote: "c"}
+	if err := node.writeState(); err != nil {
+		t.Fatalf("Unexpected error writing state: %v", err)
+	}
+	compacted, err := New(ci, hand, rpc, full, WithCompactState())
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer compacted.Close()
+	if term, vote, _ := compacted.VoteRecord(); term != 7 || vote != "c" {
+		t.Fatalf("Expected term 7 and vote %q from the default state, got %d and %q", "c", term, vote)
+	}
+	if compacted.StateFormat() != STATE_FORMAT_CURRENT {
+		t.Fatalf("Expected %v, got %v", STATE_FORMAT_CURRENT, compacted.StateFormat())
+	}
+}
+
+func TestWriteRetry(t *testing.T) {
+	// Simulate a disk failing transiently twice per write.
+	var failures atomic.Int32
+	defer func(wf func(string, []byte, fs.FileMode) error) { writeFile = wf }(writeFile)
+	writeFile = func(name string, data []byte, perm fs.FileMode) error {
+		if failures.Add(-1) >= 0 {
+			return syscall.EINTR
+		}
+		return os.WriteFile(name, data, perm)
+	}
+
+	ci := ClusterInfo{Name: "retry", Size: 3}
+	hand, rpc, log := genNodeArgs(t)
+	metrics := &counterMetrics{counters: make(map[string]int64)}
+	if _, err := New(ci, hand, rpc, log, WithWriteRetry(0, time.Millisecond)); err != ErrInvalidOption {
+		t.Fatalf("Expected %v, got: %v", ErrInvalidOption, err)
+	}
+	node, err := New(ci, hand, rpc, log, WithMetrics(metrics), WithWriteRetry(2, time.Millisecond))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+	// Delay elections
+	node.mu.Lock()
+	node.electTimer.Reset(10 * time.Second)
+	node.mu.Unlock()
+
+	failures.Store(2)
+	node.setTerm(3)
+	if err := node.Flush(); err != nil {
+		t.Fatalf("Expected the write to succeed once retried, got: %v", err)
+	}
+	if node.isDegraded() {
+		t.Fatal("Expected the node not to be degraded")
+	}
+	if c := metrics.counter(METRIC_STATE_SAVE_RETRIES); c != 2 {
+		t.Fatalf("Expected 2 retries, got %d", c)
+	}
+	if ps, err := node.readState(log); err != nil || ps.CurrentTerm != 3 {
+		t.Fatalf("Expected term 3 to be written, got %+v, %v", ps, err)
+	}
+
+	// The retries are bounded.
+	failures.Store(3)
+	if err := node.Flush(); !errors.Is(err, syscall.EINTR) {
+		t.Fatalf("Expected %v, got: %v", syscall.EINTR, err)
+	}
+	if !node.isDegraded() {
+		t.Fatal("Expected the node to be degraded")
+	}
+
+	// Other errors are not retried.
+	defer func(wf func(string, []byte, fs.FileMode) error) { writeFile = wf }(writeFile)
+	writeFile = func(name string, data []byte, perm fs.FileMode) error {
+		failures.Add(1)
+		return syscall.ENOSPC
+	}
+	failures.Store(0)
+	if err := node.Flush(); !errors.Is(err, syscall.ENOSPC) || failures.Load() != 1 {
+		t.Fatalf("Expected a single attempt failing with %v, got %d and: %v", syscall.ENOSPC, failures.Load(), err)
+	}
+
+	// The retries wait at most half the election timeout in total.
+	writeFile = func(name string, data []byte, perm fs.FileMode) error {
+		if failures.Add(-1) >= 0 {
+			return syscall.EINTR
+		}
+		return os.WriteFile(name, data, perm)
+	}
+	failures.Store(0)
+	hand, rpc, log = genNodeArgs(t)
+	capped, err := New(ci, hand, rpc, log,
+		WithTimeoutStrategy(FixedTimeout(20*time.Millisecond)), WithWriteRetry(5, time.Second))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer capped.Close()
+	capped.mu.Lock()
+	capped.electTimer.Reset(10 * time.Second)
+	capped.mu.Unlock()
+	failures.Store(10)
+	start := time.Now()
+	if err := capped.Flush(); !errors.Is(err, syscall.EINTR) {
+		t.Fatalf("Expected %v, got: %v", syscall.EINTR, err)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Fatalf("Expected the retries to give up well before their backoff, took %v", elapsed)
+	}
+}
+
+func TestStrictEmptyLog(t *testing.T) {
+	ci := ClusterInfo{Name: "empty", Size: 3}
+	hand, rpc, _ := genNodeArgs(t)
+	dir := t.TempDir()
+
+	// A log we create is a fresh start, and is never left empty.
+	fresh := filepath.Join(dir, "fresh")
+	node, err := New(ci, hand, rpc, fresh, WithStrictEmptyLog())
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if term := node.CurrentTerm(); term != 0 {
+		t.Fatalf("Expected a fresh start, got term %d", term)
+	}
+	if ps, err := LoadPersistentState(fresh); err != nil || ps.CurrentTerm != 0 {
+		t.Fatalf("Expected the fresh state to be written, got %+v, %v", ps, err)
+	}
+	node.Close()
+
+	// A log truncated to zero is corrupt.
+	truncated := filepath.Join(dir, "truncated")
+	stale := &Node{opts: defaultOptions(), info: ci, logPath: truncated, term: 5, vote: "a"}
+	if err := stale.writeState(); err != nil {
+		t.Fatalf("Unexpected error writing state: %v", err)
+	}
+	if err := os.Truncate(truncated, 0); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	_, err = New(ci, hand, rpc, truncated, WithStrictEmptyLog())
+	var lerr *LogError
+	if !errors.Is(err, ErrLogCorrupt) || !errors.Is(err, ErrLogEmpty) || !errors.As(err, &lerr) || lerr.Kind != KindCorrupt {
+		t.Fatalf("Expected %v wrapping %v, got: %v", ErrLogCorrupt, ErrLogEmpty, err)
+	}
+
+	// By default, it is a fresh start.
+	node, err = New(ci, hand, rpc, truncated)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if term := node.CurrentTerm(); term != 0 {
+		t.Fatalf("Expected a fresh start, got term %d", term)
+	}
+	node.Close()
+
+	// And it can be reset as any corrupt log.
+	if err := os.WriteFile(truncated, nil, 0660); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	node, err = New(ci, hand, rpc, truncated, WithStrictEmptyLog(), WithResetOnCorruptLog())
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+	if ps, err := LoadPersistentState(truncated); err != nil || ps.CurrentTerm != 0 {
+		t.Fatalf("Expected the reset state to be written, got %+v, %v", ps, err)
 	}
 }
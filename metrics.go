@@ -0,0 +1,70 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+import (
+	"time"
+)
+
+// Names of the metrics reported to a Metrics hook.
+const (
+	// Heartbeat round-trip time to a peer, labeled with "peer".
+	METRIC_PEER_LATENCY = "graft_peer_latency"
+	// Corrupt log files detected at startup, labeled with "path".
+	METRIC_STATE_CORRUPT = "graft_state_corrupt"
+	// 1 while the state can not be written, e.g. on a full disk, else 0.
+	METRIC_STATE_DEGRADED = "graft_state_degraded"
+	// Log files read with the legacy digest, which should be migrated
+	// by writing them again, labeled with "path".
+	METRIC_STATE_LEGACY_DIGEST = "graft_state_legacy_digest"
+	// Time taken to write and to read the state of the log file.
+	METRIC_STATE_SAVE_LATENCY = "graft_state_save_latency"
+	METRIC_STATE_LOAD_LATENCY = "graft_state_load_latency"
+	// Failed writes and reads of the log file. A log file without
+	// state is not counted as a failed read.
+	METRIC_STATE_SAVE_ERRORS = "graft_state_save_errors"
+	METRIC_STATE_LOAD_ERRORS = "graft_state_load_errors"
+	// Writes of the log file retried after a transient error, see
+	// WithWriteRetry.
+	METRIC_STATE_SAVE_RETRIES = "graft_state_save_retries"
+	// Times this node learned of a new LEADER, including itself.
+	METRIC_LEADERSHIP_CHANGES = "graft_leadership_changes"
+	// Peers found advertising an incompatible protocol version,
+	// labeled with "peer" and "version".
+	METRIC_INCOMPATIBLE_PEERS = "graft_incompatible_peers"
+)
+
+// Label qualifies a metric, e.g. with the peer it applies to.
+type Label struct {
+	Name, Value string
+}
+
+// Metrics is a hook to export the internal measurements of a node
+// to a metrics system. Implementations must be safe for concurrent
+// use and should not block.
+type Metrics interface {
+	// IncrCounter increments the counter name by delta.
+	IncrCounter(name string, delta int64, labels ...Label)
+	// SetGauge sets the gauge name to value.
+	SetGauge(name string, value float64, labels ...Label)
+	// ObserveDuration records a duration sample for name.
+	ObserveDuration(name string, d time.Duration, labels ...Label)
+}
+
+// nopMetrics is used when no Metrics hook is set.
+type nopMetrics struct{}
+
+func (nopMetrics) IncrCounter(string, int64, ...Label)             {}
+func (nopMetrics) SetGauge(string, float64, ...Label)              {}
+func (nopMetrics) ObserveDuration(string, time.Duration, ...Label) {}
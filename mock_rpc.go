@@ -17,49 +17,66 @@ import (
 	"errors"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/nats-io/graft/pb"
 )
 
-var mu sync.Mutex
-var peers map[string]*Node
+// MockHub connects the nodes using a MockRpcDriver, in memory. Each hub
+// is an isolated network, so tests using their own hub can run in
+// parallel. It is safe for concurrent use.
+type MockHub struct {
+	mu    sync.Mutex
+	peers map[string]*Node
+}
 
-func init() {
-	peers = make(map[string]*Node)
+// NewMockHub returns an empty network.
+func NewMockHub() *MockHub {
+	return &MockHub{peers: make(map[string]*Node)}
 }
 
-func mockPeerCount() int {
-	mu.Lock()
-	defer mu.Unlock()
-	return len(peers)
+// NewRpc returns a driver connecting its node to the hub.
+func (h *MockHub) NewRpc() *MockRpcDriver {
+	return &MockRpcDriver{hub: h}
 }
 
-func mockPeers() []*Node {
-	mu.Lock()
-	defer mu.Unlock()
-	nodes := make([]*Node, 0, len(peers))
-	for _, p := range peers {
+// Count returns the number of nodes registered with the hub.
+func (h *MockHub) Count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.peers)
+}
+
+// Nodes returns the nodes registered with the hub.
+func (h *MockHub) Nodes() []*Node {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	nodes := make([]*Node, 0, len(h.peers))
+	for _, p := range h.peers {
 		nodes = append(nodes, p)
 	}
 	return nodes
 }
 
-func mockRegisterPeer(n *Node) {
-	mu.Lock()
-	defer mu.Unlock()
-	peers[n.id] = n
+// Register adds a node to the hub, e.g. a fake node that only has
+// channels. Drivers register their node on Init.
+func (h *MockHub) Register(n *Node) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.peers[n.id] = n
 }
 
-func mockUnregisterPeer(id string) {
-	mu.Lock()
-	defer mu.Unlock()
-	delete(peers, id)
+// Unregister removes the node with id from the hub.
+func (h *MockHub) Unregister(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.peers, id)
 }
 
-func mockResetPeers() {
-	mu.Lock()
-	defer mu.Unlock()
-	peers = make(map[string]*Node)
+func (h *MockHub) peer(id string) *Node {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.peers[id]
 }
 
 // Membership designations for split network simulations.
@@ -69,48 +86,108 @@ const (
 	GRP_B
 )
 
-// Handle a simulation of a split network.
-func mockSplitNetwork(grp []*Node) {
+// SplitNetwork simulates a split of the hub's network, between the
+// nodes of grp and the others.
+func (h *MockHub) SplitNetwork(grp []*Node) {
 	if len(grp) <= 0 {
 		return
 	}
-	mu.Lock()
-	defer mu.Unlock()
+	h.mu.Lock()
+	defer h.mu.Unlock()
 	// Reset all to other group, GrpB
-	for _, p := range peers {
-		rpc := p.rpc.(*MockRpcDriver)
+	for _, p := range h.peers {
+		rpc := p.transport().(*MockRpcDriver)
 		atomic.StoreInt32(&rpc.membership, GRP_B)
 	}
 	// Set passed in nodes to GrpA
 	for _, p := range grp {
-		rpc := p.rpc.(*MockRpcDriver)
+		rpc := p.transport().(*MockRpcDriver)
 		atomic.StoreInt32(&rpc.membership, GRP_A)
 	}
 }
 
+// RestoreNetwork restores the hub's network from a split.
+func (h *MockHub) RestoreNetwork() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, p := range h.peers {
+		rpc := p.transport().(*MockRpcDriver)
+		atomic.StoreInt32(&rpc.membership, NO_MEMBERSHIP)
+	}
+}
+
+// The network of the drivers created with NewMockRpc.
+var defaultHub = NewMockHub()
+
+func mockPeerCount() int {
+	return defaultHub.Count()
+}
+
+func mockRegisterPeer(n *Node) {
+	defaultHub.Register(n)
+}
+
+func mockUnregisterPeer(id string) {
+	defaultHub.Unregister(id)
+}
+
+func mockResetPeers() {
+	defaultHub.mu.Lock()
+	defer defaultHub.mu.Unlock()
+	defaultHub.peers = make(map[string]*Node)
+}
+
+// Handle a simulation of a split network.
+func mockSplitNetwork(grp []*Node) {
+	defaultHub.SplitNetwork(grp)
+}
+
 // Restore network from a split.
 func mockRestoreNetwork() {
-	mu.Lock()
-	defer mu.Unlock()
-	for _, p := range peers {
-		rpc := p.rpc.(*MockRpcDriver)
-		atomic.StoreInt32(&rpc.membership, NO_MEMBERSHIP)
+	defaultHub.RestoreNetwork()
+}
+
+// Handle a simulation of a broken link between two nodes.
+func mockBlockLink(a, b *Node) {
+	for _, pair := range [][2]*Node{{a, b}, {b, a}} {
+		rpc := pair[0].transport().(*MockRpcDriver)
+		rpc.mu.Lock()
+		if rpc.blocked == nil {
+			rpc.blocked = make(map[string]struct{})
+		}
+		rpc.blocked[pair[1].id] = struct{}{}
+		rpc.mu.Unlock()
 	}
 }
 
 type MockRpcDriver struct {
 	mu   sync.Mutex
 	node *Node
+	hub  *MockHub
 
 	// For testing
 	shouldFailInit bool
 	closeCalled    bool
 	shouldFailComm bool
 	membership     int32
+	responseDelay  time.Duration
+	replaced       bool
+	blocked        map[string]struct{}
 }
 
+// NewMockRpc returns a driver connected to the nodes of the other drivers
+// it returned. Use a MockHub for an isolated network.
 func NewMockRpc() *MockRpcDriver {
-	return &MockRpcDriver{}
+	return defaultHub.NewRpc()
+}
+
+// network returns the hub of the driver. Drivers built as literals use
+// the one of NewMockRpc.
+func (rpc *MockRpcDriver) network() *MockHub {
+	if rpc.hub == nil {
+		return defaultHub
+	}
+	return rpc.hub
 }
 
 func (rpc *MockRpcDriver) Init(n *Node) error {
@@ -118,36 +195,59 @@ func (rpc *MockRpcDriver) Init(n *Node) error {
 		return errors.New("RPC Failed to Init")
 	}
 	// Redo the channels to be buffered since we could be
-	// sending and block the select loops.
-	cSize := n.ClusterInfo().Size
-	n.VoteRequests = make(chan *pb.VoteRequest, cSize)
-	n.VoteResponses = make(chan *pb.VoteResponse, cSize)
-	n.HeartBeats = make(chan *pb.Heartbeat, cSize)
+	// sending and block the select loops. A node that swaps
+	// to a new driver already has them.
+	if cap(n.VoteRequests) == 0 {
+		cSize := n.ClusterInfo().Size
+		n.VoteRequests = make(chan *pb.VoteRequest, cSize)
+		n.VoteResponses = make(chan *pb.VoteResponse, cSize)
+		n.HeartBeats = make(chan *pb.Heartbeat, cSize)
+		n.HeartBeatResponses = make(chan *pb.HeartbeatResponse, cSize)
+	}
+
+	// The node is swapping drivers, its registration is now ours.
+	if old, ok := n.transport().(*MockRpcDriver); ok && old != rpc {
+		old.mu.Lock()
+		old.replaced = true
+		old.mu.Unlock()
+	}
 
-	mockRegisterPeer(n)
+	rpc.network().Register(n)
 	rpc.node = n
 	return nil
 }
 
 func (rpc *MockRpcDriver) Close() {
 	rpc.closeCalled = true
-	if rpc.node != nil {
-		mockUnregisterPeer(rpc.node.id)
+	// Keep the registration of a node that swapped to a new driver.
+	rpc.mu.Lock()
+	replaced := rpc.replaced
+	rpc.mu.Unlock()
+	if rpc.node != nil && !replaced {
+		rpc.network().Unregister(rpc.node.id)
 	}
 }
 
 // Test if we can talk to a peer
 func (rpc *MockRpcDriver) commAllowed(peer *Node) bool {
 	// Faked nodes
-	if peer == nil || peer.rpc == nil {
+	if peer == nil || peer.transport() == nil {
 		return true
 	}
 	// Might be fake node, so allow if not a MockRpcDriver
-	peerRpc, ok := peer.rpc.(*MockRpcDriver)
+	peerRpc, ok := peer.transport().(*MockRpcDriver)
 	if !ok {
 		return true
 	}
 
+	// Check for a broken link to the peer
+	rpc.mu.Lock()
+	_, blocked := rpc.blocked[peer.id]
+	rpc.mu.Unlock()
+	if blocked {
+		return false
+	}
+
 	// Check to see if we are in same group as peer
 	m1 := atomic.LoadInt32(&rpc.membership)
 	m2 := atomic.LoadInt32(&peerRpc.membership)
@@ -159,7 +259,7 @@ func (rpc *MockRpcDriver) RequestVote(vr *pb.VoteRequest) error {
 		// Silent failure
 		return nil
 	}
-	for _, p := range mockPeers() {
+	for _, p := range rpc.network().Nodes() {
 		if p.id != rpc.node.id && rpc.commAllowed(p) {
 			p.VoteRequests <- vr
 		}
@@ -167,13 +267,37 @@ func (rpc *MockRpcDriver) RequestVote(vr *pb.VoteRequest) error {
 	return nil
 }
 
+func (rpc *MockRpcDriver) Peers() []string {
+	var ids []string
+	for _, p := range rpc.network().Nodes() {
+		if p.id != rpc.node.id && rpc.commAllowed(p) {
+			ids = append(ids, p.id)
+		}
+	}
+	return ids
+}
+
+func (rpc *MockRpcDriver) RequestVoteFrom(peer string, vr *pb.VoteRequest) error {
+	if rpc.isCommBlocked() {
+		// Silent failure
+		return nil
+	}
+
+	p := rpc.network().peer(peer)
+
+	if p != nil && rpc.commAllowed(p) {
+		p.VoteRequests <- vr
+	}
+	return nil
+}
+
 func (rpc *MockRpcDriver) HeartBeat(hb *pb.Heartbeat) error {
 	if rpc.isCommBlocked() {
 		// Silent failure
 		return nil
 	}
 
-	for _, p := range mockPeers() {
+	for _, p := range rpc.network().Nodes() {
 		if p.id != rpc.node.id && rpc.commAllowed(p) {
 			p.HeartBeats <- hb
 		}
@@ -187,9 +311,7 @@ func (rpc *MockRpcDriver) SendVoteResponse(candidate string, vresp *pb.VoteRespo
 		return nil
 	}
 
-	mu.Lock()
-	p := peers[candidate]
-	mu.Unlock()
+	p := rpc.network().peer(candidate)
 
 	if p != nil && p.isRunning() && rpc.commAllowed(p) {
 		p.VoteResponses <- vresp
@@ -197,6 +319,48 @@ func (rpc *MockRpcDriver) SendVoteResponse(candidate string, vresp *pb.VoteRespo
 	return nil
 }
 
+func (rpc *MockRpcDriver) SendHeartbeatResponse(leader string, hbresp *pb.HeartbeatResponse) error {
+	if rpc.isCommBlocked() {
+		// Silent failure
+		return nil
+	}
+
+	p := rpc.network().peer(leader)
+
+	if p != nil && p.isRunning() && rpc.commAllowed(p) {
+		// Responses are best effort, never block the sender.
+		send := func() {
+			select {
+			case p.HeartBeatResponses <- hbresp:
+			default:
+			}
+		}
+		if delay := rpc.heartbeatResponseDelay(); delay > 0 {
+			timer := rpc.node.Clock().NewTimer(delay)
+			go func() {
+				<-timer.C()
+				send()
+			}()
+		} else {
+			send()
+		}
+	}
+	return nil
+}
+
+// Simulate a slow link for the heartbeat responses.
+func (rpc *MockRpcDriver) setHeartbeatResponseDelay(delay time.Duration) {
+	rpc.mu.Lock()
+	defer rpc.mu.Unlock()
+	rpc.responseDelay = delay
+}
+
+func (rpc *MockRpcDriver) heartbeatResponseDelay() time.Duration {
+	rpc.mu.Lock()
+	defer rpc.mu.Unlock()
+	return rpc.responseDelay
+}
+
 func (rpc *MockRpcDriver) isCommBlocked() bool {
 	rpc.mu.Lock()
 	defer rpc.mu.Unlock()
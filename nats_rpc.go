@@ -11,6 +11,8 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build !no_nats
+
 package graft
 
 import (
@@ -20,21 +22,36 @@ import (
 
 	"github.com/nats-io/graft/pb"
 	"github.com/nats-io/nats.go"
-	"github.com/nats-io/nats.go/encoders/protobuf"
+	"google.golang.org/protobuf/proto"
 )
 
 // The subject space for the nats rpc driver is based on the
 // cluster name, which is filled in below on the heartbeats
-// and vote requests. The vote responses are directed by
-// using the node.Id().
+// and vote requests. The vote and heartbeat responses are
+// directed by using the node.Id().
+//
+// The wire format, for a cluster named <cluster>:
+//
+//   - A LEADER publishes its Heartbeat on graft.<cluster>.heartbeat.
+//   - A FOLLOWER publishes its HeartbeatResponse on
+//     graft.<leader id>.heartbeat_response.
+//   - A CANDIDATE publishes its VoteRequest on graft.<cluster>.vote_request,
+//     with graft.<candidate id>.vote_response as reply subject.
+//   - A node publishes its VoteResponse on graft.<candidate id>.vote_response.
+//
+// The payload of each message is the message encoded by the driver's
+// RpcCodec, by default in the protocol buffers binary format of
+// pb/protocol.proto. Nodes ignore the vote requests they sent.
 const (
-	HEARTBEAT_SUB = "graft.%s.heartbeat"
-	VOTE_REQ_SUB  = "graft.%s.vote_request"
-	VOTE_RESP_SUB = "graft.%s.vote_response"
+	HEARTBEAT_SUB      = "graft.%s.heartbeat"
+	HEARTBEAT_RESP_SUB = "graft.%s.heartbeat_response"
+	VOTE_REQ_SUB       = "graft.%s.vote_request"
+	VOTE_RESP_SUB      = "graft.%s.vote_response"
 )
 
 var (
 	ErrNotInitialized = errors.New("graft(nats_rpc): Driver is not properly initialized")
+	ErrNotMessage     = errors.New("graft(nats_rpc): Not a protocol message")
 )
 
 // NatsRpcDriver is an implementation of the RPCDriver using NATS.
@@ -47,6 +64,9 @@ type NatsRpcDriver struct {
 	// Heartbeat subscription.
 	hbSub *nats.Subscription
 
+	// Heartbeat response subscription.
+	hbrespSub *nats.Subscription
+
 	// Vote request subscription.
 	vreqSub *nats.Subscription
 
@@ -55,6 +75,13 @@ type NatsRpcDriver struct {
 
 	// Graft node.
 	node *Node
+
+	// Whether Close should also close the NATS connection.
+	closeConn bool
+
+	// Handlers that were set on the NATS connection before ours.
+	prevDisconnectCB nats.ConnErrHandler
+	prevReconnectCB  nats.ConnHandler
 }
 
 // NewNatsRpc creates a new instance of the driver. The NATS connection
@@ -64,41 +91,135 @@ func NewNatsRpc(opts *nats.Options) (*NatsRpcDriver, error) {
 	if err != nil {
 		return nil, err
 	}
-	ec, err := nats.NewEncodedConn(nc, protobuf.PROTOBUF_ENCODER)
-	if err != nil {
-		return nil, err
-	}
-	return &NatsRpcDriver{ec: ec}, nil
+	return newNatsRpc(nc, true)
 }
 
 // NewNatsRpcFromConn creates a new instance of the driver using an existing NATS connection.
+// The connection will be closed when the driver is closed.
 func NewNatsRpcFromConn(nc *nats.Conn) (*NatsRpcDriver, error) {
-	ec, err := nats.NewEncodedConn(nc, protobuf.PROTOBUF_ENCODER)
-	if err != nil {
-		return nil, err
+	return newNatsRpc(nc, true)
+}
+
+// NewNatsRpcFromExternalConn creates a new instance of the driver using a NATS
+// connection owned by the caller. When closeConn is false the connection is left
+// open when the driver is closed.
+//
+// In all cases the driver installs its own disconnect and reconnect handlers on
+// the connection so that elections are held off while disconnected. Handlers
+// already set on the connection are not replaced: they are invoked after the
+// driver's own, and are restored when the driver is closed. Handlers set on the
+// connection after the driver was created replace the driver's.
+func NewNatsRpcFromExternalConn(nc *nats.Conn, closeConn bool) (*NatsRpcDriver, error) {
+	return newNatsRpc(nc, closeConn)
+}
+
+func newNatsRpc(nc *nats.Conn, closeConn bool) (*NatsRpcDriver, error) {
+	if nc == nil {
+		return nil, nats.ErrInvalidConnection
+	}
+	if nc.IsClosed() {
+		return nil, nats.ErrConnectionClosed
+	}
+	ec := &nats.EncodedConn{Conn: nc, Enc: codecEncoder{ProtobufCodec{}}}
+	rpc := &NatsRpcDriver{ec: ec, closeConn: closeConn}
+	rpc.prevDisconnectCB = nc.DisconnectErrHandler()
+	rpc.prevReconnectCB = nc.ReconnectHandler()
+	nc.SetDisconnectErrHandler(rpc.disconnected)
+	nc.SetReconnectHandler(rpc.reconnected)
+	return rpc, nil
+}
+
+// SetCodec replaces the RpcCodec encoding the messages, ProtobufCodec
+// by default. It must be called before the driver is passed to New.
+func (rpc *NatsRpcDriver) SetCodec(codec RpcCodec) {
+	if codec == nil {
+		codec = ProtobufCodec{}
+	}
+	rpc.Lock()
+	defer rpc.Unlock()
+	rpc.ec.Enc = codecEncoder{codec}
+}
+
+// codecEncoder encodes the messages of the NATS connection with an
+// RpcCodec.
+type codecEncoder struct {
+	codec RpcCodec
+}
+
+func (e codecEncoder) Encode(subject string, v any) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, ErrNotMessage
+	}
+	return e.codec.Encode(msg)
+}
+
+func (e codecEncoder) Decode(subject string, data []byte, vPtr any) error {
+	msg, ok := vPtr.(proto.Message)
+	if !ok {
+		return ErrNotMessage
 	}
-	return &NatsRpcDriver{ec: ec}, nil
+	return e.codec.Decode(data, msg)
+}
+
+// disconnected holds off elections, reports the connection failure to
+// the node and chains to the previous handler.
+func (rpc *NatsRpcDriver) disconnected(nc *nats.Conn, err error) {
+	if n := rpc.graftNode(); n != nil {
+		n.setDisconnected(true)
+		if err != nil {
+			n.ReportRPCError("connect", err)
+		}
+	}
+	if rpc.prevDisconnectCB != nil {
+		rpc.prevDisconnectCB(nc, err)
+	}
+}
+
+// reconnected resumes elections and chains to the previous handler.
+func (rpc *NatsRpcDriver) reconnected(nc *nats.Conn) {
+	if n := rpc.graftNode(); n != nil {
+		n.setDisconnected(false)
+	}
+	if rpc.prevReconnectCB != nil {
+		rpc.prevReconnectCB(nc)
+	}
+}
+
+func (rpc *NatsRpcDriver) graftNode() *Node {
+	rpc.Lock()
+	defer rpc.Unlock()
+	return rpc.node
 }
 
 // Init initializes the driver via the Graft node.
 func (rpc *NatsRpcDriver) Init(n *Node) (err error) {
+	rpc.Lock()
 	rpc.node = n
+	rpc.Unlock()
 
 	// Create the heartbeat subscription.
 	hbSub := fmt.Sprintf(HEARTBEAT_SUB, n.ClusterInfo().Name)
 	rpc.hbSub, err = rpc.ec.Subscribe(hbSub, rpc.HeartbeatCallback)
 	if err != nil {
-		return err
+		return transportError("subscribe", err)
 	}
 	// Create the voteRequest subscription.
 	rpc.vreqSub, err = rpc.ec.Subscribe(rpc.vreqSubject(), rpc.VoteRequestCallback)
 	if err != nil {
-		return err
+		return transportError("subscribe", err)
+	}
+	// Create the directed heartbeat response subscription.
+	rpc.hbrespSub, err = rpc.ec.Subscribe(rpc.hbrespSubject(n.Id()), rpc.HeartbeatResponseCallback)
+	if err != nil {
+		return transportError("subscribe", err)
 	}
 	return nil
 }
 
-// Close down the subscriptions and the NATS encoded connection.
+// Close down the subscriptions and the NATS encoded connection,
+// unless the connection is owned by the caller. In that case the
+// connection's previous handlers are restored instead.
 // Will nil everything out.
 func (rpc *NatsRpcDriver) Close() {
 	rpc.Lock()
@@ -108,6 +229,10 @@ func (rpc *NatsRpcDriver) Close() {
 		rpc.hbSub.Unsubscribe()
 		rpc.hbSub = nil
 	}
+	if rpc.hbrespSub != nil {
+		rpc.hbrespSub.Unsubscribe()
+		rpc.hbrespSub = nil
+	}
 	if rpc.vreqSub != nil {
 		rpc.vreqSub.Unsubscribe()
 		rpc.vreqSub = nil
@@ -117,8 +242,21 @@ func (rpc *NatsRpcDriver) Close() {
 		rpc.vrespSub = nil
 	}
 	if rpc.ec != nil {
-		rpc.ec.Close()
+		if rpc.closeConn {
+			rpc.ec.Close()
+		} else {
+			rpc.ec.Conn.SetDisconnectErrHandler(rpc.prevDisconnectCB)
+			rpc.ec.Conn.SetReconnectHandler(rpc.prevReconnectCB)
+		}
+	}
+}
+
+// transportError wraps a NATS failure into an RPCError.
+func transportError(op string, err error) error {
+	if err == nil {
+		return nil
 	}
+	return &RPCError{Kind: KindTransport, Op: op, Err: err}
 }
 
 // Convenience function for generating the directed response
@@ -128,6 +266,12 @@ func (rpc *NatsRpcDriver) vrespSubject(candidate string) string {
 	return fmt.Sprintf(VOTE_RESP_SUB, candidate)
 }
 
+// Convenience function for generating the directed response
+// subject for heartbeats. We will use the leader's id.
+func (rpc *NatsRpcDriver) hbrespSubject(leader string) string {
+	return fmt.Sprintf(HEARTBEAT_RESP_SUB, leader)
+}
+
 // Convenience funstion for generating the vote request subject.
 func (rpc *NatsRpcDriver) vreqSubject() string {
 	return fmt.Sprintf(VOTE_REQ_SUB, rpc.node.ClusterInfo().Name)
@@ -139,6 +283,12 @@ func (rpc *NatsRpcDriver) HeartbeatCallback(hb *pb.Heartbeat) {
 	rpc.node.HeartBeats <- hb
 }
 
+// HeartbeatResponseCallback will place the response on the Graft
+// node's appropriate channel.
+func (rpc *NatsRpcDriver) HeartbeatResponseCallback(hbresp *pb.HeartbeatResponse) {
+	rpc.node.HeartBeatResponses <- hbresp
+}
+
 // VoteRequestCallback will place the request on the Graft
 // node's appropriate channel.
 func (rpc *NatsRpcDriver) VoteRequestCallback(vreq *pb.VoteRequest) {
@@ -169,17 +319,17 @@ func (rpc *NatsRpcDriver) RequestVote(vr *pb.VoteRequest) error {
 	inbox := rpc.vrespSubject(rpc.node.Id())
 	sub, err := rpc.ec.Subscribe(inbox, rpc.VoteResponseCallback)
 	if err != nil {
-		return err
+		return transportError("subscribe", err)
 	}
 	// If we can auto-unsubscribe to max number of expected responses
 	// which will be the cluster size.
-	if size := rpc.node.ClusterInfo().Size; size > 0 {
+	if size := rpc.node.ClusterSize(); size > 0 {
 		sub.AutoUnsubscribe(size)
 	}
 	// hold to cancel later.
 	rpc.vrespSub = sub
 	// Fire off the request.
-	return rpc.ec.PublishRequest(rpc.vreqSubject(), inbox, vr)
+	return transportError("request vote", rpc.ec.PublishRequest(rpc.vreqSubject(), inbox, vr))
 }
 
 // HeartBeat is called from the Graft node to send out a heartbeat
@@ -189,9 +339,9 @@ func (rpc *NatsRpcDriver) HeartBeat(hb *pb.Heartbeat) error {
 	defer rpc.Unlock()
 
 	if rpc.hbSub == nil {
-		return ErrNotInitialized
+		return transportError("heartbeat", ErrNotInitialized)
 	}
-	return rpc.ec.Publish(rpc.hbSub.Subject, hb)
+	return transportError("heartbeat", rpc.ec.Publish(rpc.hbSub.Subject, hb))
 }
 
 // SendVoteResponse is called from the Graft node to respond to a vote request.
@@ -199,5 +349,14 @@ func (rpc *NatsRpcDriver) SendVoteResponse(id string, vresp *pb.VoteResponse) er
 	rpc.Lock()
 	defer rpc.Unlock()
 
-	return rpc.ec.Publish(rpc.vrespSubject(id), vresp)
+	return transportError("vote response", rpc.ec.Publish(rpc.vrespSubject(id), vresp))
+}
+
+// SendHeartbeatResponse is called from the Graft node to acknowledge a
+// heartbeat from the leader.
+func (rpc *NatsRpcDriver) SendHeartbeatResponse(leader string, hbresp *pb.HeartbeatResponse) error {
+	rpc.Lock()
+	defer rpc.Unlock()
+
+	return transportError("heartbeat response", rpc.ec.Publish(rpc.hbrespSubject(leader), hbresp))
 }
@@ -11,15 +11,21 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build !no_nats
+
 package graft
 
 import (
+	"encoding/json"
 	"fmt"
 	"testing"
 	"time"
 
+	"github.com/nats-io/graft/pb"
 	"github.com/nats-io/nats-server/v2/test"
 	"github.com/nats-io/nats.go"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 )
 
 func createNatsNodes(t *testing.T, name string, numNodes int) []*Node {
@@ -78,3 +84,94 @@ func TestNatsLeaderElection(t *testing.T) {
 	// Wait for Election
 	expectedClusterState(t, nodes, 1, toStart-2, 0)
 }
+
+func TestNatsExternalConnHandlers(t *testing.T) {
+	// No server is needed to exercise the handlers.
+	nc := &nats.Conn{}
+	userDisconnects, userReconnects := 0, 0
+	nc.SetDisconnectErrHandler(func(*nats.Conn, error) { userDisconnects++ })
+	nc.SetReconnectHandler(func(*nats.Conn) { userReconnects++ })
+
+	rpc, err := NewNatsRpcFromExternalConn(nc, false)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	node := &Node{id: "ext"}
+	rpc.node = node
+
+	nc.DisconnectErrHandler()(nc, nil)
+	if !node.isDisconnected() {
+		t.Fatal("Expected the node to be marked disconnected")
+	}
+	if userDisconnects != 1 {
+		t.Fatalf("Expected the user's disconnect handler to fire, got %d calls", userDisconnects)
+	}
+
+	nc.ReconnectHandler()(nc)
+	if node.isDisconnected() {
+		t.Fatal("Expected the node to be marked connected")
+	}
+	if userReconnects != 1 {
+		t.Fatalf("Expected the user's reconnect handler to fire, got %d calls", userReconnects)
+	}
+
+	// Closing the driver leaves the connection open and restores
+	// the user's handlers.
+	rpc.Close()
+	if nc.IsClosed() {
+		t.Fatal("Expected the external connection to be left open")
+	}
+	nc.DisconnectErrHandler()(nc, nil)
+	if node.isDisconnected() {
+		t.Fatal("Expected the driver's handler to be removed on Close")
+	}
+	if userDisconnects != 2 {
+		t.Fatalf("Expected the user's disconnect handler to be restored, got %d calls", userDisconnects)
+	}
+}
+
+// jsonCodec encodes the messages in the JSON format of protocol buffers.
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(msg proto.Message) ([]byte, error) {
+	return protojson.Marshal(msg)
+}
+
+func (jsonCodec) Decode(data []byte, msg proto.Message) error {
+	return protojson.Unmarshal(data, msg)
+}
+
+func TestNatsRpcCodec(t *testing.T) {
+	rpc, err := NewNatsRpcFromExternalConn(&nats.Conn{}, false)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	rpc.SetCodec(jsonCodec{})
+
+	msgs := []proto.Message{
+		&pb.VoteRequest{Term: 3, Candidate: "a", CurrentState: []byte("pos")},
+		&pb.VoteResponse{Term: 3, Granted: true, Voter: "b"},
+		&pb.Heartbeat{Term: 3, Leader: "a", Nonce: 7, Metadata: []byte("meta"), Extension: 11},
+		&pb.HeartbeatResponse{Term: 3, Follower: "b", Nonce: 7},
+	}
+	for _, msg := range msgs {
+		data, err := rpc.ec.Enc.Encode("subject", msg)
+		if err != nil {
+			t.Fatalf("Expected no error encoding %T, got: %v", msg, err)
+		}
+		if !json.Valid(data) {
+			t.Fatalf("Expected %T to be encoded by the codec, got %q", msg, data)
+		}
+		decoded := msg.ProtoReflect().New().Interface()
+		if err := rpc.ec.Enc.Decode("subject", data, decoded); err != nil {
+			t.Fatalf("Expected no error decoding %T, got: %v", msg, err)
+		}
+		if !proto.Equal(decoded, msg) {
+			t.Fatalf("Expected %v, got %v", msg, decoded)
+		}
+	}
+
+	if _, err := rpc.ec.Enc.Encode("subject", "hello"); err != ErrNotMessage {
+		t.Fatalf("Expected %v, got: %v", ErrNotMessage, err)
+	}
+}
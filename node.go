@@ -18,14 +18,18 @@
 package graft
 
 import (
+	"bytes"
 	"crypto/rand"
 	"encoding/hex"
+	"fmt"
 	"io"
-	mrand "math/rand"
+	"maps"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/nats-io/graft/pb"
+	"google.golang.org/protobuf/proto"
 )
 
 type Node struct {
@@ -41,12 +45,15 @@ type Node struct {
 	// Current state
 	state State
 
-	// The RPC Driver
+	// RPC driver, swapped under the lock.
 	rpc RPCDriver
 
 	// Where we store the persistent state
 	logPath string
 
+	// Serializes writes of the persistent state
+	wmu sync.Mutex
+
 	// Async handler
 	handler Handler
 
@@ -59,14 +66,106 @@ type Node struct {
 	// Current leader
 	leader string
 
+	// When we learned of the current leader.
+	electedAt time.Time
+
+	// When we learned of the last leaders, oldest first.
+	leaderChanges []time.Time
+
+	// When we cast our current vote, zero if loaded from the log.
+	votedAt time.Time
+
+	// Format of the state loaded from the log.
+	stateFormat StateFormat
+
+	// Peers that granted us their vote in our last campaign.
+	voters []string
+
+	// Records appended to the history since it was compacted.
+	// Protected by wmu.
+	historyAppends int
+
+	// Successful writes of the state. Protected by wmu.
+	writeGen uint64
+
 	// Current term
 	term uint64
 
 	// Who we voted for in the current term.
 	vote string
 
+	// Set once the log is removed on Close. Protected by wmu.
+	logClosed bool
+
+	// Whether the last write fell back to rewriting the log in place.
+	// Protected by wmu.
+	inPlace bool
+
+	// Whether the RPC transport reported it is disconnected.
+	disconnected bool
+
 	// Election timer.
-	electTimer *time.Timer
+	electTimer Timer
+
+	// The election timer, kept once cleared to report its deadline.
+	electDeadline *deadlineTimer
+
+	// Consecutive elections started without electing a leader.
+	attempts int
+
+	// Campaigns started since we last had a LEADER, see
+	// WithMaxElectionAttempts.
+	campaigns int
+
+	// Unique id of our current or last campaign.
+	campaign string
+
+	// Trace baggage sent with our vote requests, see SetBaggage.
+	baggage map[string]string
+
+	// Consecutive campaigns that failed, and the tallies of the last
+	// ones, as many as diagnosed with WithElectionDiagnostics.
+	failedCount     int
+	failedCampaigns []campaignTally
+
+	// Heartbeat round-trip times measured as LEADER.
+	latencies map[string]peerLatency
+
+	// How well we reach a quorum as LEADER, see LeaderStability.
+	stability stability
+
+	// Last communication failure with each peer.
+	peerErrors map[string]peerError
+
+	// Peers that advertised an incompatible protocol version.
+	incompatible map[string]peerVersion
+
+	// Set when we lost our state but kept our identity, until we
+	// hear from a LEADER. See WithLostStateGuard.
+	catchingUp bool
+
+	// We may have voted up to this term before losing our state.
+	voteFloor uint64
+
+	// Term of the last heartbeat we followed, see IsCurrent.
+	heardTerm uint64
+
+	// Set while our state can not be written, e.g. on a full disk.
+	// We neither vote nor campaign until a write succeeds.
+	degraded bool
+
+	// Metadata we advertise in heartbeats as LEADER.
+	advertised []byte
+
+	// End of our leadership extension as LEADER.
+	extendedUntil time.Time
+
+	// Metadata last dropped from our heartbeats for their size.
+	// Only used by our loop.
+	oversized []byte
+
+	// Metadata advertised by the current LEADER.
+	leaderMeta []byte
 
 	// Channel to receive VoteRequests.
 	VoteRequests chan *pb.VoteRequest
@@ -77,8 +176,62 @@ type Node struct {
 	// Channel to receive Heartbeats.
 	HeartBeats chan *pb.Heartbeat
 
+	// Channel to receive HeartbeatResponses.
+	HeartBeatResponses chan *pb.HeartbeatResponse
+
+	// Optional behavior.
+	opts options
+
 	// quit channel for shutdown on Close().
 	quit chan chan struct{}
+
+	// pause and resume channels for Pause() and Resume().
+	pause  chan chan struct{}
+	resume chan chan struct{}
+
+	// retry channel for Retry().
+	retry chan chan struct{}
+
+	// external channel for AssumeLeadership() and RelinquishLeadership().
+	external chan *externalReq
+
+	// Members the quorum is computed from.
+	size int
+
+	// Excluded from the quorum by DemoteToObserver().
+	observer bool
+
+	// Peers that answered as observers, and the term they did so in.
+	// They are left out of the quorum of that term.
+	observers map[string]uint64
+
+	// We answered as an observer up to this term, and never vote in it.
+	observedTerm uint64
+
+	// stepUp channel for StepUp(), and the channel closed at our next
+	// state change.
+	stepUp       chan chan error
+	stateChanged chan struct{}
+
+	// handoff channel for TransferLeadership().
+	handoff chan *handoffReq
+
+	// Closed on Close() to stop watching the PeerProvider.
+	peersDone chan struct{}
+
+	// Mirror of our leadership into an external lease, if any.
+	lease *leaseMirror
+
+	// Closed when Close() starts, to stop accepting actions.
+	closing chan struct{}
+	// Close() runs once, concurrent calls wait for it.
+	closeOnce sync.Once
+	// Closed once serving, see StartServing().
+	serving chan struct{}
+	serveMu sync.Mutex
+
+	// The loop, PeerProvider watcher and draining go routines.
+	routines sync.WaitGroup
 }
 
 // ClusterInfo expresses the name and expected
@@ -91,6 +244,17 @@ type ClusterInfo struct {
 	Size int
 }
 
+// Validate checks that the ClusterInfo can be used to create a node.
+func (ci ClusterInfo) Validate() error {
+	if ci.Name == "" {
+		return ErrClusterName
+	}
+	if ci.Size == 0 {
+		return ErrClusterSize
+	}
+	return nil
+}
+
 // StateMachineHandler is used to interrogate an external state machine.
 type StateMachineHandler interface {
 	// CurrentState returns an opaque byte slice that represents the current
@@ -120,6 +284,12 @@ func (d *defaultStateMachineHandler) GrantVote(position []byte) bool {
 }
 
 // A Handler can process async callbacks from a Graft node.
+//
+// AsyncError and the state changes are delivered in order with the
+// Scheduler, see WithScheduler. The other methods, and those of the
+// optional interfaces a Handler may implement, are called synchronously
+// by the node, which does nothing else meanwhile, so they must not block
+// for long.
 type Handler interface {
 	StateMachineHandler
 
@@ -130,26 +300,163 @@ type Handler interface {
 	StateChange(from, to State)
 }
 
-// New will create a new Graft node. All arguments are required.
-func New(info ClusterInfo, handler Handler, rpc RPCDriver, logPath string) (*Node, error) {
+// A StateChangeReasonHandler is a Handler told why its node changed
+// state. StateChangeWithReason is called instead of StateChange.
+type StateChangeReasonHandler interface {
+	StateChangeWithReason(from, to State, reason Reason)
+}
+
+// A StateChangeEventHandler is a Handler given the full StateChange,
+// including the Campaign a CANDIDATE ran. OnStateChange is called
+// instead of StateChangeWithReason and StateChange.
+type StateChangeEventHandler interface {
+	OnStateChange(sc StateChange)
+}
+
+// A CurrentHandler is a Handler notified when its node becomes current,
+// see IsCurrent, e.g. to start serving reads after a restart. OnCurrent
+// is called with the term and LEADER it heard from.
+type CurrentHandler interface {
+	OnCurrent(term uint64, leader string)
+}
+
+// A BaggageHandler is a Handler given the trace baggage that candidates
+// set with SetBaggage, e.g. a correlation id, to link the election
+// activity of its node to the request that triggered a campaign.
+// OnBaggage is called for each vote request carrying baggage, with the
+// candidate and its campaign, before the vote is considered.
+type BaggageHandler interface {
+	OnBaggage(candidate, campaign string, baggage map[string]string)
+}
+
+// A LeadershipLossHandler is a Handler notified the moment its node stops
+// being LEADER, e.g. to abort leader-only work. Unlike StateChange,
+// OnLostLeadership is called with the term we were LEADER of, before the
+// node does anything else.
+type LeadershipLossHandler interface {
+	OnLostLeadership(term uint64)
+}
+
+// A LeaderTicker is a Handler called every interval set with
+// WithLeaderTick while its node is LEADER, e.g. to run leader-only
+// housekeeping. OnLeaderTick is called by the LEADER's loop, so it never
+// fires once the node has stepped down.
+type LeaderTicker interface {
+	OnLeaderTick()
+}
+
+// A QuorumHeartbeatHandler is a Handler notified each time a quorum of
+// the cluster, counting its node, acknowledged a heartbeat of its node
+// as LEADER of term. Nobody else was LEADER when the heartbeat was sent
+// at that time, so it is the proof a leader lease can be extended from.
+// The RPCDriver must implement HeartbeatResponder.
+type QuorumHeartbeatHandler interface {
+	OnQuorumHeartbeat(term uint64, at time.Time)
+}
+
+// A TermJumpHandler is a Handler notified each time the term of its
+// node increased from one term to another, e.g. to correlate events
+// across clusters. The source is the id of the peer whose message
+// carried the higher term we adopted, or our own id when we increased
+// it ourselves, campaigning or taking external leadership.
+type TermJumpHandler interface {
+	OnTermJump(from, to uint64, source string)
+}
+
+// A LeadershipVetoer is a Handler that can prevent its node from becoming
+// LEADER, e.g. while it does not hold an external lease. CanBecomeLeader is
+// consulted before starting an election and right before switching to
+// LEADER after winning one, in which case the node steps down and lets
+// another node try. If every node of the cluster vetoes, the cluster has
+// no LEADER until one of them allows it again.
+type LeadershipVetoer interface {
+	CanBecomeLeader() bool
+}
+
+// A VoteGrantHook is a Handler called after its node durably recorded its
+// vote for candidate in term, but before it sends the grant that may make
+// the candidate LEADER, e.g. to fence external resources. Returning an
+// error aborts the grant: the candidate is denied instead. The vote stays
+// recorded, so the node can not vote for anyone else in that term, and
+// grants it again only if the candidate asks again. Aborting can thus
+// delay the election of a LEADER, or prevent it entirely if the hook
+// keeps failing on enough nodes.
+type VoteGrantHook interface {
+	BeforeGrantVote(term uint64, candidate string) error
+}
+
+// New will create a new Graft node. All arguments except the options
+// are required.
+func New(info ClusterInfo, handler Handler, rpc RPCDriver, logPath string, opts ...Option) (*Node, error) {
 
 	// Check for correct Args
-	if err := checkArgs(info, handler, rpc, logPath); err != nil {
+	if err := checkArgs(info, handler, rpc); err != nil {
 		return nil, err
 	}
 
-	// Assign an Id() and start us as a FOLLOWER with no known LEADER.
+	// Process the options
+	o := defaultOptions()
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkOptions(o, rpc); err != nil {
+		return nil, err
+	}
+	if err := checkMembership(info, rpc, o); err != nil {
+		return nil, err
+	}
+	if logPath == "" && !o.ephemeral {
+		return nil, ErrLogReq
+	}
+	if _, ok := handler.(LeaderTicker); o.leaderTick > 0 && !ok {
+		return nil, ErrLeaderTickerReq
+	}
+	if _, ok := handler.(LeadershipChurnHandler); o.churnThreshold > 0 && !ok {
+		return nil, ErrChurnHandlerReq
+	}
+
+	// Assign an Id() unless we were given one.
+	id := o.id
+	if id == "" {
+		if id = o.idGenerator(); id == "" {
+			return nil, ErrInvalidOption
+		}
+	}
+
+	// Order the election timeouts by our affinity for the cluster.
+	if o.leaderAffinity {
+		u, ok := o.timeouts.(UniformTimeout)
+		if !ok {
+			return nil, ErrInvalidOption
+		}
+		o.timeouts = affinityTimeout{min: u.Min, max: u.Max, affinity: leaderAffinity(id, info.Name)}
+	}
+
+	// Start us as a FOLLOWER with no known LEADER.
 	node := &Node{
-		id:            genUUID(),
-		info:          info,
-		state:         FOLLOWER,
-		rpc:           rpc,
-		handler:       handler,
-		leader:        NO_LEADER,
-		quit:          make(chan chan struct{}),
-		VoteRequests:  make(chan *pb.VoteRequest),
-		VoteResponses: make(chan *pb.VoteResponse),
-		HeartBeats:    make(chan *pb.Heartbeat),
+		id:                 id,
+		info:               info,
+		state:              FOLLOWER,
+		rpc:                rpc,
+		handler:            handler,
+		leader:             NO_LEADER,
+		quit:               make(chan chan struct{}),
+		pause:              make(chan chan struct{}),
+		resume:             make(chan chan struct{}),
+		retry:              make(chan chan struct{}),
+		external:           make(chan *externalReq),
+		size:               info.Size,
+		VoteRequests:       make(chan *pb.VoteRequest),
+		VoteResponses:      make(chan *pb.VoteResponse),
+		HeartBeats:         make(chan *pb.Heartbeat),
+		HeartBeatResponses: make(chan *pb.HeartbeatResponse),
+		opts:               o,
+		closing:            make(chan struct{}),
+		stepUp:             make(chan chan error),
+		handoff:            make(chan *handoffReq),
+		serving:            make(chan struct{}),
 	}
 
 	// Init the log file and update our state.
@@ -157,16 +464,44 @@ func New(info ClusterInfo, handler Handler, rpc RPCDriver, logPath string) (*Nod
 		return nil, err
 	}
 
-	// Init the rpc driver
-	if err := rpc.Init(node); err != nil {
-		return nil, err
+	// Init the rpc driver, unless deferred to StartServing().
+	if !o.deferredServing {
+		if err := rpc.Init(node); err != nil {
+			return nil, &RPCError{Kind: KindTransport, Op: "init", Err: err}
+		}
+		close(node.serving)
+	}
+
+	// Follow the live membership.
+	if o.peers != nil {
+		node.peersDone = make(chan struct{})
+		node.updateSize()
+		node.routines.Add(1)
+		go func() {
+			defer node.routines.Done()
+			node.watchPeers()
+		}()
+	}
+
+	// Mirror our leadership into an external lease.
+	if o.leaseRenew != nil {
+		node.lease = newLeaseMirror(o.leaseRenew, o.leaseRelease)
+		node.routines.Add(1)
+		go func() {
+			defer node.routines.Done()
+			node.mirrorLease(node.lease)
+		}()
 	}
 
 	// Setup Timers
 	node.setupTimers()
 
 	// Loop
-	go node.loop()
+	node.routines.Add(1)
+	go func() {
+		defer node.routines.Done()
+		node.loop()
+	}()
 
 	return node, nil
 }
@@ -182,6 +517,12 @@ func (n *Node) ClusterInfo() ClusterInfo {
 	return n.info
 }
 
+// Clock returns the time source of the node. RPCDrivers should use it
+// for their own timers so a FakeClock drives them too.
+func (n *Node) Clock() Clock {
+	return n.opts.clock
+}
+
 // Convenience function for accessing the node's Id().
 func (n *Node) Id() string {
 	return n.id
@@ -189,7 +530,8 @@ func (n *Node) Id() string {
 
 func (n *Node) setupTimers() {
 	// Election timer
-	n.electTimer = time.NewTimer(randElectionTimeout())
+	n.electDeadline = newDeadlineTimer(n.opts.clock, n.nextElectionTimeout())
+	n.electTimer = n.electDeadline
 }
 
 func (n *Node) clearTimers() {
@@ -200,13 +542,10 @@ func (n *Node) clearTimers() {
 }
 
 // Make sure we have all the arguments to create the Graft node.
-func checkArgs(info ClusterInfo, handler Handler, rpc RPCDriver, logPath string) error {
+func checkArgs(info ClusterInfo, handler Handler, rpc RPCDriver) error {
 	// Check ClusterInfo
-	if info.Name == "" {
-		return ErrClusterName
-	}
-	if info.Size == 0 {
-		return ErrClusterSize
+	if err := info.Validate(); err != nil {
+		return err
 	}
 	// Make sure we have non-nil args
 	if handler == nil {
@@ -215,14 +554,56 @@ func checkArgs(info ClusterInfo, handler Handler, rpc RPCDriver, logPath string)
 	if rpc == nil {
 		return ErrRpcDriverReq
 	}
-	if logPath == "" {
-		return ErrLogReq
+	return nil
+}
+
+// Make sure the members configured in the RPCDriver can reach a quorum
+// of the cluster, with WithMembershipCheck.
+func checkMembership(info ClusterInfo, rpc RPCDriver, o options) error {
+	if !o.membershipCheck {
+		return nil
+	}
+	members := rpc.(MembershipReporter).ConfiguredMembers()
+	if quorum := Quorum(info.Size); members < quorum {
+		return fmt.Errorf("%w: %d members configured, %d needed of %d", ErrQuorumUnreachable, members, quorum, info.Size)
+	}
+	return nil
+}
+
+// Make sure the options can be honored by the given RPCDriver.
+func checkOptions(o options, rpc RPCDriver) error {
+	if _, ok := rpc.(HeartbeatResponder); o.checkQuorum && !ok {
+		return ErrHeartbeatResponseReq
+	}
+	if _, ok := rpc.(PeerVoteRequester); o.maxInflightVotes > 0 && !ok {
+		return ErrPeerVoteRequesterReq
+	}
+	if _, ok := rpc.(MembershipReporter); o.membershipCheck && !ok {
+		return ErrMembershipReq
+	}
+	if o.lostStateGuard && o.id == "" {
+		return ErrInvalidOption
+	}
+	if o.historyRetention > 0 && o.historyPath == "" {
+		return ErrInvalidOption
+	}
+	if o.quarantineDir != "" && !o.resetOnCorrupt {
+		return ErrInvalidOption
+	}
+	if o.campaignJitter > MAX_CAMPAIGN_JITTER_MULTIPLIER*o.heartbeat {
+		return ErrInvalidOption
+	}
+	if o.knownCandidatesOnly && len(o.knownCandidates) == 0 && o.peers == nil {
+		return ErrPeerProviderReq
 	}
 	return nil
 }
 
 // Mainloop that switches states and reacts to voteRequests and Heartbeats.
 func (n *Node) loop() {
+	if !n.waitForServing() {
+		return
+	}
 	for n.isRunning() {
 		switch n.State() {
 		case FOLLOWER:
@@ -230,11 +611,28 @@ func (n *Node) loop() {
 		case CANDIDATE:
 			n.runAsCandidate()
 		case LEADER:
+			term := n.CurrentTerm()
 			n.runAsLeader()
+			n.lease.lead(0)
+			n.lostLeadership(term)
+		case PAUSED:
+			n.runAsPaused()
+		case GAVE_UP:
+			n.runAsGaveUp()
 		}
 	}
 }
 
+// isClosing returns whether Close() was called.
+func (n *Node) isClosing() bool {
+	select {
+	case <-n.closing:
+		return true
+	default:
+		return false
+	}
+}
+
 // isRunning returns whether we are still running.
 // When Close() has been called this returns false.
 func (n *Node) isRunning() bool {
@@ -245,10 +643,47 @@ func (n *Node) isRunning() bool {
 
 // Process loop for a LEADER.
 func (n *Node) runAsLeader() {
+	// A new leadership is not extended.
+	n.clearLeadershipExtension()
+	n.resetStability()
+
 	// Setup our heartbeat ticker
-	hb := time.NewTicker(HEARTBEAT_INTERVAL)
+	hb := n.opts.clock.NewTicker(n.opts.heartbeat)
 	defer hb.Stop()
 
+	// Setup the handler's leader ticker, if configured.
+	var tick <-chan time.Time
+	if n.opts.leaderTick > 0 {
+		lt := n.opts.clock.NewTicker(n.opts.leaderTick)
+		defer lt.Stop()
+		tick = lt.C()
+	}
+
+	// Acquire the external lease, and renew it periodically.
+	var leaseTick <-chan time.Time
+	if n.lease != nil {
+		n.lease.lead(n.term)
+		lt := n.opts.clock.NewTicker(n.opts.leaseInterval)
+		defer lt.Stop()
+		leaseTick = lt.C()
+	}
+
+	// Peers that responded to our last heartbeat, and the last
+	// time a quorum did so. Only used with CheckQuorum.
+	acks := make(map[string]struct{})
+	lastQuorum := n.opts.clock.Now()
+	sent := false
+
+	// Nonce and send time of the last heartbeat, to measure the
+	// round-trip time to each peer, and the peers that acknowledged it.
+	var nonce uint64
+	var sentAt time.Time
+	roundAcks := make(map[string]struct{})
+	roundQuorum := false
+
+	// Peer we hand our leadership off to, see TransferLeadership.
+	successor := NO_LEADER
+
 	for {
 		select {
 
@@ -257,25 +692,141 @@ func (n *Node) runAsLeader() {
 			n.processQuit(q)
 			return
 
+		// Request to pause
+		case p := <-n.pause:
+			n.processPause(p)
+			return
+
+		// Leadership set by an external coordinator.
+		case req := <-n.external:
+			if n.processExternal(req) {
+				return
+			}
+
 		// Heartbeat tick. Send an HB each time.
-		case <-hb.C:
+		case <-hb.C():
+			if n.IsObserver() {
+				n.switchToFollower(NO_LEADER, REASON_DEMOTED)
+				return
+			}
+			// Check that a quorum answered the previous heartbeat.
+			if n.opts.checkQuorum && sent {
+				if n.hasQuorum(acks) {
+					lastQuorum = n.opts.clock.Now()
+				} else if n.opts.clock.Now().Sub(lastQuorum) > n.opts.quorumGrace && n.leadershipExtension() == 0 {
+					n.switchToFollower(NO_LEADER, REASON_QUORUM_LOST)
+					return
+				}
+				clear(acks)
+			}
+			// The previous round is over.
+			if sent {
+				n.recordRound(roundQuorum)
+			}
 			// Send a heartbeat
-			n.rpc.HeartBeat(&pb.Heartbeat{Term: n.term, Leader: n.id})
+			nonce++
+			sentAt = n.opts.clock.Now()
+			// A single node has nobody to send it to.
+			if !n.singleNode() {
+				hb := n.newHeartbeat(nonce)
+				hb.Successor = successor
+				if hb = intercept(n, hb, true); hb != nil {
+					n.transport().HeartBeat(hb)
+				}
+			}
+			sent = true
+			clear(roundAcks)
+			// Alone, we are our own quorum.
+			if roundQuorum = n.hasQuorum(roundAcks); roundQuorum {
+				n.quorumHeartbeat(sentAt)
+			}
+
+		// A handoff requested by TransferLeadership().
+		case req := <-n.handoff:
+			if req.withdraw {
+				successor = NO_LEADER
+				req.done <- nil
+				continue
+			}
+			peer := req.peer
+			if peer == NO_LEADER {
+				peer = n.successor()
+			}
+			if peer == NO_LEADER || peer == n.id {
+				req.done <- ErrNoSuccessor
+				continue
+			}
+			successor = peer
+			req.done <- nil
+			// Ask right away, this heartbeat is not measured.
+			hb := n.newHeartbeat(0)
+			hb.Successor = successor
+			if hb = intercept(n, hb, true); hb != nil {
+				n.transport().HeartBeat(hb)
+			}
+
+		// Leader-only housekeeping of the handler.
+		case <-tick:
+			n.handler.(LeaderTicker).OnLeaderTick()
+
+		// Time to renew the external lease.
+		case <-leaseTick:
+			n.lease.lead(n.term)
+
+		// A response to our heartbeats.
+		case hbresp := <-n.HeartBeatResponses:
+			if hbresp = intercept(n, hbresp, false); hbresp == nil {
+				continue
+			}
+			// If they are newer, we will step down.
+			if stepDown := n.handleHeartBeatResponse(hbresp); stepDown {
+				n.switchToFollower(NO_LEADER, REASON_HIGHER_TERM)
+				return
+			}
+			if hbresp.Term == n.term && hbresp.Follower != n.id {
+				// An observer does not count toward our quorum.
+				if n.observed(hbresp.Follower, hbresp.Term, hbresp.Observer); hbresp.Observer {
+					continue
+				}
+				acks[hbresp.Follower] = struct{}{}
+				// Only a response to the last heartbeat has a known send time.
+				if hbresp.Nonce == nonce {
+					n.recordLatency(hbresp.Follower, n.opts.clock.Now().Sub(sentAt))
+					// Notify once, when the round reaches a quorum.
+					roundAcks[hbresp.Follower] = struct{}{}
+					if !roundQuorum && n.hasQuorum(roundAcks) {
+						roundQuorum = true
+						n.quorumHeartbeat(sentAt)
+					}
+				}
+			}
 
 		// A Vote Request.
 		case vreq := <-n.VoteRequests:
-			// We will stepdown if needed. This can happen if the
-			// request is from a newer term than ours.
+			if vreq = intercept(n, vreq, false); vreq == nil {
+				continue
+			}
+			if n.opts.reportLeaderVotes {
+				n.handleError(fmt.Errorf("%w: from %q at term %d, ours %d",
+					ErrVoteRequestAsLeader, vreq.Candidate, vreq.Term, n.term))
+			}
+			// We deny requests up to our term, and step down on a
+			// newer term before considering the vote as a FOLLOWER.
 			if stepDown := n.handleVoteRequest(vreq); stepDown {
-				n.switchToFollower(NO_LEADER)
+				n.switchToFollower(NO_LEADER, REASON_HIGHER_TERM)
 				return
 			}
 
 		// Process another LEADER's heartbeat.
 		case hb := <-n.HeartBeats:
+			if hb = intercept(n, hb, false); hb == nil {
+				continue
+			}
 			// If they are newer, we will step down.
-			if stepDown := n.handleHeartBeat(hb); stepDown {
-				n.switchToFollower(hb.Leader)
+			stepDown := n.handleHeartBeat(hb)
+			n.sendHeartBeatResponse(hb)
+			if stepDown {
+				n.switchToFollower(hb.Leader, REASON_HIGHER_TERM)
 				return
 			}
 		}
@@ -293,28 +844,41 @@ func (n *Node) runAsCandidate() {
 		Term:         n.term,
 		Candidate:    n.id,
 		CurrentState: n.handler.CurrentState(),
+		Version:      n.opts.protocolVersion,
+		Campaign:     n.Campaign(),
+		Baggage:      n.Baggage(),
 	}
 	// Collect the votes.
 	// We will vote for ourselves, so start at 1.
 	votes := 1
 
+	// Distinct peers that answered this campaign.
+	responders := make(map[string]struct{})
+
+	// Responses to our previous campaigns.
+	late := 0
+
 	// Vote for ourself.
-	n.setVote(n.id)
+	n.castVote(n.id)
 
 	// Save our state.
 	if err := n.writeState(); err != nil {
 		n.handleError(err)
-		n.switchToFollower(NO_LEADER)
+		n.switchToFollower(NO_LEADER, REASON_WRITE_FAILED)
 		return
 	}
 
-	// Send the vote request to other members
-	n.rpc.RequestVote(vreq)
+	// Send the vote request to other members, if any.
+	var waves *voteWaves
+	if !n.singleNode() {
+		waves = n.requestVotes(vreq)
+	}
+	defer waves.stop()
 
 	// Check to see if we have already won.
-	if n.wonElection(votes) {
+	if n.wonCampaign(votes, responders) {
 		// Become LEADER if we have won.
-		n.switchToLeader()
+		n.switchToElectedLeader()
 		return
 	}
 
@@ -326,47 +890,118 @@ func (n *Node) runAsCandidate() {
 			n.processQuit(q)
 			return
 
+		// Request to pause
+		case p := <-n.pause:
+			n.processPause(p)
+			return
+
 		// An ElectionTimeout causes us to go back into a Candidate
 		// state and start a new election.
-		case <-n.electTimer.C:
+		case <-n.electTimer.C():
+			// Hold off while the transport is disconnected.
+			if n.isDisconnected() {
+				n.resetElectionTimeout()
+				continue
+			}
+			n.failedCampaign(campaignTally{votes: votes, responders: len(responders), late: late})
 			n.switchToCandidate()
 			return
 
+		// Time to send the next wave of vote requests.
+		case <-waves.C():
+			waves.expired()
+
 		// A response to our votes.
 		case vresp := <-n.VoteResponses:
-			// We have a VoteResponse. Only process if
+			if vresp = intercept(n, vresp, false); vresp == nil {
+				continue
+			}
+			waves.responded()
+			// Someone is newer, abandon our campaign.
+			if stepDown := n.handleVoteResponse(vresp); stepDown {
+				n.failedCampaign(campaignTally{votes: votes, responders: len(responders), late: late})
+				n.switchToFollower(NO_LEADER, REASON_HIGHER_TERM)
+				return
+			}
+			if vresp.Term < n.term {
+				late++
+			}
+			if vresp.Term == n.term && vresp.Voter != "" && vresp.Voter != n.id {
+				responders[vresp.Voter] = struct{}{}
+				n.observed(vresp.Voter, vresp.Term, vresp.Observer)
+			}
+			// We have a VoteResponse. Only count it if
 			// it is for our term and Granted is true.
 			if vresp.Granted && vresp.Term == n.term {
 				votes++
-				if n.wonElection(votes) {
-					// Become LEADER if we have won.
-					n.switchToLeader()
-					return
-				}
+				n.addVoter(vresp.Voter)
+			}
+			if n.wonCampaign(votes, responders) {
+				// Become LEADER if we have won.
+				n.switchToElectedLeader()
+				return
 			}
 
 		// A Vote Request.
 		case vreq := <-n.VoteRequests:
+			if vreq = intercept(n, vreq, false); vreq == nil {
+				continue
+			}
 			// We will stepdown if needed. This can happen if the
 			// request is from a newer term than ours.
 			if stepDown := n.handleVoteRequest(vreq); stepDown {
-				n.switchToFollower(NO_LEADER)
+				n.failedCampaign(campaignTally{votes: votes, responders: len(responders), late: late})
+				n.switchToFollower(NO_LEADER, REASON_HIGHER_TERM)
+				return
+			}
+			// Let a preferred competitor win its next campaign.
+			if n.defersTo(vreq) {
+				n.electTimer.Reset(2 * n.nextElectionTimeout())
+				n.switchToFollower(NO_LEADER, REASON_TIEBREAK)
 				return
 			}
 
 		// Process a LEADER's heartbeat.
 		case hb := <-n.HeartBeats:
-			// If they are newer, we will step down.
-			if stepDown := n.handleHeartBeat(hb); stepDown {
-				n.switchToFollower(hb.Leader)
+			if hb = intercept(n, hb, false); hb == nil {
+				continue
+			}
+			// Someone else won our term, or they are newer. Either
+			// way we will step down.
+			reason := REASON_NEW_LEADER
+			if hb.Term > n.term {
+				reason = REASON_HIGHER_TERM
+			}
+			stepDown := n.handleHeartBeat(hb)
+			n.sendHeartBeatResponse(hb)
+			if stepDown {
+				n.switchToFollower(hb.Leader, reason)
 				return
 			}
+
+		// Late responses to heartbeats we sent as LEADER.
+		case <-n.HeartBeatResponses:
 		}
 	}
 }
 
 // Process loop for a FOLLOWER.
 func (n *Node) runAsFollower() {
+	// Whether we already waited for our campaign jitter.
+	jittered := false
+
+	// Consecutive heartbeat intervals without a heartbeat or a vote
+	// granted, counted with WithMaxMissedHeartbeats, and whether we
+	// heard from the LEADER or granted our vote in the current one.
+	missed := 0
+	heard := false
+	var missTick <-chan time.Time
+	if n.opts.maxMissedHeartbeats > 0 {
+		mt := n.opts.clock.NewTicker(n.opts.heartbeat)
+		defer mt.Stop()
+		missTick = mt.C()
+	}
+
 	for {
 		select {
 
@@ -375,20 +1010,103 @@ func (n *Node) runAsFollower() {
 			n.processQuit(q)
 			return
 
+		// Request to pause
+		case p := <-n.pause:
+			n.processPause(p)
+			return
+
+		// Leadership set by an external coordinator.
+		case req := <-n.external:
+			if n.processExternal(req) {
+				return
+			}
+
 		// An ElectionTimeout causes us to go into a Candidate state
 		// and start a new election.
-		case <-n.electTimer.C:
+		case <-n.electTimer.C():
+			// Elections are disabled under external leadership.
+			if n.opts.externalLeadership {
+				continue
+			}
+			// Missed heartbeats start our campaigns instead, unless
+			// we are waiting for our campaign jitter.
+			if n.opts.maxMissedHeartbeats > 0 && !jittered {
+				n.resetElectionTimeout()
+				continue
+			}
+			if !n.canCampaign() {
+				n.resetElectionTimeout()
+				continue
+			}
+			// Desynchronize from the other followers that lost
+			// the same LEADER.
+			if n.opts.campaignJitter > 0 && !jittered {
+				jittered = true
+				n.electTimer.Reset(uniformTimeout(0, n.opts.campaignJitter))
+				continue
+			}
+			n.switchToCandidate()
+			return
+
+		// Another heartbeat interval went by, see WithMaxMissedHeartbeats.
+		case <-missTick:
+			if heard {
+				heard = false
+				missed = 0
+				continue
+			}
+			missed++
+			if missed < n.opts.maxMissedHeartbeats || jittered {
+				continue
+			}
+			if n.opts.externalLeadership || !n.canCampaign() {
+				continue
+			}
+			if n.opts.campaignJitter > 0 {
+				jittered = true
+				n.electTimer.Reset(uniformTimeout(0, n.opts.campaignJitter))
+				continue
+			}
+			n.switchToCandidate()
+			return
+
+		// A campaign requested by StepUp().
+		case s := <-n.stepUp:
+			// Refuse while we can not safely campaign.
+			if n.isCatchingUp() || !n.recovered() || !n.canBecomeLeader() {
+				s <- ErrCannotLead
+				continue
+			}
 			n.switchToCandidate()
+			s <- nil
 			return
 
 		// A Vote Request.
 		case vreq := <-n.VoteRequests:
+			if vreq = intercept(n, vreq, false); vreq == nil {
+				continue
+			}
+			jittered = false
+			_, _, votedAt := n.VoteRecord()
 			if shouldReturn := n.handleVoteRequest(vreq); shouldReturn {
 				return
 			}
+			// Granting our vote restarts the count, as a heartbeat.
+			if _, _, at := n.VoteRecord(); !at.IsZero() && !at.Equal(votedAt) {
+				heard = true
+				missed = 0
+			}
 
 		// Process a LEADER's heartbeat.
 		case hb := <-n.HeartBeats:
+			if hb = intercept(n, hb, false); hb == nil {
+				continue
+			}
+			if n.fromLeader(hb) {
+				jittered = false
+				heard = true
+				missed = 0
+			}
 			// Set the Leader regardless if we currently have none set.
 			if n.leader == NO_LEADER {
 				n.setLeader(hb.Leader)
@@ -397,55 +1115,173 @@ func (n *Node) runAsFollower() {
 			if stepDown := n.handleHeartBeat(hb); stepDown {
 				n.setLeader(hb.Leader)
 			}
+			n.sendHeartBeatResponse(hb)
+			// Campaign right away if the LEADER hands off to us.
+			if hb.Successor == n.id && hb.Term == n.term && n.canSucceed() {
+				n.switchToCandidate()
+				return
+			}
+
+		// Late responses to heartbeats we sent as LEADER.
+		case <-n.HeartBeatResponses:
 		}
 	}
 }
 
-// postError invokes handler.AsyncError() in a go routine.
-// When the handler call returns, and if there are still pending errors,
-// this function will recursively call itself with the first element in
-// the list.
-func (n *Node) postError(err error) {
-	go func() {
-		n.handler.AsyncError(err)
-		n.mu.Lock()
-		n.errors = n.errors[1:]
-		if len(n.errors) > 0 {
-			err := n.errors[0]
-			n.postError(err)
-		}
-		n.mu.Unlock()
-	}()
+// canCampaign returns whether a FOLLOWER may start a campaign. We hold
+// off while the transport is disconnected, while we do not know the
+// current term after losing our state, while we can not persist it, or
+// while the handler would not let us become LEADER.
+func (n *Node) canCampaign() bool {
+	return !n.isDisconnected() && !n.isCatchingUp() && n.recovered() && n.canBecomeLeader()
 }
 
-// Send the error to the async handler.
-func (n *Node) handleError(err error) {
-	n.mu.Lock()
-	n.errors = append(n.errors, err)
-	// Call postError only for the first error added.
-	// Check postError for details.
-	if len(n.errors) == 1 {
-		n.postError(err)
-	}
-	n.mu.Unlock()
-}
+// Process loop while paused. Messages are discarded, as if the node
+// was down, until we are resumed or closed.
+func (n *Node) runAsPaused() {
+	for {
+		select {
 
-// handleHeartBeat is called to process a heartbeat from a LEADER.
-// We will indicate to the controlling process loop if we should
-// "stepdown" from our current role.
-func (n *Node) handleHeartBeat(hb *pb.Heartbeat) bool {
+		// Request to quit
+		case q := <-n.quit:
+			n.processQuit(q)
+			return
 
-	// Ignore old term
-	if hb.Term < n.term {
+		// Request to resume
+		case r := <-n.resume:
+			n.processResume(r)
+			return
+
+		// Already paused.
+		case p := <-n.pause:
+			close(p)
+
+		// Leadership can not be assumed while paused.
+		case req := <-n.external:
+			req.done <- ErrNodePaused
+
+		case <-n.electTimer.C():
+		case <-n.VoteRequests:
+		case <-n.VoteResponses:
+		case <-n.HeartBeats:
+		case <-n.HeartBeatResponses:
+		}
+	}
+}
+
+// Process loop for a node that gave up campaigning. It no longer
+// campaigns until Retry() is called, but still votes like a FOLLOWER,
+// and becomes one again once it hears from a LEADER.
+func (n *Node) runAsGaveUp() {
+	for {
+		select {
+
+		// Request to quit
+		case q := <-n.quit:
+			n.processQuit(q)
+			return
+
+		// Request to retry
+		case r := <-n.retry:
+			n.processRetry(r)
+			return
+
+		// Request to pause
+		case p := <-n.pause:
+			n.processPause(p)
+			return
+
+		// Leadership can not be assumed once we gave up.
+		case req := <-n.external:
+			req.done <- ErrGaveUp
+
+		// A Vote Request, answered as a FOLLOWER would.
+		case vreq := <-n.VoteRequests:
+			if vreq = intercept(n, vreq, false); vreq == nil {
+				continue
+			}
+			n.handleVoteRequest(vreq)
+
+		// A LEADER's heartbeat, which we follow again.
+		case hb := <-n.HeartBeats:
+			if hb = intercept(n, hb, false); hb == nil {
+				continue
+			}
+			follow := hb.Term >= n.term && n.fromLeader(hb)
+			n.handleHeartBeat(hb)
+			n.sendHeartBeatResponse(hb)
+			if follow {
+				n.switchToFollower(hb.Leader, REASON_NEW_LEADER)
+				return
+			}
+
+		// We no longer campaign.
+		case <-n.electTimer.C():
+		case <-n.VoteResponses:
+		case <-n.HeartBeatResponses:
+		}
+	}
+}
+
+// postError invokes handler.AsyncError() with the Scheduler.
+// When the handler call returns, and if there are still pending errors,
+// this function will recursively call itself with the first element in
+// the list.
+func (n *Node) postError(err error) {
+	n.opts.scheduler.Go(func() {
+		n.handler.AsyncError(err)
+		n.mu.Lock()
+		n.errors = n.errors[1:]
+		if len(n.errors) > 0 {
+			err := n.errors[0]
+			n.postError(err)
+		}
+		n.mu.Unlock()
+	})
+}
+
+// Send the error to the async handler.
+func (n *Node) handleError(err error) {
+	n.mu.Lock()
+	n.errors = append(n.errors, err)
+	// Call postError only for the first error added.
+	// Check postError for details.
+	if len(n.errors) == 1 {
+		n.postError(err)
+	}
+	n.mu.Unlock()
+}
+
+// ReportRPCError is used by RPCDrivers to report a connection failure
+// that happened after Init returned, e.g. with asynchronous connections.
+// The handler's AsyncError receives it as an RPCError of KindConnect.
+func (n *Node) ReportRPCError(op string, err error) {
+	n.handleError(&RPCError{Kind: KindConnect, Op: op, Err: err})
+}
+
+// handleHeartBeat is called to process a heartbeat from a LEADER.
+// We will indicate to the controlling process loop if we should
+// "stepdown" from our current role.
+func (n *Node) handleHeartBeat(hb *pb.Heartbeat) bool {
+	term := n.term
+	n.compatiblePeer(hb.Leader, hb.Version)
+
+	// Ignore old term, and other LEADERs of our term, who must
+	// not keep us from campaigning.
+	if hb.Term < n.term || !n.fromLeader(hb) {
 		return false
 	}
 
+	// We now know the current term.
+	n.catchUp(hb.Term)
+
 	// Save state flag
 	saveState := false
 
 	// This will trigger a return from the current runAs loop.
 	stepDown := false
 
+	n.mu.Lock()
 	// Newer term
 	if hb.Term > n.term {
 		n.term = hb.Term
@@ -456,15 +1292,26 @@ func (n *Node) handleHeartBeat(hb *pb.Heartbeat) bool {
 
 	// If we are candidate and someone asserts they are leader for an equal or
 	// higher term, step down.
-	if n.State() == CANDIDATE && hb.Term >= n.term {
+	if n.state == CANDIDATE && hb.Term >= n.term {
 		n.term = hb.Term
 		n.vote = NO_VOTE
 		stepDown = true
 		saveState = true
 	}
 
-	// Reset the election timer.
+	// We have a leader, reset the election timer, extended if the
+	// LEADER asked for it.
+	n.attempts = 0
+	n.campaigns = 0
+	n.failedCount = 0
+	n.failedCampaigns = nil
+	n.mu.Unlock()
 	n.resetElectionTimeout()
+	if hb.Extension > 0 {
+		ext := min(time.Duration(hb.Extension), n.maxExtension())
+		n.electTimer.Reset(n.nextElectionTimeout() + ext)
+	}
+	n.setLeaderMetadata(hb.Metadata)
 
 	// Write our state if needed.
 	if saveState {
@@ -473,19 +1320,128 @@ func (n *Node) handleHeartBeat(hb *pb.Heartbeat) bool {
 			stepDown = true
 		}
 	}
+	if n.term > term {
+		n.termJumped(term, n.term, hb.Leader)
+	}
+	n.heardLeader(hb.Leader)
 
 	return stepDown
 }
 
+// heardLeader records that we heard from leader in our term, and
+// notifies a CurrentHandler if we were not current until then.
+func (n *Node) heardLeader(leader string) {
+	n.mu.Lock()
+	current := n.heardTerm == n.term
+	n.heardTerm = n.term
+	term := n.term
+	n.mu.Unlock()
+	if h, ok := n.handler.(CurrentHandler); !current && ok {
+		h.OnCurrent(term, leader)
+	}
+}
+
+// fromLeader returns whether hb is from a newer term, or from the LEADER
+// we follow in our term, if we know of one.
+func (n *Node) fromLeader(hb *pb.Heartbeat) bool {
+	if hb.Term != n.term {
+		return hb.Term > n.term
+	}
+	leader := n.Leader()
+	return leader == NO_LEADER || leader == hb.Leader
+}
+
+// handleHeartBeatResponse is called by a LEADER to process the
+// response to one of its heartbeats. We will indicate to the
+// controlling process loop if we should "stepdown".
+func (n *Node) handleHeartBeatResponse(hbresp *pb.HeartbeatResponse) bool {
+	// Only a newer term requires action.
+	if hbresp.Term <= n.term {
+		return false
+	}
+	n.adoptTerm(hbresp.Term, hbresp.Follower)
+	return true
+}
+
+// handleVoteResponse is called by a CANDIDATE to process a response
+// to its vote requests carrying a newer term, which it adopts. We will
+// indicate to the controlling process loop if we should "stepdown".
+func (n *Node) handleVoteResponse(vresp *pb.VoteResponse) bool {
+	// Only a newer term requires action.
+	if vresp.Term <= n.term {
+		return false
+	}
+	n.adoptTerm(vresp.Term, vresp.Voter)
+	return true
+}
+
+// sendHeartBeatResponse acknowledges a heartbeat to its LEADER with
+// our current term, if the RPCDriver supports it. A LEADER with an
+// older term will learn it has to step down.
+func (n *Node) sendHeartBeatResponse(hb *pb.Heartbeat) {
+	// Some transports deliver our own heartbeats back to us.
+	if hb.Leader == n.id {
+		return
+	}
+	if hbr, ok := n.transport().(HeartbeatResponder); ok {
+		// An observer answers, but only to be left out of the quorum.
+		observer := n.IsObserver()
+		if observer {
+			n.observeTerm(n.term)
+		}
+		if hbresp := intercept(n, &pb.HeartbeatResponse{Term: n.term, Follower: n.id, Nonce: hb.Nonce, Observer: observer}, true); hbresp != nil {
+			n.ReportPeerError(hb.Leader, hbr.SendHeartbeatResponse(hb.Leader, hbresp))
+		}
+	}
+}
+
+// sendVoteResponse sends our response to a candidate.
+func (n *Node) sendVoteResponse(candidate string, vresp *pb.VoteResponse) {
+	if vresp = intercept(n, vresp, true); vresp != nil {
+		n.ReportPeerError(candidate, n.transport().SendVoteResponse(candidate, vresp))
+	}
+}
+
 // handleVoteRequest will process a vote request and either
 // deny or grant our own vote to the caller.
 func (n *Node) handleVoteRequest(vreq *pb.VoteRequest) bool {
 
-	deny := &pb.VoteResponse{Term: n.term, Granted: false}
+	deny := &pb.VoteResponse{Term: n.term, Granted: false, Voter: n.id, Campaign: vreq.Campaign}
+
+	if h, ok := n.handler.(BaggageHandler); ok && len(vreq.Baggage) > 0 {
+		h.OnBaggage(vreq.Candidate, vreq.Campaign, vreq.Baggage)
+	}
+
+	// We may already have voted in this term before losing our state,
+	// or could not record our vote.
+	if n.refuseVote(vreq.Term) || !n.recovered() {
+		n.sendVoteResponse(vreq.Candidate, deny)
+		return false
+	}
+
+	// Ignore the candidates we do not know.
+	if !n.knownCandidate(vreq.Candidate) {
+		n.handleError(fmt.Errorf("%w: %q", ErrUnknownCandidate, vreq.Candidate))
+		n.sendVoteResponse(vreq.Candidate, deny)
+		return false
+	}
+
+	// Ignore the candidates we may not understand.
+	if !n.compatiblePeer(vreq.Candidate, vreq.Version) && n.opts.refuseIncompatible {
+		n.sendVoteResponse(vreq.Candidate, deny)
+		return false
+	}
 
 	// Old term or candidate's log is behind, reject
 	if vreq.Term < n.term || !n.handler.GrantVote(vreq.CurrentState) {
-		n.rpc.SendVoteResponse(vreq.Candidate, deny)
+		// A LEADER can not keep leading once a newer term started.
+		if vreq.Term > n.term && n.State() == LEADER {
+			n.adoptTerm(vreq.Term, vreq.Candidate)
+			deny.Term = n.term
+			n.sendVoteResponse(vreq.Candidate, deny)
+			return true
+		}
+		n.sendVoteResponse(vreq.Candidate, deny)
 		return false
 	}
 
@@ -494,28 +1450,35 @@ func (n *Node) handleVoteRequest(vreq *pb.VoteRequest) bool {
 
 	// Newer term
 	if vreq.Term > n.term {
-		n.term = vreq.Term
-		n.vote = NO_VOTE
-		n.leader = NO_LEADER
+		n.adoptTerm(vreq.Term, vreq.Candidate)
 		stepDown = true
 	}
 
+	// An observer follows the term, but does not vote. It says so,
+	// for the candidate to leave it out of the quorum.
+	if n.IsObserver() {
+		n.observeTerm(n.term)
+		deny.Term, deny.Observer = n.term, true
+		n.sendVoteResponse(vreq.Candidate, deny)
+		return stepDown
+	}
+
 	// If we are the Leader, deny request unless we have seen
 	// a newer term and must step down.
 	if n.State() == LEADER && !stepDown {
-		n.rpc.SendVoteResponse(vreq.Candidate, deny)
+		n.sendVoteResponse(vreq.Candidate, deny)
 		return stepDown
 	}
 
 	// If we have already cast a vote for this term, reject.
 	if n.vote != NO_VOTE && n.vote != vreq.Candidate {
-		n.rpc.SendVoteResponse(vreq.Candidate, deny)
+		n.sendVoteResponse(vreq.Candidate, deny)
 		return stepDown
 	}
 
 	// We will vote for this candidate.
 
-	n.setVote(vreq.Candidate)
+	n.castVote(vreq.Candidate)
 
 	// Write our state.
 	if err := n.writeState(); err != nil {
@@ -523,14 +1486,23 @@ func (n *Node) handleVoteRequest(vreq *pb.VoteRequest) bool {
 		// and deny the vote.
 		n.handleError(err)
 		n.setVote(NO_VOTE)
-		n.rpc.SendVoteResponse(vreq.Candidate, deny)
+		n.sendVoteResponse(vreq.Candidate, deny)
 		n.resetElectionTimeout()
 		return true
 	}
 
+	// Let the handler quiesce before our vote can take effect.
+	if h, ok := n.handler.(VoteGrantHook); ok {
+		if err := h.BeforeGrantVote(n.term, vreq.Candidate); err != nil {
+			n.sendVoteResponse(vreq.Candidate, deny)
+			return stepDown
+		}
+	}
+
 	// Send our acceptance.
-	accept := &pb.VoteResponse{Term: n.term, Granted: true}
-	n.rpc.SendVoteResponse(vreq.Candidate, accept)
+	n.trace(traceVoteGranted, n.term)
+	accept := &pb.VoteResponse{Term: n.term, Granted: true, Voter: n.id, Campaign: vreq.Campaign}
+	n.sendVoteResponse(vreq.Candidate, accept)
 
 	// Reset ElectionTimeout
 	n.resetElectionTimeout()
@@ -538,14 +1510,51 @@ func (n *Node) handleVoteRequest(vreq *pb.VoteRequest) bool {
 	return stepDown
 }
 
+// adoptTerm moves us to a newer term learned from source, without a
+// vote nor a leader yet, and persists it before we answer anyone.
+func (n *Node) adoptTerm(term uint64, source string) {
+	n.mu.Lock()
+	old := n.term
+	n.term = term
+	n.vote = NO_VOTE
+	n.leader = NO_LEADER
+	n.mu.Unlock()
+	if err := n.writeState(); err != nil {
+		n.handleError(err)
+	}
+	n.termJumped(old, term, source)
+}
+
+// knownCandidate returns whether we may vote for candidate, see
+// WithKnownCandidatesOnly.
+func (n *Node) knownCandidate(candidate string) bool {
+	if !n.opts.knownCandidatesOnly {
+		return true
+	}
+	if len(n.opts.knownCandidates) > 0 {
+		return slices.Contains(n.opts.knownCandidates, candidate)
+	}
+	return slices.Contains(n.opts.peers.Peers(), candidate)
+}
+
 // wonElection returns a bool to determine if we have a
-// majority of the votes.
+// majority of the votes, among the members that may vote.
 func (n *Node) wonElection(votes int) bool {
-	return votes >= quorumNeeded(n.info.Size)
+	return votes >= Quorum(n.votingSize())
 }
 
-// Return the quorum size for a given cluster config.
-func quorumNeeded(clusterSize int) int {
+// singleNode returns whether we are the only member of the cluster, in
+// which case we elect ourselves and lead without any RPC. A node that
+// requires answers from peers with WithMinElectionPeers does not trust
+// its cluster size, and still asks for votes.
+func (n *Node) singleNode() bool {
+	return n.ClusterSize() == 1 && n.opts.minElectionPeers == 0
+}
+
+// Quorum returns the number of votes needed to form a majority
+// in a cluster of the given size. Even sized clusters need more
+// than half of their members, e.g. 4 requires 3.
+func Quorum(clusterSize int) int {
 	switch clusterSize {
 	// Handle 0, but 0 is really an invalid cluster size.
 	case 0:
@@ -555,41 +1564,165 @@ func quorumNeeded(clusterSize int) int {
 	}
 }
 
-// Switch to a FOLLOWER.
-func (n *Node) switchToFollower(leader string) {
+// Switch to a FOLLOWER for the given reason.
+func (n *Node) switchToFollower(leader string, reason Reason) {
 	n.mu.Lock()
 	defer n.mu.Unlock()
-	n.leader = leader
-	n.switchState(FOLLOWER)
+	n.updateLeader(leader)
+	if leader == NO_LEADER {
+		n.leaderMeta = nil
+	}
+	n.switchState(FOLLOWER, reason)
+}
+
+// quorumHeartbeat records that a quorum acknowledged our heartbeat sent
+// at, and notifies a QuorumHeartbeatHandler.
+func (n *Node) quorumHeartbeat(at time.Time) {
+	n.mu.Lock()
+	n.stability.lastQuorum = at
+	n.mu.Unlock()
+	if h, ok := n.handler.(QuorumHeartbeatHandler); ok {
+		h.OnQuorumHeartbeat(n.term, at)
+	}
+}
+
+// termJumped notifies a TermJumpHandler that our term increased from
+// to to, because of source. Lock should not be held.
+func (n *Node) termJumped(from, to uint64, source string) {
+	if h, ok := n.handler.(TermJumpHandler); ok {
+		h.OnTermJump(from, to, source)
+	}
+}
+
+// lostLeadership notifies a LeadershipLossHandler that we are no
+// longer LEADER of term.
+func (n *Node) lostLeadership(term uint64) {
+	if h, ok := n.handler.(LeadershipLossHandler); ok {
+		h.OnLostLeadership(term)
+	}
+}
+
+// defersTo returns whether we, as CANDIDATE, should defer to the
+// competing candidate of vreq, see WithCandidateTiebreak.
+func (n *Node) defersTo(vreq *pb.VoteRequest) bool {
+	if n.opts.tiebreak == nil || vreq.Term != n.term || vreq.Candidate == n.id {
+		return false
+	}
+	return n.opts.tiebreak(vreq.Candidate, n.id)
+}
+
+// wonCampaign returns whether we won the election, which also requires
+// answers from the minimum number of peers and the QuorumPredicate to
+// be satisfied by our voters if configured.
+func (n *Node) wonCampaign(votes int, responders map[string]struct{}) bool {
+	if !n.wonElection(votes) || len(responders) < n.opts.minElectionPeers {
+		return false
+	}
+	if n.opts.quorumPredicate == nil {
+		return true
+	}
+	return n.opts.quorumPredicate(append(n.Voters(), n.id))
+}
+
+// hasQuorum returns whether we and the peers are a quorum, which also
+// requires the QuorumPredicate to be satisfied if configured.
+func (n *Node) hasQuorum(peers map[string]struct{}) bool {
+	if !n.wonElection(len(peers) + 1) {
+		return false
+	}
+	if n.opts.quorumPredicate == nil {
+		return true
+	}
+	granted := make([]string, 0, len(peers)+1)
+	for peer := range peers {
+		granted = append(granted, peer)
+	}
+	return n.opts.quorumPredicate(append(granted, n.id))
+}
+
+// canBecomeLeader returns false for a witness or an observer, or if the
+// handler vetoes our leadership.
+func (n *Node) canBecomeLeader() bool {
+	if n.opts.witness || n.IsObserver() {
+		return false
+	}
+	if v, ok := n.handler.(LeadershipVetoer); ok {
+		return v.CanBecomeLeader()
+	}
+	return true
 }
 
-// Switch to a LEADER.
-func (n *Node) switchToLeader() {
+// Switch to a LEADER after winning an election, unless we were demoted
+// meanwhile or the handler vetoes it, in which case we step down.
+func (n *Node) switchToElectedLeader() {
+	if n.IsObserver() {
+		n.switchToFollower(NO_LEADER, REASON_DEMOTED)
+		return
+	}
+	if !n.canBecomeLeader() {
+		n.switchToFollower(NO_LEADER, REASON_VETOED)
+		return
+	}
+	n.switchToLeader(REASON_WON_ELECTION)
+}
+
+// Switch to a LEADER for the given reason.
+func (n *Node) switchToLeader(reason Reason) {
 	n.mu.Lock()
 	defer n.mu.Unlock()
-	n.leader = n.id
-	n.switchState(LEADER)
+	n.updateLeader(n.id)
+	n.attempts = 0
+	n.campaigns = 0
+	n.failedCount = 0
+	n.failedCampaigns = nil
+	n.latencies = make(map[string]peerLatency)
+	n.switchState(LEADER, reason)
 }
 
-// Switch to a CANDIDATE.
+// Switch to a CANDIDATE, or give up once out of election attempts.
 func (n *Node) switchToCandidate() {
 	n.mu.Lock()
-	defer n.mu.Unlock()
+	if limit := n.opts.maxElectionAttempts; limit > 0 && n.campaigns >= limit {
+		n.leader = NO_LEADER
+		n.leaderMeta = nil
+		n.switchState(GAVE_UP, REASON_GAVE_UP)
+		n.mu.Unlock()
+		n.handleError(fmt.Errorf("%w after %d campaigns", ErrGaveUp, limit))
+		return
+	}
+	n.campaigns++
 	// Increment the term.
+	term := n.term
 	n.term++
 	// Clear current Leader.
 	n.leader = NO_LEADER
+	n.leaderMeta = nil
+	// Count the failed elections.
+	if n.state == CANDIDATE {
+		n.attempts++
+	}
+	n.voters = nil
+	// Tag the new campaign.
+	n.campaign = genUUID()
 	n.resetElectionTimeout()
-	n.switchState(CANDIDATE)
+	n.switchState(CANDIDATE, REASON_ELECTION_TIMEOUT)
+	n.mu.Unlock()
+	n.termJumped(term, term+1, n.id)
 }
 
-// postStateChange invokes handler.StateChange() in a go routine.
+// postStateChange invokes handler.StateChange() with the Scheduler.
 // When the handler call returns, and if there are still pending state
 // changes, this function will recursively call itself with the first
 // element in the list.
 func (n *Node) postStateChange(sc *StateChange) {
-	go func() {
-		n.handler.StateChange(sc.From, sc.To)
+	n.opts.scheduler.Go(func() {
+		if h, ok := n.handler.(StateChangeEventHandler); ok {
+			h.OnStateChange(*sc)
+		} else if h, ok := n.handler.(StateChangeReasonHandler); ok {
+			h.StateChangeWithReason(sc.From, sc.To, sc.Reason)
+		} else {
+			n.handler.StateChange(sc.From, sc.To)
+		}
 		n.mu.Lock()
 		n.stateChg = n.stateChg[1:]
 		if len(n.stateChg) > 0 {
@@ -597,18 +1730,25 @@ func (n *Node) postStateChange(sc *StateChange) {
 			n.postStateChange(sc)
 		}
 		n.mu.Unlock()
-	}()
+	})
 }
 
 // Process a state transition. Assume lock is held on entrance.
 // Call the async handler in a separate Go routine.
-func (n *Node) switchState(state State) {
+func (n *Node) switchState(state State, reason Reason) {
 	if state == n.state {
 		return
 	}
 	old := n.state
 	n.state = state
-	sc := &StateChange{From: old, To: state}
+	if n.stateChanged != nil {
+		close(n.stateChanged)
+		n.stateChanged = nil
+	}
+	sc := &StateChange{From: old, To: state, Reason: reason}
+	if old == CANDIDATE || state == CANDIDATE {
+		sc.Campaign = n.campaign
+	}
 	n.stateChg = append(n.stateChg, sc)
 	// Invoke postStateChange only for the first state change added.
 	// Check postStateChange for details.
@@ -617,16 +1757,19 @@ func (n *Node) switchState(state State) {
 	}
 }
 
-// Reset the election timeout with a random value.
+// Reset the election timeout with a value from the TimeoutStrategy.
 func (n *Node) resetElectionTimeout() {
-	n.electTimer.Reset(randElectionTimeout())
+	n.electTimer.Reset(n.nextElectionTimeout())
+}
+
+func (n *Node) nextElectionTimeout() time.Duration {
+	return n.opts.timeouts.NextElectionTimeout(n.attempts)
 }
 
 // Generate a random timeout between MIN and MAX Election timeouts.
 // The randomness is required for the RAFT algorithm to be stable.
 func randElectionTimeout() time.Duration {
-	delta := mrand.Int63n(int64(MAX_ELECTION_TIMEOUT - MIN_ELECTION_TIMEOUT))
-	return (MIN_ELECTION_TIMEOUT + time.Duration(delta))
+	return uniformTimeout(MIN_ELECTION_TIMEOUT, MAX_ELECTION_TIMEOUT)
 }
 
 // processQuit will change or internal state to CLOSED and will close the
@@ -638,6 +1781,99 @@ func (n *Node) processQuit(q chan struct{}) {
 	close(q)
 }
 
+// processPause will change our state to PAUSED and will close the
+// received channel to release anyone waiting on it.
+func (n *Node) processPause(p chan struct{}) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.leader = NO_LEADER
+	n.switchState(PAUSED, REASON_PAUSED)
+	close(p)
+}
+
+// processResume will change our state back to FOLLOWER, with a fresh
+// election timeout, and will close the received channel.
+func (n *Node) processResume(r chan struct{}) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	// A node that gave up before the pause may campaign again.
+	n.campaigns = 0
+	n.switchState(FOLLOWER, REASON_RESUMED)
+	n.resetElectionTimeout()
+	close(r)
+}
+
+// processRetry will change our state back to FOLLOWER with all the
+// election attempts available again, and will close the received channel.
+func (n *Node) processRetry(r chan struct{}) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.campaigns = 0
+	n.switchState(FOLLOWER, REASON_RETRY)
+	n.resetElectionTimeout()
+	close(r)
+}
+
+// Retry restarts as a FOLLOWER a node that gave up campaigning, see
+// WithMaxElectionAttempts. It has no effect on a node that did not give
+// up.
+func (n *Node) Retry() error {
+	switch n.State() {
+	case CLOSED:
+		return ErrNodeClosed
+	case GAVE_UP:
+	default:
+		return nil
+	}
+	r := make(chan struct{})
+	select {
+	case n.retry <- r:
+	case <-n.closing:
+		return ErrNodeClosed
+	}
+	<-r
+	return nil
+}
+
+// Pause stops the node from taking part in elections, as if it was
+// down, while keeping its RPCDriver and log. A LEADER steps down. Use
+// Resume to restart it as a FOLLOWER.
+func (n *Node) Pause() error {
+	switch n.State() {
+	case CLOSED:
+		return ErrNodeClosed
+	case PAUSED:
+		return nil
+	}
+	p := make(chan struct{})
+	select {
+	case n.pause <- p:
+	case <-n.closing:
+		return ErrNodeClosed
+	}
+	<-p
+	return nil
+}
+
+// Resume restarts a paused node as a FOLLOWER.
+func (n *Node) Resume() error {
+	switch n.State() {
+	case CLOSED:
+		return ErrNodeClosed
+	case PAUSED:
+	default:
+		return nil
+	}
+	r := make(chan struct{})
+	select {
+	case n.resume <- r:
+	case <-n.closing:
+		return ErrNodeClosed
+	}
+	<-r
+	return nil
+}
+
 // waitOnLoopFinish will block until the loops are exiting.
 func (n *Node) waitOnLoopFinish() {
 	q := make(chan struct{})
@@ -655,19 +1891,110 @@ func (n *Node) drainPreviousVoteResponses() {
 	}
 }
 
-// Close will shutdown the Graft node and wait until the
-// state is processed. We will clear timers, channels, etc.
-// and close the log.
-func (n *Node) Close() {
-	if n.State() == CLOSED {
-		return
+// transport returns the current RPC driver.
+func (n *Node) transport() RPCDriver {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.rpc
+}
+
+// SwapRPCDriver replaces the RPC driver of a running node, e.g. to
+// migrate a cluster to another transport node by node. The new driver
+// is initialized before the old one is closed, so the node may briefly
+// receive messages from both. Term, vote and state are preserved. While
+// migrating, nodes on different transports can not reach each other, so
+// a quorum must remain reachable on one of them to keep a LEADER. If the
+// new driver fails to initialize, the old one stays in use. A node
+// created WithDeferredServing must be serving first.
+func (n *Node) SwapRPCDriver(rpc RPCDriver) error {
+	if rpc == nil {
+		return ErrRpcDriverReq
+	}
+	if n.isClosing() {
+		return ErrNodeClosed
+	}
+	if !n.isServing() {
+		return ErrNotServing
+	}
+	if err := checkOptions(n.opts, rpc); err != nil {
+		return err
 	}
-	n.rpc.Close()
+	if err := rpc.Init(n); err != nil {
+		return &RPCError{Kind: KindTransport, Op: "init", Err: err}
+	}
+	n.mu.Lock()
+	// Close could have started meanwhile, and closed the old driver.
+	if n.isClosing() {
+		n.mu.Unlock()
+		rpc.Close()
+		return ErrNodeClosed
+	}
+	old := n.rpc
+	n.rpc = rpc
+	n.mu.Unlock()
+	old.Close()
+	return nil
+}
+
+// Close will shutdown the Graft node and wait until it is done.
+// Concurrent calls wait for the first one. The shutdown is ordered:
+//
+//  1. Stop accepting actions: Pause, Resume, SwapRPCDriver and the
+//     external leadership calls return ErrNodeClosed.
+//  2. Stop the election timer, so no campaign starts meanwhile.
+//  3. Join the loop, which stops the heartbeats of a LEADER, the
+//     PeerProvider watcher and the lease mirror, which releases a lease
+//     still held. The node is then CLOSED.
+//  4. Close the RPCDriver, which nothing sends on anymore. What it
+//     still delivers meanwhile is discarded.
+//  5. Remove the log, which nothing writes anymore.
+func (n *Node) Close() {
+	n.closeOnce.Do(n.shutdown)
+}
+
+// shutdown closes the node in the order documented on Close.
+func (n *Node) shutdown() {
+	close(n.closing)
+	n.electTimer.Stop()
+
 	n.waitOnLoopFinish()
+	if n.peersDone != nil {
+		close(n.peersDone)
+	}
+	if n.lease != nil {
+		close(n.lease.done)
+	}
+	n.routines.Wait()
 	n.clearTimers()
+
+	closed := make(chan struct{})
+	n.routines.Add(1)
+	go func() {
+		defer n.routines.Done()
+		n.drainInbound(closed)
+	}()
+	n.transport().Close()
+	close(closed)
+	n.routines.Wait()
+
 	n.closeLog()
 }
 
+// drainInbound discards the messages delivered to a stopped node until
+// done is closed, so the RPCDriver never blocks on it while closing.
+func (n *Node) drainInbound(done chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-n.HeartBeats:
+		case <-n.HeartBeatResponses:
+		case <-n.VoteRequests:
+		case <-n.VoteResponses:
+		}
+	}
+}
+
 // Return the current state.
 func (n *Node) State() State {
 	n.mu.Lock()
@@ -678,7 +2005,94 @@ func (n *Node) State() State {
 func (n *Node) setLeader(newLeader string) {
 	n.mu.Lock()
 	defer n.mu.Unlock()
-	n.leader = newLeader
+	n.updateLeader(newLeader)
+}
+
+// updateLeader sets the leader and records when we learned of a new one.
+// Lock should be held.
+func (n *Node) updateLeader(leader string) {
+	if leader != NO_LEADER && leader != n.leader {
+		n.electedAt = n.opts.clock.Now()
+		n.recordLeaderChange(n.electedAt)
+	}
+	n.leader = leader
+}
+
+// setDisconnected is used by RPC drivers to report the state of their
+// transport. Elections are held off while disconnected since we could
+// not reach the other members anyway.
+func (n *Node) setDisconnected(disconnected bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.disconnected = disconnected
+}
+
+// isCatchingUp returns whether we lost our state and did not hear
+// from a LEADER yet.
+func (n *Node) isCatchingUp() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.catchingUp
+}
+
+// catchUp ends catching up once we heard the current term. We may
+// have voted in any term up to it.
+func (n *Node) catchUp(term uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.catchingUp {
+		n.catchingUp = false
+		n.voteFloor = max(n.term, term)
+	}
+}
+
+// refuseVote returns whether we must not vote in term because we
+// may have voted in it before losing our state, or answered in it as
+// an observer.
+func (n *Node) refuseVote(term uint64) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.catchingUp || term <= n.voteFloor || term <= n.observedTerm
+}
+
+// setDegraded records whether our state could be written.
+func (n *Node) setDegraded(degraded bool) {
+	n.mu.Lock()
+	changed := n.degraded != degraded
+	n.degraded = degraded
+	n.mu.Unlock()
+	if changed {
+		gauge := 0.0
+		if degraded {
+			gauge = 1
+		}
+		n.opts.metrics.SetGauge(METRIC_STATE_DEGRADED, gauge)
+	}
+}
+
+func (n *Node) isDegraded() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.degraded
+}
+
+// recovered returns whether our state can be written. When degraded,
+// it retries writing the state, which ends the degraded state once
+// the failure is fixed.
+func (n *Node) recovered() bool {
+	if !n.isDegraded() {
+		return true
+	}
+	if err := n.writeState(); err != nil {
+		return false
+	}
+	return true
+}
+
+func (n *Node) isDisconnected() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.disconnected
 }
 
 func (n *Node) Leader() string {
@@ -703,6 +2117,15 @@ func (n *Node) setVote(candidate string) {
 	n.mu.Lock()
 	defer n.mu.Unlock()
 	n.vote = candidate
+	n.votedAt = time.Time{}
+}
+
+// castVote sets our vote and records when we cast it.
+func (n *Node) castVote(candidate string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.vote = candidate
+	n.votedAt = n.opts.clock.Now()
 }
 
 func (n *Node) CurrentVote() string {
@@ -711,6 +2134,268 @@ func (n *Node) CurrentVote() string {
 	return n.vote
 }
 
+// Campaign returns the unique id of our current or last campaign, or
+// the empty string if we never ran one. The id is carried in our vote
+// requests and echoed in the responses, to correlate a campaign across
+// the logs of all nodes.
+func (n *Node) Campaign() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.campaign
+}
+
+// SetBaggage sets the trace baggage sent with the vote requests of our
+// next campaigns, e.g. the correlation id of a request about to call
+// StepUp, until replaced. Peers receive it with their BaggageHandler. A
+// nil baggage stops sending any.
+func (n *Node) SetBaggage(baggage map[string]string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.baggage = maps.Clone(baggage)
+}
+
+// Baggage returns the trace baggage set with SetBaggage.
+func (n *Node) Baggage() map[string]string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return maps.Clone(n.baggage)
+}
+
+// IsCurrent returns whether our view of the cluster is current: we are
+// the LEADER, or a FOLLOWER that heard from the LEADER of our term. A
+// node restarting at a stale term is not current until a heartbeat
+// brings it to the term of the LEADER, and no node is current while an
+// election is in progress. This can gate serving reads after a restart.
+func (n *Node) IsCurrent() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	switch n.state {
+	case LEADER:
+		return true
+	case FOLLOWER:
+		return n.heardTerm == n.term && n.term > 0
+	default:
+		return false
+	}
+}
+
+// HasVotedThisTerm returns whether we cast our vote in the current term,
+// for another candidate or for ourselves. The vote is cleared whenever
+// the term advances, so it is false until we vote in the new term.
+func (n *Node) HasVotedThisTerm() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.vote != NO_VOTE
+}
+
+// addVoter records a peer that granted us its vote.
+func (n *Node) addVoter(voter string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if voter == "" || voter == n.id || slices.Contains(n.voters, voter) {
+		return
+	}
+	n.voters = append(n.voters, voter)
+}
+
+// Voters returns the ids of the peers that granted us their vote in the
+// current term, not counting our own, in the order they did. It is only
+// valid while CANDIDATE or LEADER, and returns nil otherwise. Peers that
+// do not report their id in their responses are not listed.
+func (n *Node) Voters() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.state != CANDIDATE && n.state != LEADER {
+		return nil
+	}
+	return slices.Clone(n.voters)
+}
+
+// VoteRecord returns the current term, whom we voted for in it, if
+// anyone, and when. The time is zero without a vote, or when the vote
+// was loaded from the log rather than cast by this process.
+func (n *Node) VoteRecord() (term uint64, votedFor string, at time.Time) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.vote != NO_VOTE {
+		at = n.votedAt
+	}
+	return n.term, n.vote, at
+}
+
+// SetAdvertised sets the metadata carried by our heartbeats while
+// LEADER, e.g. the address clients should use. Followers expose it with
+// LeaderMetadata. It is limited to MAX_ADVERTISED_SIZE bytes.
+func (n *Node) SetAdvertised(metadata []byte) error {
+	if len(metadata) > MAX_ADVERTISED_SIZE {
+		return ErrAdvertisedTooLarge
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.advertised = bytes.Clone(metadata)
+	return nil
+}
+
+// newHeartbeat assembles our heartbeat, dropping the advertised metadata
+// if it would not fit the size budget.
+func (n *Node) newHeartbeat(nonce uint64) *pb.Heartbeat {
+	hb := &pb.Heartbeat{
+		Term:      n.term,
+		Leader:    n.id,
+		Nonce:     nonce,
+		Metadata:  n.advertisedMetadata(),
+		Extension: uint64(n.leadershipExtension()),
+		Version:   n.opts.protocolVersion,
+	}
+	if n.opts.maxHeartbeatSize <= 0 || len(hb.Metadata) == 0 {
+		return hb
+	}
+	if size := proto.Size(hb); size > n.opts.maxHeartbeatSize {
+		// Warn once per metadata, not at every heartbeat.
+		if !bytes.Equal(hb.Metadata, n.oversized) {
+			n.oversized = hb.Metadata
+			n.handleError(fmt.Errorf("%w: %d bytes, sent without the advertised metadata", ErrHeartbeatTooLarge, size))
+		}
+		hb.Metadata = nil
+	}
+	return hb
+}
+
+func (n *Node) advertisedMetadata() []byte {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.advertised
+}
+
+func (n *Node) setLeaderMetadata(metadata []byte) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.leaderMeta = bytes.Clone(metadata)
+}
+
+// LeaderMetadata returns the metadata advertised by the current LEADER,
+// or nil if there is none. A LEADER returns its own metadata.
+func (n *Node) LeaderMetadata() []byte {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.state == LEADER {
+		return bytes.Clone(n.advertised)
+	}
+	return bytes.Clone(n.leaderMeta)
+}
+
+// recordLatency stores the heartbeat round-trip time to peer and
+// reports it to the metrics hook.
+func (n *Node) recordLatency(peer string, d time.Duration) {
+	n.mu.Lock()
+	if n.latencies != nil {
+		now := n.opts.clock.Now()
+		n.latencies[peer] = peerLatency{d: d, at: now}
+		prunePeers(n.latencies, n.peerCutoff(now), func(pl peerLatency) time.Time { return pl.at })
+	}
+	n.mu.Unlock()
+	n.opts.metrics.ObserveDuration(METRIC_PEER_LATENCY, d, Label{Name: "peer", Value: peer})
+}
+
+// PeerLatencies returns the last heartbeat round-trip time measured to
+// each peer. It is empty unless we are LEADER and an RPCDriver
+// implementing HeartbeatResponder delivered responses.
+// Peers are pruned as described in WithPeerStateRetention.
+func (n *Node) PeerLatencies() map[string]time.Duration {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.state != LEADER {
+		return map[string]time.Duration{}
+	}
+	cutoff := n.peerCutoff(n.opts.clock.Now())
+	latencies := make(map[string]time.Duration, len(n.latencies))
+	for peer, pl := range n.latencies {
+		if pl.at.After(cutoff) {
+			latencies[peer] = pl.d
+		}
+	}
+	return latencies
+}
+
+// peerLatency is a heartbeat round-trip time to a peer, and when it was
+// measured.
+type peerLatency struct {
+	d  time.Duration
+	at time.Time
+}
+
+// maxTrackedPeers bounds the peers we keep state for, e.g. latencies,
+// in case ids of transient peers show up on a shared transport.
+const maxTrackedPeers = 1024
+
+// peerCutoff returns the time before which the state of a peer we have
+// not heard from is pruned. See WithPeerStateRetention. Lock should be
+// held.
+func (n *Node) peerCutoff(now time.Time) time.Time {
+	if n.opts.peerRetention <= 0 {
+		return time.Time{}
+	}
+	return now.Add(-n.opts.peerRetention)
+}
+
+// prunePeers removes the entries of peers last seen at or before
+// cutoff, then the least recently seen ones beyond maxTrackedPeers.
+func prunePeers[V any](peers map[string]V, cutoff time.Time, seen func(V) time.Time) {
+	for peer, v := range peers {
+		if !seen(v).After(cutoff) {
+			delete(peers, peer)
+		}
+	}
+	for len(peers) > maxTrackedPeers {
+		var oldest string
+		var at time.Time
+		for peer, v := range peers {
+			if oldest == "" || seen(v).Before(at) {
+				oldest, at = peer, seen(v)
+			}
+		}
+		delete(peers, oldest)
+	}
+}
+
+// peerError is a communication failure with a peer, and its time.
+type peerError struct {
+	err error
+	at  time.Time
+}
+
+// ReportPeerError is used by RPCDrivers to report a failure to
+// communicate with peer, e.g. while receiving from it. Failures to send
+// to a peer are recorded by the node. See LastPeerError.
+func (n *Node) ReportPeerError(peer string, err error) {
+	if err == nil || peer == "" {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.peerErrors == nil {
+		n.peerErrors = make(map[string]peerError)
+	}
+	now := n.opts.clock.Now()
+	n.peerErrors[peer] = peerError{err: err, at: now}
+	prunePeers(n.peerErrors, n.peerCutoff(now), func(pe peerError) time.Time { return pe.at })
+}
+
+// LastPeerError returns the last failure to communicate with peer, and
+// when it happened, e.g. to alert on a misconfigured or down peer. It is
+// nil if none happened. A failure is kept after communication recovers,
+// compare its time to spot a recovered peer. It is forgotten once the peer
+// is pruned, see WithPeerStateRetention.
+func (n *Node) LastPeerError(peer string) (error, time.Time) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	pe := n.peerErrors[peer]
+	if !pe.at.After(n.peerCutoff(n.opts.clock.Now())) {
+		return nil, time.Time{}
+	}
+	return pe.err, pe.at
+}
+
 func (n *Node) LogPath() string {
 	n.mu.Lock()
 	defer n.mu.Unlock()
@@ -14,9 +14,18 @@
 package graft
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
 	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/nats-io/graft/pb"
 )
 
 func TestNew(t *testing.T) {
@@ -50,6 +59,11 @@ func TestNew(t *testing.T) {
 	if _, err := New(ci, hand, badRpc, ""); err == nil {
 		t.Fatal("Expected an error with a bad rpcDriver argument")
 	}
+	_, err := New(ci, hand, badRpc, log)
+	var rerr *RPCError
+	if !errors.As(err, &rerr) || rerr.Kind != KindTransport || rerr.Op != "init" {
+		t.Fatalf("Expected an RPCError for a failed Init, got: %v", err)
+	}
 
 	// Test peer count
 	mpc := mockPeerCount()
@@ -85,6 +99,47 @@ func TestNew(t *testing.T) {
 	}
 }
 
+func TestClusterInfoValidate(t *testing.T) {
+	if err := (ClusterInfo{Size: 3}).Validate(); err != ErrClusterName {
+		t.Fatalf("Expected %v, got: %v", ErrClusterName, err)
+	}
+	if err := (ClusterInfo{Name: "foo"}).Validate(); err != ErrClusterSize {
+		t.Fatalf("Expected %v, got: %v", ErrClusterSize, err)
+	}
+	for size := 1; size <= 7; size++ {
+		ci := ClusterInfo{Name: "foo", Size: size}
+		if err := ci.Validate(); err != nil {
+			t.Fatalf("Expected no error for size %d, got: %v", size, err)
+		}
+	}
+}
+
+func TestNoElectionWhileDisconnected(t *testing.T) {
+	ci := ClusterInfo{Name: "foo", Size: 1}
+	hand, rpc, log := genNodeArgs(t)
+	node, err := New(ci, hand, rpc, log)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+
+	node.setDisconnected(true)
+	node.mu.Lock()
+	node.electTimer.Reset(time.Millisecond)
+	node.mu.Unlock()
+
+	time.Sleep(50 * time.Millisecond)
+	if state := node.State(); state != FOLLOWER {
+		t.Fatalf("Expected Node to hold off elections, got: %s", state)
+	}
+
+	// Once connected the node campaigns again.
+	node.setDisconnected(false)
+	if state := waitForState(node, LEADER); state != LEADER {
+		t.Fatalf("Expected Node to be in Leader state, got: %s", state)
+	}
+}
+
 func TestClose(t *testing.T) {
 	base := runtime.NumGoroutine()
 
@@ -127,6 +182,84 @@ func TestClose(t *testing.T) {
 	}
 }
 
+func TestCloseUnderActivity(t *testing.T) {
+	ci := ClusterInfo{Name: "teardown", Size: 3}
+	for i := 0; i < 20; i++ {
+		hub := NewMockHub()
+		nodes := make([]*Node, 3)
+		rpcs := make([]*MockRpcDriver, 3)
+		for j := range nodes {
+			hand, _, log := genNodeArgs(t)
+			rpcs[j] = hub.NewRpc()
+			node, err := New(ci, hand, rpcs[j], log, WithHeartbeatInterval(time.Millisecond, 10, 20))
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			nodes[j] = node
+		}
+
+		// Pause and resume the nodes until they are closed.
+		var wg sync.WaitGroup
+		for _, node := range nodes {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					if err := node.Pause(); err == ErrNodeClosed {
+						return
+					}
+					if err := node.Resume(); err == ErrNodeClosed {
+						return
+					}
+				}
+			}()
+		}
+		time.Sleep(time.Duration(i) * time.Millisecond)
+
+		// Close them concurrently, twice.
+		closed := make(chan struct{})
+		go func() {
+			var cwg sync.WaitGroup
+			for _, node := range append(nodes, nodes...) {
+				cwg.Add(1)
+				go func() {
+					defer cwg.Done()
+					node.Close()
+				}()
+			}
+			cwg.Wait()
+			wg.Wait()
+			close(closed)
+		}()
+		select {
+		case <-closed:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("[%d] Expected the nodes to be closed", i)
+		}
+
+		for j, node := range nodes {
+			if state := node.State(); state != CLOSED {
+				t.Fatalf("[%d] Expected node to be in Closed state, got: %s", i, state)
+			}
+			if !rpcs[j].closeCalled {
+				t.Fatalf("[%d] RPCDriver was not shutdown properly", i)
+			}
+			if node.electTimer != nil {
+				t.Fatalf("[%d] electTimer was not cleared", i)
+			}
+			if err := node.Pause(); err != ErrNodeClosed {
+				t.Fatalf("[%d] Expected %v, got: %v", i, ErrNodeClosed, err)
+			}
+			if err := node.writeState(); err != ErrLogClosed {
+				t.Fatalf("[%d] Expected %v, got: %v", i, ErrLogClosed, err)
+			}
+		}
+		if count := hub.Count(); count != 0 {
+			t.Fatalf("[%d] Expected no registered nodes, got %d", i, count)
+		}
+	}
+}
+
 func TestElectionTimeoutDuration(t *testing.T) {
 	et := randElectionTimeout()
 	if et < MIN_ELECTION_TIMEOUT || et > MAX_ELECTION_TIMEOUT {
@@ -273,6 +406,49 @@ func TestDownToOneAndBack(t *testing.T) {
 	}
 }
 
+func TestSwapRPCDriver(t *testing.T) {
+	nodes := createNodes(t, "swap", 3)
+	for _, n := range nodes {
+		defer n.Close()
+	}
+	expectedClusterState(t, nodes, 1, 2, 0)
+
+	if err := nodes[0].SwapRPCDriver(nil); err != ErrRpcDriverReq {
+		t.Fatalf("Expected %v, got: %v", ErrRpcDriverReq, err)
+	}
+	failing := NewMockRpc()
+	failing.shouldFailInit = true
+	var rerr *RPCError
+	if err := nodes[0].SwapRPCDriver(failing); !errors.As(err, &rerr) {
+		t.Fatalf("Expected an RPCError, got: %v", err)
+	}
+
+	// Swap the driver of every node, preserving their state.
+	for _, n := range nodes {
+		state, term, vote := n.State(), n.CurrentTerm(), n.CurrentVote()
+		old := n.transport().(*MockRpcDriver)
+		if err := n.SwapRPCDriver(NewMockRpc()); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if !old.closeCalled {
+			t.Fatal("Expected the old driver to be closed")
+		}
+		if n.State() != state || n.CurrentTerm() != term || n.CurrentVote() != vote {
+			t.Fatalf("Expected the state to be preserved")
+		}
+	}
+	expectedClusterState(t, nodes, 1, 2, 0)
+
+	// Elections still work with the new drivers.
+	leader := findLeader(nodes)
+	leader.Close()
+	expectedClusterState(t, nodes, 1, 1, 0)
+
+	if err := leader.SwapRPCDriver(NewMockRpc()); err != ErrNodeClosed {
+		t.Fatalf("Expected %v, got: %v", ErrNodeClosed, err)
+	}
+}
+
 func TestReElection(t *testing.T) {
 	toStart := 5
 	nodes := createNodes(t, "foo", toStart)
@@ -330,3 +506,845 @@ func TestNetworkSplit(t *testing.T) {
 
 	expectedClusterState(t, nodes, 1, clusterSize-1, 0)
 }
+
+func TestWitness(t *testing.T) {
+	ci := ClusterInfo{Name: "witness", Size: 3}
+	nodes := make([]*Node, 3)
+	for i := range nodes {
+		hand, rpc, log := genNodeArgs(t)
+		var opts []Option
+		if i == 2 {
+			opts = append(opts, WithWitness())
+		}
+		node, err := New(ci, hand, rpc, log, opts...)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		defer node.Close()
+		nodes[i] = node
+	}
+	witness := nodes[2]
+
+	// The two full nodes can only reach the witness.
+	mockBlockLink(nodes[0], nodes[1])
+
+	// The witness times out first, but does not campaign.
+	witness.mu.Lock()
+	witness.electTimer.Reset(time.Millisecond)
+	witness.mu.Unlock()
+
+	// The vote of the witness elects one of the full nodes.
+	var leader *Node
+	deadline := time.Now().Add(3 * MAX_ELECTION_TIMEOUT)
+	for leader == nil && time.Now().Before(deadline) {
+		if witness.State() != FOLLOWER {
+			t.Fatalf("Expected the witness to stay a Follower, got: %s", witness.State())
+		}
+		for _, n := range nodes[:2] {
+			if n.State() == LEADER {
+				leader = n
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if leader == nil {
+		t.Fatal("Expected a full node to be elected with the witness vote")
+	}
+	if vote := witness.CurrentVote(); vote != leader.Id() {
+		t.Fatalf("Expected the witness to have voted for %s, got %q", leader.Id(), vote)
+	}
+}
+
+func TestObserver(t *testing.T) {
+	ci := ClusterInfo{Name: "observer", Size: 3}
+	hub := NewMockHub()
+	nodes := make([]*Node, 3)
+	for i := range nodes {
+		hand, _, log := genNodeArgs(t)
+		node, err := New(ci, hand, hub.NewRpc(), log, WithHeartbeatInterval(5*time.Millisecond, 10, 20))
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		defer node.Close()
+		nodes[i] = node
+	}
+	elected := func(nodes []*Node) *Node {
+		deadline := time.Now().Add(3 * time.Second)
+		for time.Now().Before(deadline) {
+			if leader := findLeader(nodes); leader != nil {
+				return leader
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		return nil
+	}
+	leader := elected(nodes)
+	if leader == nil {
+		t.Fatal("Expected a LEADER to be elected")
+	}
+
+	// The demoted LEADER steps down, and the remaining two elect.
+	if err := leader.DemoteToObserver(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if state := waitForState(leader, FOLLOWER); state != FOLLOWER {
+		t.Fatalf("Expected the observer to be a Follower, got: %s", state)
+	}
+	var others []*Node
+	for _, n := range nodes {
+		if n != leader {
+			others = append(others, n)
+		}
+	}
+	next := elected(others)
+	if next == nil {
+		t.Fatal("Expected the remaining nodes to elect a LEADER")
+	}
+
+	// The observer follows, without voting.
+	if l := waitForLeader(leader, next.Id()); l != next.Id() {
+		t.Fatalf("Expected the observer to follow %s, got %q", next.Id(), l)
+	}
+	if term := leader.CurrentTerm(); term != next.CurrentTerm() {
+		t.Fatalf("Expected the observer to be at term %d, got %d", next.CurrentTerm(), term)
+	}
+	if vote := leader.CurrentVote(); vote != NO_VOTE {
+		t.Fatalf("Expected the observer not to vote, got %q", vote)
+	}
+
+	// It never campaigns, even without a LEADER.
+	next.Close()
+	time.Sleep(10 * 20 * 5 * time.Millisecond)
+	if state := leader.State(); state != FOLLOWER {
+		t.Fatalf("Expected the observer to stay a Follower, got: %s", state)
+	}
+
+	// Promoted, it can lead again.
+	if err := leader.PromoteFromObserver(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if elected(nodes) == nil {
+		t.Fatal("Expected a LEADER to be elected after the promotion")
+	}
+	leader.Close()
+	if err := leader.DemoteToObserver(); err != ErrNodeClosed {
+		t.Fatalf("Expected %v, got: %v", ErrNodeClosed, err)
+	}
+}
+
+func TestObserverQuorum(t *testing.T) {
+	var all []*Node
+	defer func() {
+		for _, n := range all {
+			n.Close()
+		}
+	}()
+	observerCluster := func(size int) []*Node {
+		ci := ClusterInfo{Name: "observer_quorum", Size: size}
+		hub := NewMockHub()
+		nodes := make([]*Node, size)
+		for i := range nodes {
+			hand, _, log := genNodeArgs(t)
+			node, err := New(ci, hand, hub.NewRpc(), log, WithHeartbeatInterval(5*time.Millisecond, 10, 20))
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			nodes[i] = node
+			all = append(all, node)
+		}
+		return nodes
+	}
+	elected := func(nodes []*Node) *Node {
+		deadline := time.Now().Add(3 * time.Second)
+		for time.Now().Before(deadline) {
+			if leader := findLeader(nodes); leader != nil {
+				return leader
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		return nil
+	}
+	votingSize := func(n *Node, size int) int {
+		deadline := time.Now().Add(time.Second)
+		for time.Now().Before(deadline) && n.votingSize() != size {
+			time.Sleep(5 * time.Millisecond)
+		}
+		return n.votingSize()
+	}
+
+	// In a cluster of 3, the LEADER leaves an observer out of its quorum.
+	nodes := observerCluster(3)
+	leader := elected(nodes)
+	if leader == nil {
+		t.Fatal("Expected a LEADER to be elected")
+	}
+	observer := firstFollower(nodes)
+	if err := observer.DemoteToObserver(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if size := votingSize(leader, 2); size != 2 {
+		t.Fatalf("Expected a voting size of 2, got %d", size)
+	}
+	if q := Quorum(leader.votingSize()); q != 2 {
+		t.Fatalf("Expected a quorum of 2, got %d", q)
+	}
+	// Once promoted it is counted again, but never votes in the term
+	// it observed.
+	term := observer.CurrentTerm()
+	if err := observer.PromoteFromObserver(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if size := votingSize(leader, 3); size != 3 {
+		t.Fatalf("Expected a voting size of 3, got %d", size)
+	}
+	if !observer.refuseVote(term) || observer.refuseVote(term+1) {
+		t.Fatalf("Expected the promoted observer to refuse votes only up to term %d", term)
+	}
+
+	// In a cluster of 4 with an observer, the 2 remaining voters elect
+	// once the LEADER is gone, which would otherwise need 3 votes.
+	nodes = observerCluster(4)
+	leader = elected(nodes)
+	if leader == nil {
+		t.Fatal("Expected a LEADER to be elected")
+	}
+	if err := firstFollower(nodes).DemoteToObserver(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	leader.Close()
+	var voters []*Node
+	for _, n := range nodes {
+		if n != leader && !n.IsObserver() {
+			voters = append(voters, n)
+		}
+	}
+	next := elected(voters)
+	if next == nil {
+		t.Fatal("Expected the remaining voters to elect a LEADER")
+	}
+	if size := next.votingSize(); size != 3 {
+		t.Fatalf("Expected a voting size of 3, got %d", size)
+	}
+
+	// Observers of past terms are forgotten.
+	node := &Node{term: 5}
+	node.observed("a", 4, true)
+	node.observed("b", 5, true)
+	if _, ok := node.observers["a"]; ok || len(node.observers) != 1 {
+		t.Fatalf("Expected only the observer of term 5, got %v", node.observers)
+	}
+}
+
+func TestStepUp(t *testing.T) {
+	ci := ClusterInfo{Name: "stepup", Size: 3}
+	hub := NewMockHub()
+	nodes := make([]*Node, 3)
+	for i := range nodes {
+		hand, _, log := genNodeArgs(t)
+		var opts []Option
+		if i == 2 {
+			opts = append(opts, WithWitness())
+		}
+		node, err := New(ci, hand, hub.NewRpc(), log, opts...)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		defer node.Close()
+		nodes[i] = node
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := nodes[2].StepUp(ctx); err != ErrCannotLead {
+		t.Fatalf("Expected %v, got: %v", ErrCannotLead, err)
+	}
+
+	// Each full node in turn takes the leadership over.
+	for i := 0; i < 4; i++ {
+		node := nodes[i%2]
+		if err := node.StepUp(ctx); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if state := node.State(); state != LEADER {
+			t.Fatalf("Expected Node to be in Leader state, got: %s", state)
+		}
+		for _, other := range nodes {
+			if other == node {
+				continue
+			}
+			if l := waitForLeader(other, node.Id()); l != node.Id() {
+				t.Fatalf("Expected %s to follow %s, got %q", other.Id(), node.Id(), l)
+			}
+		}
+		// Already LEADER.
+		if err := node.StepUp(ctx); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+	}
+
+	nodes[0].Close()
+	if err := nodes[0].StepUp(ctx); err != ErrNodeClosed {
+		t.Fatalf("Expected %v, got: %v", ErrNodeClosed, err)
+	}
+}
+
+// onceLeadHandler lets its node become LEADER on the first check only.
+type onceLeadHandler struct {
+	dummyHandler
+	checks atomic.Int32
+}
+
+func (h *onceLeadHandler) CanBecomeLeader() bool {
+	return h.checks.Add(1) == 1
+}
+
+func TestStepUpRefused(t *testing.T) {
+	ci := ClusterInfo{Name: "stepup_refused", Size: 3}
+	_, rpc, log := genNodeArgs(t)
+	node, err := New(ci, &onceLeadHandler{}, rpc, log)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+	// Delay elections
+	node.mu.Lock()
+	node.electTimer.Reset(10 * time.Second)
+	node.mu.Unlock()
+
+	// Vetoed once handed to the loop, the campaign is refused, not lost.
+	if err := node.StepUp(context.Background()); err != ErrCannotLead {
+		t.Fatalf("Expected %v, got: %v", ErrCannotLead, err)
+	}
+	if state := node.State(); state != FOLLOWER {
+		t.Fatalf("Expected Node to stay a Follower, got: %s", state)
+	}
+}
+
+func TestTransferLeadership(t *testing.T) {
+	nodes := createNodes(t, "transfer", 3)
+	for _, n := range nodes {
+		defer n.Close()
+	}
+	expectedClusterState(t, nodes, 1, 2, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	leader := findLeader(nodes)
+	target := firstFollower(nodes)
+	if err := target.TransferLeadership(ctx, leader.id); err != ErrNotLeader {
+		t.Fatalf("Expected %v, got: %v", ErrNotLeader, err)
+	}
+
+	// Hand off to a given follower, faster than an election timeout.
+	term := leader.CurrentTerm()
+	start := time.Now()
+	if err := leader.TransferLeadership(ctx, target.id); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if state := waitForState(target, LEADER); state != LEADER {
+		t.Fatalf("Expected the successor to be in Leader state, got: %s", state)
+	}
+	if elapsed := time.Since(start); elapsed >= MIN_ELECTION_TIMEOUT {
+		t.Fatalf("Expected the handoff to take less than %v, took %v", MIN_ELECTION_TIMEOUT, elapsed)
+	}
+	if got := target.CurrentTerm(); got != term+1 {
+		t.Fatalf("Expected the successor to lead term %d, got %d", term+1, got)
+	}
+	if state := leader.State(); state == LEADER {
+		t.Fatalf("Expected the previous Leader to have stepped down")
+	}
+
+	// A peer that never campaigns.
+	short, cancelShort := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancelShort()
+	if err := target.TransferLeadership(short, "ghost"); err != context.DeadlineExceeded {
+		t.Fatalf("Expected %v, got: %v", context.DeadlineExceeded, err)
+	}
+	if state := target.State(); state != LEADER {
+		t.Fatalf("Expected to still be Leader, got: %s", state)
+	}
+
+	// Alone, there is nobody to hand off to.
+	ci := ClusterInfo{Name: "transfer-single", Size: 1}
+	hand, _, log := genNodeArgs(t)
+	single, err := New(ci, hand, NewMockHub().NewRpc(), log)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer single.Close()
+	if state := waitForState(single, LEADER); state != LEADER {
+		t.Fatalf("Expected Node to be in Leader state, got: %s", state)
+	}
+	if err := single.TransferLeadership(ctx, NO_LEADER); err != ErrNoSuccessor {
+		t.Fatalf("Expected %v, got: %v", ErrNoSuccessor, err)
+	}
+}
+
+func TestDeferredServing(t *testing.T) {
+	ci := ClusterInfo{Name: "deferred", Size: 1}
+	hub := NewMockHub()
+	hand, _, log := genNodeArgs(t)
+	ps := PersistentState{CurrentTerm: 5, ClusterName: ci.Name}
+	var buf bytes.Buffer
+	if err := EncodeState(&buf, ps); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if err := os.WriteFile(log, buf.Bytes(), 0660); err != nil {
+		t.Fatalf("Error writing the log: %v", err)
+	}
+	node, err := New(ci, hand, hub.NewRpc(), log, WithDeferredServing(),
+		WithHeartbeatInterval(5*time.Millisecond, 10, 20))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+
+	// The state is loaded, but nothing is served yet.
+	if term := node.CurrentTerm(); term != 5 {
+		t.Fatalf("Expected the persisted term 5, got %d", term)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if count := hub.Count(); count != 0 {
+		t.Fatalf("Expected the driver not to be initialized, got %d registered nodes", count)
+	}
+	if state := node.State(); state != FOLLOWER || node.CurrentTerm() != 5 {
+		t.Fatalf("Expected no election, got %s at term %d", state, node.CurrentTerm())
+	}
+	if err := node.SwapRPCDriver(hub.NewRpc()); err != ErrNotServing {
+		t.Fatalf("Expected %v, got: %v", ErrNotServing, err)
+	}
+
+	// Serving, the single node elects itself.
+	if err := node.StartServing(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if count := hub.Count(); count != 1 {
+		t.Fatalf("Expected the driver to be initialized, got %d registered nodes", count)
+	}
+	if state := waitForState(node, LEADER); state != LEADER {
+		t.Fatalf("Expected Node to be in Leader state, got: %s", state)
+	}
+	if err := node.StartServing(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	// A node closed before serving.
+	_, _, log2 := genNodeArgs(t)
+	idle, err := New(ci, hand, hub.NewRpc(), log2, WithDeferredServing())
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	idle.Close()
+	if state := idle.State(); state != CLOSED {
+		t.Fatalf("Expected node to be in Closed state, got: %s", state)
+	}
+	if err := idle.StartServing(); err != ErrNodeClosed {
+		t.Fatalf("Expected %v, got: %v", ErrNodeClosed, err)
+	}
+}
+
+func TestPauseAndReloadState(t *testing.T) {
+	ci := ClusterInfo{Name: "reload", Size: 1}
+	hand, rpc, log := genNodeArgs(t)
+	node, err := New(ci, hand, rpc, log)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+
+	if state := waitForState(node, LEADER); state != LEADER {
+		t.Fatalf("Expected Node to be in Leader state, got: %s", state)
+	}
+	if err := node.ReloadState(); err != ErrNodeNotPaused {
+		t.Fatalf("Expected %v, got: %v", ErrNodeNotPaused, err)
+	}
+
+	// A LEADER steps down when paused.
+	if err := node.Pause(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if state := node.State(); state != PAUSED {
+		t.Fatalf("Expected node to be in Paused state, got: %s", state)
+	}
+	if leader := node.Leader(); leader != NO_LEADER {
+		t.Fatalf("Expected no leader, got: %s", leader)
+	}
+
+	// Rewrite the state as an operator would.
+	edit := &Node{opts: defaultOptions(), info: ci, logPath: log, term: 7, vote: "other"}
+	if err := edit.writeState(); err != nil {
+		t.Fatalf("Unexpected error writing state: %v", err)
+	}
+	if err := node.ReloadState(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if term := node.CurrentTerm(); term != 7 {
+		t.Fatalf("Expected term 7, got %d", term)
+	}
+	if vote := node.CurrentVote(); vote != "other" {
+		t.Fatalf("Expected vote for other, got %q", vote)
+	}
+
+	// We continue from the reloaded term.
+	if err := node.Resume(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if state := waitForState(node, LEADER); state != LEADER {
+		t.Fatalf("Expected Node to be in Leader state, got: %s", state)
+	}
+	if term := node.CurrentTerm(); term != 8 {
+		t.Fatalf("Expected term 8, got %d", term)
+	}
+
+	node.Close()
+	if err := node.Pause(); err != ErrNodeClosed {
+		t.Fatalf("Expected %v, got: %v", ErrNodeClosed, err)
+	}
+	if err := node.ReloadState(); err != ErrNodeClosed {
+		t.Fatalf("Expected %v, got: %v", ErrNodeClosed, err)
+	}
+}
+
+func TestResetState(t *testing.T) {
+	ci := ClusterInfo{Name: "reset", Size: 1}
+	hand, rpc, log := genNodeArgs(t)
+	node, err := New(ci, hand, rpc, log)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+
+	if state := waitForState(node, LEADER); state != LEADER {
+		t.Fatalf("Expected Node to be in Leader state, got: %s", state)
+	}
+	if err := node.ResetState(); err != ErrNodeNotPaused {
+		t.Fatalf("Expected %v, got: %v", ErrNodeNotPaused, err)
+	}
+
+	if err := node.Pause(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	// A failed write keeps the state, as on disk.
+	term, vote := node.CurrentTerm(), node.CurrentVote()
+	os.Chmod(log, 0400)
+	if err := node.ResetState(); err == nil {
+		t.Fatal("Expected an error writing the state")
+	}
+	os.Chmod(log, 0660)
+	if node.CurrentTerm() != term || node.CurrentVote() != vote {
+		t.Fatalf("Expected term %d and vote %q, got %d and %q", term, vote, node.CurrentTerm(), node.CurrentVote())
+	}
+	if err := node.ResetState(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if term, vote := node.CurrentTerm(), node.CurrentVote(); term != 0 || vote != NO_VOTE {
+		t.Fatalf("Expected term 0 and no vote, got %d and %q", term, vote)
+	}
+	ps, err := LoadPersistentState(log)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if ps.CurrentTerm != 0 || ps.VotedFor != NO_VOTE || ps.ClusterName != ci.Name {
+		t.Fatalf("Expected a fresh state, got %+v", ps)
+	}
+
+	node.Close()
+	if err := node.ResetState(); err != ErrNodeClosed {
+		t.Fatalf("Expected %v, got: %v", ErrNodeClosed, err)
+	}
+}
+
+func TestParallelMockHubs(t *testing.T) {
+	for i := 0; i < 4; i++ {
+		t.Run(fmt.Sprintf("hub%d", i), func(t *testing.T) {
+			t.Parallel()
+			hub := NewMockHub()
+			ci := ClusterInfo{Name: "parallel", Size: 3}
+			// The same ids in every hub, which only isolation allows.
+			nodes := make([]*Node, 3)
+			for j := range nodes {
+				hand, _, log := genNodeArgs(t)
+				node, err := New(ci, hand, hub.NewRpc(), log,
+					WithId(fmt.Sprintf("node%d", j)), WithHeartbeatInterval(5*time.Millisecond, 10, 20))
+				if err != nil {
+					t.Fatalf("Expected no error, got: %v", err)
+				}
+				nodes[j] = node
+			}
+			if count := hub.Count(); count != 3 {
+				t.Fatalf("Expected 3 nodes in the hub, got %d", count)
+			}
+			expectedClusterState(t, nodes, 1, 2, 0)
+			for _, n := range nodes {
+				n.Close()
+			}
+			if count := hub.Count(); count != 0 {
+				t.Fatalf("Expected an empty hub, got %d nodes", count)
+			}
+		})
+	}
+}
+
+// singleHandler records the state changes and quorum heartbeats.
+type singleHandler struct {
+	*ChanHandler
+	quorums atomic.Int64
+}
+
+func (h *singleHandler) OnQuorumHeartbeat(term uint64, at time.Time) {
+	h.quorums.Add(1)
+}
+
+func TestSingleNode(t *testing.T) {
+	ci := ClusterInfo{Name: "single", Size: 1}
+	hub := NewMockHub()
+	// A misconfigured peer, which must not hear from us.
+	fake := fakeNode("fake")
+	fake.HeartBeats = make(chan *pb.Heartbeat, 32)
+	hub.Register(fake)
+
+	scCh := make(chan StateChange, 8)
+	hand := &singleHandler{ChanHandler: NewChanHandler(scCh, make(chan error, 8))}
+	_, _, log := genNodeArgs(t)
+	opts := []Option{WithHeartbeatInterval(5*time.Millisecond, 10, 20), WithCheckQuorum()}
+	node, err := New(ci, hand, hub.NewRpc(), log, opts...)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+
+	if sc := wait(t, scCh); sc.From != FOLLOWER || sc.To != CANDIDATE {
+		t.Fatalf("Expected a campaign, got %s -> %s", sc.From, sc.To)
+	}
+	if sc := wait(t, scCh); sc.From != CANDIDATE || sc.To != LEADER {
+		t.Fatalf("Expected to win the campaign, got %s -> %s", sc.From, sc.To)
+	}
+	testStateOfNode(t, node)
+	term := node.CurrentTerm()
+
+	// It stays LEADER, well past the election timeouts.
+	time.Sleep(300 * time.Millisecond)
+	if state := node.State(); state != LEADER {
+		t.Fatalf("Expected Node to stay Leader, got: %s", state)
+	}
+	if cur := node.CurrentTerm(); cur != term {
+		t.Fatalf("Expected term %d, got %d", term, cur)
+	}
+	select {
+	case sc := <-scCh:
+		t.Fatalf("Expected no state change, got %s -> %s", sc.From, sc.To)
+	default:
+	}
+	if hand.quorums.Load() == 0 {
+		t.Fatal("Expected quorum heartbeats")
+	}
+	if len(fake.VoteRequests) != 0 || len(fake.HeartBeats) != 0 {
+		t.Fatal("Expected no RPC from a single node")
+	}
+
+	// A restart preserves the term.
+	var buf bytes.Buffer
+	if err := node.ExportState(&buf); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	node.Close()
+	_, _, log = genNodeArgs(t)
+	if err := os.WriteFile(log, buf.Bytes(), 0660); err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+	node, err = New(ci, &dummyHandler{}, hub.NewRpc(), log, opts...)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+	if cur := node.CurrentTerm(); cur != term {
+		t.Fatalf("Expected term %d after a restart, got %d", term, cur)
+	}
+	if state := waitForState(node, LEADER); state != LEADER {
+		t.Fatalf("Expected Node to be Leader, got: %s", state)
+	}
+	if cur := node.CurrentTerm(); cur != term+1 {
+		t.Fatalf("Expected term %d, got %d", term+1, cur)
+	}
+}
+
+func TestIdGenerator(t *testing.T) {
+	ci := ClusterInfo{Name: "idgen", Size: 1}
+	hand, _, log := genNodeArgs(t)
+	calls := 0
+	gen := func() string {
+		calls++
+		return "host-1"
+	}
+	node, err := New(ci, hand, NewMockHub().NewRpc(), log, WithIdGenerator(gen))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+	if id := node.Id(); id != "host-1" {
+		t.Fatalf("Expected the generated id, got %q", id)
+	}
+
+	// Our vote for ourselves is persisted with it.
+	if state := waitForState(node, LEADER); state != LEADER {
+		t.Fatalf("Expected Node to be in Leader state, got: %s", state)
+	}
+	ps, err := LoadPersistentState(log)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if ps.VotedFor != "host-1" {
+		t.Fatalf("Expected a vote for host-1, got %q", ps.VotedFor)
+	}
+
+	// An explicit id wins.
+	hand, rpc, log := genNodeArgs(t)
+	other, err := New(ci, hand, rpc, log, WithIdGenerator(gen), WithId("explicit"))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	other.Close()
+	if calls != 1 || other.Id() != "explicit" {
+		t.Fatalf("Expected the explicit id without generating one, got %q", other.Id())
+	}
+
+	empty := func() string { return "" }
+	if _, err := New(ci, hand, rpc, log, WithIdGenerator(empty)); err != ErrInvalidOption {
+		t.Fatalf("Expected %v, got: %v", ErrInvalidOption, err)
+	}
+	if _, err := New(ci, hand, rpc, log, WithIdGenerator(nil)); err != ErrInvalidOption {
+		t.Fatalf("Expected %v, got: %v", ErrInvalidOption, err)
+	}
+}
+
+func TestMaxElectionAttempts(t *testing.T) {
+	ci := ClusterInfo{Name: "giveup", Size: 3}
+	_, _, log := genNodeArgs(t)
+	hub := NewMockHub()
+	rpc := hub.NewRpc()
+	scCh := make(chan StateChange, 16)
+	errCh := make(chan error, 16)
+	timeout := 20 * time.Millisecond
+	node, err := New(ci, NewChanHandler(scCh, errCh), rpc, log,
+		WithTimeoutStrategy(FixedTimeout(timeout)), WithMaxElectionAttempts(3))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+
+	// Without peers, no campaign can succeed.
+	giveUp := func() {
+		t.Helper()
+		for {
+			sc := wait(t, scCh)
+			if sc.To == GAVE_UP {
+				if sc.From != CANDIDATE || sc.Reason != REASON_GAVE_UP {
+					t.Fatalf("Expected Candidate to GaveUp for %s, got %+v", REASON_GAVE_UP, sc)
+				}
+				break
+			}
+		}
+		if err := errWait(t, errCh); !errors.Is(err, ErrGaveUp) {
+			t.Fatalf("Expected %v, got: %v", ErrGaveUp, err)
+		}
+	}
+	giveUp()
+	if term := node.CurrentTerm(); term != 3 {
+		t.Fatalf("Expected 3 campaigns, got term %d", term)
+	}
+
+	// No more campaigns.
+	time.Sleep(10 * timeout)
+	if state, term := node.State(), node.CurrentTerm(); state != GAVE_UP || term != 3 {
+		t.Fatalf("Expected to stay GaveUp at term 3, got %s at term %d", state, term)
+	}
+	if err := node.StepUp(context.Background()); err != ErrGaveUp {
+		t.Fatalf("Expected %v, got: %v", ErrGaveUp, err)
+	}
+
+	// Retrying campaigns as many times again.
+	if err := node.Retry(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if sc := wait(t, scCh); sc.From != GAVE_UP || sc.To != FOLLOWER || sc.Reason != REASON_RETRY {
+		t.Fatalf("Expected GaveUp to Follower for %s, got %+v", REASON_RETRY, sc)
+	}
+	giveUp()
+	if term := node.CurrentTerm(); term != 6 {
+		t.Fatalf("Expected 3 more campaigns, got term %d", term)
+	}
+
+	// Paused and resumed, it also campaigns as many times again.
+	if err := node.Pause(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if err := node.Resume(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	giveUp()
+	if term := node.CurrentTerm(); term != 9 {
+		t.Fatalf("Expected 3 more campaigns, got term %d", term)
+	}
+
+	// Having given up, it still votes.
+	fake := fakeNode("fake")
+	hub.Register(fake)
+	defer hub.Unregister(fake.id)
+	node.VoteRequests <- &pb.VoteRequest{Term: 10, Candidate: fake.id}
+	if vresp := <-fake.VoteResponses; !vresp.Granted || vresp.Term != 10 {
+		t.Fatalf("Expected the vote to be granted at term 10, got %+v", vresp)
+	}
+	if state := node.State(); state != GAVE_UP {
+		t.Fatalf("Expected to stay GaveUp, got %s", state)
+	}
+
+	// And follows a LEADER again.
+	node.HeartBeats <- &pb.Heartbeat{Term: 10, Leader: fake.id}
+	if sc := wait(t, scCh); sc.From != GAVE_UP || sc.To != FOLLOWER || sc.Reason != REASON_NEW_LEADER {
+		t.Fatalf("Expected GaveUp to Follower for %s, got %+v", REASON_NEW_LEADER, sc)
+	}
+	if leader := node.Leader(); leader != fake.id {
+		t.Fatalf("Expected to follow %s, got %q", fake.id, leader)
+	}
+}
+
+// staticRpc is an RPCDriver configured with a static list of members.
+type staticRpc struct {
+	*MockRpcDriver
+	members []string
+}
+
+func (s *staticRpc) ConfiguredMembers() int {
+	return len(s.members)
+}
+
+func TestMembershipCheck(t *testing.T) {
+	ci := ClusterInfo{Name: "static", Size: 5}
+	hand, _, log := genNodeArgs(t)
+
+	// Two members can never reach a quorum of five.
+	rpc := &staticRpc{MockRpcDriver: NewMockHub().NewRpc(), members: []string{"a:4222", "b:4222"}}
+	if _, err := New(ci, hand, rpc, log, WithMembershipCheck()); !errors.Is(err, ErrQuorumUnreachable) {
+		t.Fatalf("Expected %v, got: %v", ErrQuorumUnreachable, err)
+	}
+	// Unless not checked.
+	node, err := New(ci, hand, rpc, log)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	node.Close()
+
+	// Three members can.
+	_, _, log = genNodeArgs(t)
+	rpc = &staticRpc{MockRpcDriver: NewMockHub().NewRpc(), members: []string{"a:4222", "b:4222", "c:4222"}}
+	node, err = New(ci, hand, rpc, log, WithMembershipCheck())
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	node.Close()
+
+	// The driver must know its members.
+	if _, err := New(ci, hand, NewMockHub().NewRpc(), log, WithMembershipCheck()); err != ErrMembershipReq {
+		t.Fatalf("Expected %v, got: %v", ErrMembershipReq, err)
+	}
+}
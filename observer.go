@@ -0,0 +1,94 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+// DemoteToObserver temporarily excludes the node from the quorum, e.g.
+// for maintenance on the LEADER's host. Unlike Pause, an observer keeps
+// following the LEADER and tracking the term, but it never campaigns
+// and never grants its vote. A LEADER steps down at its next heartbeat,
+// and a CANDIDATE once its campaign is over. Use PromoteFromObserver to make it a full member again.
+//
+// An observer flags its answers to vote requests and heartbeats, the
+// latter with an RPCDriver implementing HeartbeatResponder. The peers
+// that hear from it leave it out of the quorum of that term, e.g. a
+// cluster of 4 with one observer needs 2 votes instead of 3. To keep
+// that safe, a node never votes in a term it answered in as observer,
+// even once promoted.
+func (n *Node) DemoteToObserver() error {
+	return n.setObserver(true)
+}
+
+// PromoteFromObserver makes a node demoted with DemoteToObserver a full
+// member of the quorum again.
+func (n *Node) PromoteFromObserver() error {
+	return n.setObserver(false)
+}
+
+// IsObserver returns whether the node was demoted to observer.
+func (n *Node) IsObserver() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.observer
+}
+
+func (n *Node) setObserver(observer bool) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.state == CLOSED || n.isClosing() {
+		return ErrNodeClosed
+	}
+	n.observer = observer
+	return nil
+}
+
+// observeTerm records that we answered in term as an observer.
+func (n *Node) observeTerm(term uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.observedTerm = max(n.observedTerm, term)
+}
+
+// observed records whether peer answered in term as an observer, and
+// forgets the peers that did so before our term.
+func (n *Node) observed(peer string, term uint64, observer bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for id, t := range n.observers {
+		if t < n.term {
+			delete(n.observers, id)
+		}
+	}
+	if !observer {
+		delete(n.observers, peer)
+		return
+	}
+	if n.observers == nil {
+		n.observers = make(map[string]uint64)
+	}
+	n.observers[peer] = term
+}
+
+// votingSize returns the cluster size without the peers that answered
+// as observers in our term, since they do not vote in it.
+func (n *Node) votingSize() int {
+	size := n.ClusterSize()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, term := range n.observers {
+		if term == n.term {
+			size--
+		}
+	}
+	return max(size, 1)
+}
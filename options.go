@@ -0,0 +1,852 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+import (
+	"slices"
+	"time"
+
+	"github.com/nats-io/graft/pb"
+)
+
+// Option is used to configure optional behavior of a Graft node.
+// Options are passed to New and applied before the node is started.
+type Option func(*options) error
+
+// options holds the optional settings of a Graft node.
+type options struct {
+	// Step down as LEADER when a quorum of the cluster can no
+	// longer be reached.
+	checkQuorum bool
+
+	// How long a LEADER tolerates not reaching a quorum before
+	// stepping down.
+	quorumGrace time.Duration
+
+	// Selects the election timeouts.
+	timeouts TimeoutStrategy
+
+	// Interval of the LEADER's heartbeats.
+	heartbeat time.Duration
+
+	// Discard the state of a log written by another cluster.
+	resetOnClusterMismatch bool
+
+	// Discard the state of a corrupt log, after copying it to the
+	// quarantine directory, or the log's directory if empty.
+	resetOnCorrupt bool
+	quarantineDir  string
+
+	// Append every persisted state to this file.
+	historyPath string
+
+	// Records kept when compacting the history, 0 to never compact.
+	historyRetention int
+
+	// Receives the internal measurements.
+	metrics Metrics
+
+	// Time source for the timers.
+	clock Clock
+
+	// Bound of the vote requests in flight per campaign, 0 for none.
+	maxInflightVotes int
+
+	// Stable identity of the node, generated if empty.
+	id string
+
+	// Generates the identity of the node when none is given.
+	idGenerator func() string
+
+	// Assume a missing state was lost. Requires id.
+	lostStateGuard bool
+
+	// Distinct peers that must answer a campaign to win it.
+	minElectionPeers int
+
+	// Vote without ever campaigning.
+	witness bool
+
+	// Interval of the LeaderTicker calls, 0 for none.
+	leaderTick time.Duration
+
+	// Order the election timeouts by affinity for the cluster.
+	leaderAffinity bool
+
+	// Leadership is set by AssumeLeadership, never by elections.
+	externalLeadership bool
+
+	// Live membership the quorum is computed from.
+	peers PeerProvider
+
+	// Band of the random delay before a FOLLOWER campaigns.
+	campaignJitter time.Duration
+
+	// Observes or transforms the messages.
+	interceptor RpcInterceptor
+
+	// Consecutive heartbeat intervals without a heartbeat before a
+	// FOLLOWER campaigns, 0 for none.
+	maxMissedHeartbeats int
+
+	// Whether a CANDIDATE defers to a competitor, nil for never.
+	tiebreak func(a, b string) bool
+
+	// Receives the vote requests sent, if not full.
+	voteRequests chan<- *pb.VoteRequest
+
+	// Encoded size budget of the heartbeats, 0 for none.
+	maxHeartbeatSize int
+
+	// Leadership changes within the window that make the leadership
+	// churn, 0 for no notification.
+	churnThreshold int
+	churnWindow    time.Duration
+
+	// Additional requirement of a quorum, nil for none.
+	quorumPredicate QuorumPredicate
+
+	// Only grant votes to known candidates: those listed, or else the
+	// members of the PeerProvider.
+	knownCandidatesOnly bool
+	knownCandidates     []string
+
+	// Consecutive failed campaigns diagnosed, 0 for none.
+	stallCampaigns int
+
+	// Wait for StartServing to initialize the RPCDriver.
+	deferredServing bool
+
+	// How long the state of a peer we have not heard from is kept.
+	peerRetention time.Duration
+
+	// Runs the asynchronous calls to the handler.
+	scheduler Scheduler
+
+	// Protocol version we advertise, and the oldest we are compatible
+	// with.
+	protocolVersion    uint32
+	minProtocolVersion uint32
+	refuseIncompatible bool
+
+	// How long a LEADER tries to hand off its leadership on a signal.
+	signalHandoff time.Duration
+
+	// Recreate the directory of the log if it is removed.
+	recreateLogDir bool
+
+	// Keep the state in memory only.
+	ephemeral bool
+
+	// Report the vote requests received as LEADER.
+	reportLeaderVotes bool
+
+	// Mirror the leadership into an external lease.
+	leaseInterval time.Duration
+	leaseRenew    func(term uint64) error
+	leaseRelease  func(term uint64) error
+
+	// Campaigns without a LEADER before giving up, 0 for no limit.
+	maxElectionAttempts int
+
+	// Write the state in the compact format.
+	compactState bool
+
+	// Retries of a write failing with a transient error, and the
+	// delay before the first one.
+	writeRetries int
+	writeBackoff time.Duration
+
+	// Treat an existing empty log as corrupt.
+	strictEmptyLog bool
+
+	// Check at New that the configured members can reach a quorum.
+	membershipCheck bool
+}
+
+// defaultOptions returns the options used when none are given.
+func defaultOptions() options {
+	return options{
+		timeouts:    UniformTimeout{Min: MIN_ELECTION_TIMEOUT, Max: MAX_ELECTION_TIMEOUT},
+		heartbeat:   HEARTBEAT_INTERVAL,
+		metrics:     nopMetrics{},
+		clock:       realClock{},
+		idGenerator: genUUID,
+		scheduler:   goScheduler{},
+
+		protocolVersion: PROTOCOL_VERSION,
+	}
+}
+
+// WithCheckQuorum makes a LEADER step down when it has not received
+// heartbeat responses from a quorum of the cluster. The RPCDriver must
+// implement HeartbeatResponder.
+func WithCheckQuorum() Option {
+	return func(o *options) error {
+		o.checkQuorum = true
+		return nil
+	}
+}
+
+// WithQuorumLossGracePeriod sets how long a LEADER using CheckQuorum
+// tolerates not reaching a quorum before stepping down. The default of
+// 0 steps down after the first heartbeat round that misses a quorum.
+// Larger values trade failover speed for stability on jittery networks.
+func WithQuorumLossGracePeriod(grace time.Duration) Option {
+	return func(o *options) error {
+		if grace < 0 {
+			return ErrInvalidOption
+		}
+		o.quorumGrace = grace
+		return nil
+	}
+}
+
+// WithTimeoutStrategy sets the strategy used to select election
+// timeouts. The default is a UniformTimeout between MIN_ELECTION_TIMEOUT
+// and MAX_ELECTION_TIMEOUT.
+func WithTimeoutStrategy(ts TimeoutStrategy) Option {
+	return func(o *options) error {
+		if ts == nil {
+			return ErrInvalidOption
+		}
+		o.timeouts = ts
+		return nil
+	}
+}
+
+// WithHeartbeatInterval sets the interval of the LEADER's heartbeats and
+// derives the election timeouts from it, picked uniformly at random
+// between minMult and maxMult times the interval, e.g. 10 and 20. This
+// keeps the timeouts safely larger than the interval: minMult must be at
+// least MIN_ELECTION_MULTIPLIER and maxMult at least minMult. It replaces
+// the TimeoutStrategy, so it should not be combined with
+// WithTimeoutStrategy. The default is HEARTBEAT_INTERVAL with timeouts
+// between MIN_ELECTION_TIMEOUT and MAX_ELECTION_TIMEOUT.
+func WithHeartbeatInterval(interval time.Duration, minMult, maxMult float64) Option {
+	return func(o *options) error {
+		if interval <= 0 || minMult < MIN_ELECTION_MULTIPLIER || maxMult < minMult {
+			return ErrInvalidOption
+		}
+		o.heartbeat = interval
+		o.timeouts = UniformTimeout{
+			Min: time.Duration(float64(interval) * minMult),
+			Max: time.Duration(float64(interval) * maxMult),
+		}
+		return nil
+	}
+}
+
+// WithResetOnClusterMismatch makes New discard the term and vote found
+// in a log file written under a different cluster name, instead of
+// failing with ErrClusterMismatch. This is useful when a log path is
+// knowingly reused for a new cluster.
+func WithResetOnClusterMismatch() Option {
+	return func(o *options) error {
+		o.resetOnClusterMismatch = true
+		return nil
+	}
+}
+
+// WithResetOnCorruptLog makes New start without state, instead of failing
+// with ErrLogCorrupt, when the log file is corrupt. The corrupt file is
+// first copied to the quarantine directory, see WithQuarantineDir, and
+// then emptied. The node may have voted in the term it lost, so this
+// should be combined with WithLostStateGuard.
+func WithResetOnCorruptLog() Option {
+	return func(o *options) error {
+		o.resetOnCorrupt = true
+		return nil
+	}
+}
+
+// WithQuarantineDir sets the directory where WithResetOnCorruptLog copies
+// corrupt log files, e.g. to collect them centrally. The default is the
+// directory of the log file. Copies are named after the log file, with a
+// ".corrupt." suffix and a timestamp.
+func WithQuarantineDir(dir string) Option {
+	return func(o *options) error {
+		if dir == "" {
+			return ErrInvalidOption
+		}
+		o.quarantineDir = dir
+		return nil
+	}
+}
+
+// WithStateHistory appends every term and vote persisted by the node,
+// with a timestamp, to the file at path. The file is never truncated
+// by the node and can be read with ReadStateHistory.
+func WithStateHistory(path string) Option {
+	return func(o *options) error {
+		if path == "" {
+			return ErrInvalidOption
+		}
+		o.historyPath = path
+		return nil
+	}
+}
+
+// WithStateHistoryRetention bounds the state history set with
+// WithStateHistory. Once as many records as retained were appended, the
+// file is compacted to its last records, see CompactStateHistory, so it
+// holds at most twice the retained records.
+func WithStateHistoryRetention(records int) Option {
+	return func(o *options) error {
+		if records <= 0 {
+			return ErrInvalidOption
+		}
+		o.historyRetention = records
+		return nil
+	}
+}
+
+// WithMetrics sets the hook receiving the metrics of the node.
+func WithMetrics(m Metrics) Option {
+	return func(o *options) error {
+		if m == nil {
+			return ErrInvalidOption
+		}
+		o.metrics = m
+		return nil
+	}
+}
+
+// WithClock sets the time source of the node's election timer and
+// heartbeats. It is meant for tests and simulations using a FakeClock.
+func WithClock(c Clock) Option {
+	return func(o *options) error {
+		if c == nil {
+			return ErrInvalidOption
+		}
+		o.clock = c
+		return nil
+	}
+}
+
+// WithMaxInflightVoteRequests bounds the number of vote requests of a
+// campaign awaiting a response. Requests are sent in waves as responses
+// come back, which smooths the load on the transport of large clusters.
+// The RPCDriver must implement PeerVoteRequester.
+func WithMaxInflightVoteRequests(max int) Option {
+	return func(o *options) error {
+		if max <= 0 {
+			return ErrInvalidOption
+		}
+		o.maxInflightVotes = max
+		return nil
+	}
+}
+
+// WithId sets a stable identity for the node instead of a generated one.
+func WithId(id string) Option {
+	return func(o *options) error {
+		if id == "" {
+			return ErrInvalidOption
+		}
+		o.id = id
+		return nil
+	}
+}
+
+// WithIdGenerator sets the function generating the identity of the node
+// when none is given with WithId, e.g. to embed the host name for
+// correlation. The default generates a random hexadecimal id. New fails
+// with ErrInvalidOption if gen returns an empty id.
+func WithIdGenerator(gen func() string) Option {
+	return func(o *options) error {
+		if gen == nil {
+			return ErrInvalidOption
+		}
+		o.idGenerator = gen
+		return nil
+	}
+}
+
+// WithLostStateGuard protects vote safety when a node with a known
+// identity, set with WithId, rejoins after losing its state, e.g. to a
+// wiped disk. Such a node may have voted in terms it no longer remembers,
+// so if it starts without state it neither votes nor campaigns until it
+// hears a heartbeat, and then refuses votes up to the term it heard. Do
+// not use it when bootstrapping a cluster, nor when every node lost its
+// state, since no LEADER could then be elected.
+func WithLostStateGuard() Option {
+	return func(o *options) error {
+		o.lostStateGuard = true
+		return nil
+	}
+}
+
+// WithMinElectionPeers requires a candidate to get answers to its vote
+// requests from at least peers distinct members, besides winning the
+// quorum, before it becomes LEADER. It protects against a node that can
+// only reach itself winning, e.g. with a misconfigured cluster size of 1,
+// at the cost of availability. Only vote responses carrying the Voter
+// are counted. The default of 0 disables it.
+func WithMinElectionPeers(peers int) Option {
+	return func(o *options) error {
+		if peers < 0 {
+			return ErrInvalidOption
+		}
+		o.minElectionPeers = peers
+		return nil
+	}
+}
+
+// WithWitness makes the node a witness: it counts toward the quorum and
+// grants votes like any member, but never campaigns and so never becomes
+// LEADER. A cheap witness can break ties between two datacenters.
+func WithWitness() Option {
+	return func(o *options) error {
+		o.witness = true
+		return nil
+	}
+}
+
+// WithLeaderTick calls the handler's OnLeaderTick every interval while
+// the node is LEADER, e.g. for leader-only housekeeping. The Handler must
+// implement LeaderTicker.
+func WithLeaderTick(interval time.Duration) Option {
+	return func(o *options) error {
+		if interval <= 0 {
+			return ErrInvalidOption
+		}
+		o.leaderTick = interval
+		return nil
+	}
+}
+
+// WithLeaderAffinity spreads the leadership of many clusters evenly across
+// the nodes they share. Each node derives from a hash of its id and of
+// the cluster name a deterministic affinity for leading that cluster, and
+// its election timeouts are picked accordingly between the bounds of the
+// UniformTimeout in use, so the member with the highest affinity usually
+// campaigns first. The ids must be stable, see WithId.
+func WithLeaderAffinity() Option {
+	return func(o *options) error {
+		o.leaderAffinity = true
+		return nil
+	}
+}
+
+// WithExternalLeadership disables elections, for leadership chosen by an
+// external coordinator, e.g. a lease. The node never campaigns and only
+// becomes LEADER through AssumeLeadership, until RelinquishLeadership.
+// It still persists its term and vote, and sends heartbeats as LEADER.
+func WithExternalLeadership() Option {
+	return func(o *options) error {
+		o.externalLeadership = true
+		return nil
+	}
+}
+
+// WithPeerProvider computes the quorum from the live membership supplied
+// by p, instead of the static ClusterInfo.Size, which is only used while
+// the membership is empty.
+func WithPeerProvider(p PeerProvider) Option {
+	return func(o *options) error {
+		if p == nil {
+			return ErrInvalidOption
+		}
+		o.peers = p
+		return nil
+	}
+}
+
+// WithCampaignJitter makes a FOLLOWER whose election timeout expired wait
+// for an additional random delay, up to band, before it campaigns. It
+// desynchronizes followers that lost the same LEADER at once, which
+// would otherwise likely split their votes, e.g. with a FixedTimeout. A
+// heartbeat received meanwhile cancels the campaign.
+// The band must be at most MAX_CAMPAIGN_JITTER_MULTIPLIER heartbeat
+// intervals, so failovers are not delayed much further.
+func WithCampaignJitter(band time.Duration) Option {
+	return func(o *options) error {
+		if band <= 0 {
+			return ErrInvalidOption
+		}
+		o.campaignJitter = band
+		return nil
+	}
+}
+
+// WithRpcInterceptor passes every message the node sends or receives
+// through i, see RpcInterceptor.
+func WithRpcInterceptor(i RpcInterceptor) Option {
+	return func(o *options) error {
+		if i == nil {
+			return ErrInvalidOption
+		}
+		o.interceptor = i
+		return nil
+	}
+}
+
+// WithMaxMissedHeartbeats makes a FOLLOWER campaign once misses
+// consecutive heartbeat intervals went by without a heartbeat from the
+// LEADER, instead of when its election timeout expires. On noisy
+// networks, sporadic gaps in the heartbeats are tolerated whatever the
+// election timeout, at the cost of a slower failover if misses is large.
+// A heartbeat from the LEADER, or granting our vote, during an interval
+// resets the count. Since intervals are not aligned with the LEADER's
+// heartbeats, misses should be at least 2.
+func WithMaxMissedHeartbeats(misses int) Option {
+	return func(o *options) error {
+		if misses <= 0 {
+			return ErrInvalidOption
+		}
+		o.maxMissedHeartbeats = misses
+		return nil
+	}
+}
+
+// WithCandidateTiebreak makes a CANDIDATE defer to a competing CANDIDATE
+// of the same term whose id it prefers, i.e. when less(theirs, ours), e.g.
+// cmp.Less[string] for the lexicographically smaller id. The deferring
+// node steps down and holds off its next campaign for two election
+// timeouts, so the preferred one wins its next campaign instead of both
+// splitting the votes again. The node campaigns again if the preferred
+// one does not, e.g. because it is down. It works best when all members
+// use the same less function.
+func WithCandidateTiebreak(less func(a, b string) bool) Option {
+	return func(o *options) error {
+		if less == nil {
+			return ErrInvalidOption
+		}
+		o.tiebreak = less
+		return nil
+	}
+}
+
+// WithVoteRequestObserver sends to ch each VoteRequest the node emits as
+// CANDIDATE, as passed to the RPCDriver, e.g. to verify the content on
+// the wire. A request is dropped if ch is full, so the node never blocks
+// on it. The requests must not be modified.
+func WithVoteRequestObserver(ch chan<- *pb.VoteRequest) Option {
+	return func(o *options) error {
+		if ch == nil {
+			return ErrInvalidOption
+		}
+		o.voteRequests = ch
+		return nil
+	}
+}
+
+// WithMaxHeartbeatSize bounds the encoded size of the heartbeats, so a
+// transport limiting its message size does not reject them. A heartbeat
+// that would exceed size is sent without the advertised metadata, see
+// SetAdvertised, and the handler is warned with ErrHeartbeatTooLarge.
+func WithMaxHeartbeatSize(size int) Option {
+	return func(o *options) error {
+		if size <= 0 {
+			return ErrInvalidOption
+		}
+		o.maxHeartbeatSize = size
+		return nil
+	}
+}
+
+// WithLeadershipChurnThreshold notifies the handler when the node learned
+// of more than changes new LEADERs within window, e.g. to alert on a
+// flapping cluster. The Handler must implement LeadershipChurnHandler.
+func WithLeadershipChurnThreshold(changes int, window time.Duration) Option {
+	return func(o *options) error {
+		if changes <= 0 || window <= 0 {
+			return ErrInvalidOption
+		}
+		o.churnThreshold = changes
+		o.churnWindow = window
+		return nil
+	}
+}
+
+// A QuorumPredicate is an additional requirement of a quorum, on top of
+// the majority of the cluster. It is given the ids of the members in
+// favor, ourselves included, in no particular order.
+type QuorumPredicate func(granted []string) bool
+
+// WithQuorumPredicate requires a quorum to also satisfy pred, e.g. to
+// include at least a member of each availability zone. It applies to
+// the votes of a CANDIDATE, and to the heartbeat responses of a LEADER
+// using CheckQuorum or a QuorumHeartbeatHandler. Peers that do not
+// report their id in their VoteResponse are counted toward the majority
+// but are not given to pred.
+//
+// A stricter quorum costs liveness: no LEADER is elected, and one using
+// CheckQuorum steps down, while the live members can not satisfy pred,
+// even if they are a majority. pred should only get easier to satisfy
+// as members are added, and all members should use the same one.
+func WithQuorumPredicate(pred QuorumPredicate) Option {
+	return func(o *options) error {
+		if pred == nil {
+			return ErrInvalidOption
+		}
+		o.quorumPredicate = pred
+		return nil
+	}
+}
+
+// WithKnownCandidatesOnly makes the node only grant its vote to known
+// candidates, e.g. when the transport is shared with other clusters or
+// rogue nodes. The known candidates are the given ids, or else the
+// current members of the PeerProvider, which is then required. A vote
+// request from an unknown candidate is denied and ignored, its term is
+// not adopted, and the handler is notified with ErrUnknownCandidate.
+func WithKnownCandidatesOnly(ids ...string) Option {
+	return func(o *options) error {
+		if slices.Contains(ids, "") {
+			return ErrInvalidOption
+		}
+		o.knownCandidatesOnly = true
+		o.knownCandidates = slices.Clone(ids)
+		return nil
+	}
+}
+
+// WithElectionDiagnostics reports an ElectionStallError to the handler
+// when campaigns consecutive campaigns of the node failed to elect a
+// LEADER, e.g. with persistent split votes or without a quorum. It
+// summarizes the votes and answers of the campaigns, and the likely
+// cause. It is reported once per stall, until a LEADER is elected.
+func WithElectionDiagnostics(campaigns int) Option {
+	return func(o *options) error {
+		if campaigns <= 0 {
+			return ErrInvalidOption
+		}
+		o.stallCampaigns = campaigns
+		return nil
+	}
+}
+
+// WithDeferredServing makes New load the persisted state without
+// initializing the RPCDriver, so the application can finish its own
+// initialization before peers reach the node. It does not answer RPCs
+// nor campaign until StartServing is called. Meanwhile, the actions of
+// the node, e.g. Pause, wait for it.
+func WithDeferredServing() Option {
+	return func(o *options) error {
+		o.deferredServing = true
+		return nil
+	}
+}
+
+// WithPeerStateRetention prunes the state tracked for a peer, i.e. its
+// heartbeat latency and last error, when we have not heard from it
+// within window. Regardless, the state of at most 1024 peers is kept,
+// the least recently seen being pruned first, so that transient peers
+// seen on a shared transport do not grow it without bounds.
+func WithPeerStateRetention(window time.Duration) Option {
+	return func(o *options) error {
+		if window <= 0 {
+			return ErrInvalidOption
+		}
+		o.peerRetention = window
+		return nil
+	}
+}
+
+// WithScheduler sets the Scheduler running the handler notifications,
+// e.g. a WorkerPool shared by many nodes to bound their go routines.
+// The default runs each call on its own go routine. This is a
+// notification scheduler only: the loop, PeerProvider watcher and lease
+// mirror of a node still run on their own go routines, see Scheduler.
+func WithScheduler(s Scheduler) Option {
+	return func(o *options) error {
+		if s == nil {
+			return ErrInvalidOption
+		}
+		o.scheduler = s
+		return nil
+	}
+}
+
+// WithProtocolVersion sets the protocol version the node advertises in
+// its heartbeats and vote requests, PROTOCOL_VERSION by default, and the
+// oldest version of its peers it is compatible with, 0 by default. The
+// handler is notified with ErrIncompatiblePeer, and the
+// METRIC_INCOMPATIBLE_PEERS counter incremented, when a peer advertises
+// an older version. See WithIncompatibleVoteRefusal.
+func WithProtocolVersion(version, minVersion uint32) Option {
+	return func(o *options) error {
+		if version < minVersion {
+			return ErrInvalidOption
+		}
+		o.protocolVersion = version
+		o.minProtocolVersion = minVersion
+		return nil
+	}
+}
+
+// WithIncompatibleVoteRefusal denies votes to the candidates advertising
+// a protocol version older than the minimum set with WithProtocolVersion,
+// so that a mixed-version cluster keeps a LEADER it is compatible with.
+// Their term is not adopted.
+func WithIncompatibleVoteRefusal() Option {
+	return func(o *options) error {
+		o.refuseIncompatible = true
+		return nil
+	}
+}
+
+// WithSignalHandoff makes a LEADER closing on a signal handled by
+// HandleSignals first hand its leadership off with TransferLeadership to
+// the peer that acknowledged its heartbeats last, which requires an
+// RPCDriver implementing HeartbeatResponder. Only if that fails within
+// deadline does it pause instead, leaving the peers to elect a
+// successor after their election timeout. A successful handoff lets a
+// healthy successor take over without waiting for that timeout.
+func WithSignalHandoff(deadline time.Duration) Option {
+	return func(o *options) error {
+		if deadline <= 0 {
+			return ErrInvalidOption
+		}
+		o.signalHandoff = deadline
+		return nil
+	}
+}
+
+// WithLogDirRecreation recreates the directory of the log file when it
+// was removed while the node runs, e.g. by an operator, and writes the
+// state again, warning the handler with ErrLogDirRemoved. By default, and
+// if the directory can not be recreated, the write fails with an error
+// wrapping ErrLogDirRemoved, and the node neither votes nor campaigns
+// until its state can be written, as on any failed write.
+func WithLogDirRecreation() Option {
+	return func(o *options) error {
+		o.recreateLogDir = true
+		return nil
+	}
+}
+
+// WithoutPersistence keeps the term and vote of the node in memory only,
+// for short-lived workers electing a LEADER among themselves: nothing is
+// written to disk, and the log path given to New is ignored and may be
+// empty. Beware that RAFT then loses a safety property: a node restarted
+// within a term forgot its vote and may vote again, so two LEADERs may
+// be elected in that term. ExportState returns ErrLogNoState.
+func WithoutPersistence() Option {
+	return func(o *options) error {
+		o.ephemeral = true
+		return nil
+	}
+}
+
+// WithLeaderVoteReports reports every vote request received while LEADER
+// to the handler, with an error wrapping ErrVoteRequestAsLeader naming
+// the candidate and its term, e.g. to detect disruptive peers. A LEADER
+// always denies the requests up to its term, and steps down on a newer
+// term, granting the vote as a FOLLOWER would.
+func WithLeaderVoteReports() Option {
+	return func(o *options) error {
+		o.reportLeaderVotes = true
+		return nil
+	}
+}
+
+// WithLeaseMirror mirrors the leadership of the node into an external
+// lease, e.g. a Kubernetes Lease, to migrate incrementally between graft
+// and lease based leader election. The caller supplies the functions
+// updating the lease, so graft does not depend on its client: renew is
+// called with our term when we become LEADER and every interval while we
+// stay LEADER, and release once we are not LEADER anymore, at the latest
+// when the node is closed. They are called in order on their own
+// goroutine, which never delays the election; calls piling up while one
+// is slow are coalesced into the latest leadership. Their errors are
+// reported to the handler wrapping ErrLeaseMirror, and do not affect the
+// leadership, which graft remains the authority for.
+func WithLeaseMirror(interval time.Duration, renew, release func(term uint64) error) Option {
+	return func(o *options) error {
+		if interval <= 0 || renew == nil || release == nil {
+			return ErrInvalidOption
+		}
+		o.leaseInterval = interval
+		o.leaseRenew = renew
+		o.leaseRelease = release
+		return nil
+	}
+}
+
+// WithMaxElectionAttempts makes the node give up after n campaigns
+// without hearing from or becoming a LEADER, e.g. in batch jobs where a
+// cluster that can not form a quorum must not campaign forever. The node
+// then switches to the GAVE_UP state with REASON_GAVE_UP, reports an
+// error wrapping ErrGaveUp, and no longer campaigns until Retry or
+// Resume is called. It still votes, and becomes a FOLLOWER again once
+// it hears from a LEADER.
+func WithMaxElectionAttempts(n int) Option {
+	return func(o *options) error {
+		if n <= 0 {
+			return ErrInvalidOption
+		}
+		o.maxElectionAttempts = n
+		return nil
+	}
+}
+
+// WithCompactState writes the state in a compact format, with short field
+// names and without the empty fields, e.g. to embed the log file in a
+// larger document. It is written with version STATE_VERSION_COMPACT, and
+// any version is read, so a node can switch formats without migration.
+// Nodes of releases predating the compact format can not read it.
+func WithCompactState() Option {
+	return func(o *options) error {
+		o.compactState = true
+		return nil
+	}
+}
+
+// WithWriteRetry retries a write of the state failing with a transient
+// error, e.g. EINTR or EAGAIN, up to retries times, waiting backoff
+// before the first retry and doubling it for each next one. Only once
+// the retries are exhausted does the write fail, and the node stops
+// voting and campaigning until it can write again. Retries block the
+// node's loop, so their total delay is capped at half the shortest
+// election timeout, and they stop when the node is closed. By default,
+// writes are not retried.
+func WithWriteRetry(retries int, backoff time.Duration) Option {
+	return func(o *options) error {
+		if retries <= 0 || backoff < 0 {
+			return ErrInvalidOption
+		}
+		o.writeRetries = retries
+		o.writeBackoff = backoff
+		return nil
+	}
+}
+
+// WithStrictEmptyLog makes New treat a log file that exists but is empty
+// as corrupt, e.g. truncated by a faulty disk or an operator, instead of
+// starting without state as for a log file it creates. The error wraps
+// both ErrLogCorrupt and ErrLogEmpty, and is handled as any corrupt log,
+// see WithResetOnCorruptLog. The node then writes its state as soon as it
+// starts without one, so its own log is never left empty.
+func WithStrictEmptyLog() Option {
+	return func(o *options) error {
+		o.strictEmptyLog = true
+		return nil
+	}
+}
+
+// WithMembershipCheck makes New fail fast with ErrQuorumUnreachable when
+// the members configured in the RPCDriver, e.g. a static list of
+// addresses, are fewer than a quorum of ClusterInfo.Size, so the cluster
+// could never elect a LEADER. The RPCDriver must implement
+// MembershipReporter. It is off by default, since not all drivers know
+// their members.
+func WithMembershipCheck() Option {
+	return func(o *options) error {
+		o.membershipCheck = true
+		return nil
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+// A PeerProvider supplies the live membership of a cluster whose members
+// come and go, e.g. from a discovery service. When set with
+// WithPeerProvider, the quorum is computed from the membership instead
+// of the static ClusterInfo.Size. Members must agree on the membership,
+// since nodes with different views can elect different LEADERS.
+type PeerProvider interface {
+	// Used to list the ids of the current members, including this node
+	Peers() []string
+	// Used to signal that the membership changed
+	Changes() <-chan struct{}
+}
+
+// ClusterSize returns the number of members the quorum is computed from:
+// the live membership of the PeerProvider if any, else ClusterInfo.Size.
+func (n *Node) ClusterSize() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.size
+}
+
+// updateSize sets the cluster size from the PeerProvider. An empty
+// membership falls back to ClusterInfo.Size.
+func (n *Node) updateSize() {
+	size := len(n.opts.peers.Peers())
+	if size == 0 {
+		size = n.info.Size
+	}
+	n.mu.Lock()
+	n.size = size
+	n.mu.Unlock()
+}
+
+// watchPeers keeps the cluster size up to date with the PeerProvider
+// until the node is closed.
+func (n *Node) watchPeers() {
+	changes := n.opts.peers.Changes()
+	for {
+		select {
+		case <-n.peersDone:
+			return
+		case <-changes:
+			n.updateSize()
+		}
+	}
+}
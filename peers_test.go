@@ -0,0 +1,161 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/graft/pb"
+)
+
+// staticPeers is a PeerProvider changed by the tests.
+type staticPeers struct {
+	mu      sync.Mutex
+	peers   []string
+	changes chan struct{}
+}
+
+func (p *staticPeers) Peers() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Clone(p.peers)
+}
+
+func (p *staticPeers) Changes() <-chan struct{} {
+	return p.changes
+}
+
+func (p *staticPeers) set(peers ...string) {
+	p.mu.Lock()
+	p.peers = peers
+	p.mu.Unlock()
+	p.changes <- struct{}{}
+}
+
+func waitForClusterSize(node *Node, size int) int {
+	timeout := time.Now().Add(time.Second)
+	for time.Now().Before(timeout) && node.ClusterSize() != size {
+		time.Sleep(5 * time.Millisecond)
+	}
+	return node.ClusterSize()
+}
+
+func TestPeerProvider(t *testing.T) {
+	ci := ClusterInfo{Name: "dynamic", Size: 7}
+	hand, rpc, log := genNodeArgs(t)
+	if _, err := New(ci, hand, rpc, log, WithPeerProvider(nil)); err != ErrInvalidOption {
+		t.Fatalf("Expected %v, got: %v", ErrInvalidOption, err)
+	}
+	peers := &staticPeers{
+		peers:   []string{"self", "fake1", "fake2", "fake3", "fake4"},
+		changes: make(chan struct{}),
+	}
+	node, err := New(ci, hand, rpc, log, WithPeerProvider(peers))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+	if size := node.ClusterSize(); size != 5 {
+		t.Fatalf("Expected a cluster size of 5, got %d", size)
+	}
+
+	fakes := []*Node{fakeNode("fake1"), fakeNode("fake2")}
+	for _, fake := range fakes {
+		mockRegisterPeer(fake)
+		defer mockUnregisterPeer(fake.id)
+	}
+
+	node.mu.Lock()
+	node.electTimer.Reset(time.Millisecond)
+	node.mu.Unlock()
+
+	var vreq *pb.VoteRequest
+	for _, fake := range fakes {
+		vreq = <-fake.VoteRequests
+	}
+
+	// 2 votes out of 5 is not a quorum.
+	node.VoteResponses <- &pb.VoteResponse{Term: vreq.Term, Granted: true, Voter: "fake1"}
+	time.Sleep(50 * time.Millisecond)
+	if state := node.State(); state != CANDIDATE {
+		t.Fatalf("Expected Node to be in Candidate state, got: %s", state)
+	}
+
+	// The membership shrinks, 2 votes out of 3 is.
+	peers.set("self", "fake1", "fake2")
+	if size := waitForClusterSize(node, 3); size != 3 {
+		t.Fatalf("Expected a cluster size of 3, got %d", size)
+	}
+	node.VoteResponses <- &pb.VoteResponse{Term: vreq.Term, Granted: false, Voter: "fake2"}
+	if state := waitForState(node, LEADER); state != LEADER {
+		t.Fatalf("Expected Node to be in Leader state, got: %s", state)
+	}
+
+	// An empty membership falls back to the static size.
+	peers.set()
+	if size := waitForClusterSize(node, 7); size != 7 {
+		t.Fatalf("Expected a cluster size of 7, got %d", size)
+	}
+}
+
+func TestKnownCandidatesOnly(t *testing.T) {
+	ci := ClusterInfo{Name: "known", Size: 3}
+	_, rpc, log := genNodeArgs(t)
+	errCh := make(chan error, 1)
+	hand := NewChanHandler(make(chan StateChange, 8), errCh)
+	if _, err := New(ci, hand, rpc, log, WithKnownCandidatesOnly()); err != ErrPeerProviderReq {
+		t.Fatalf("Expected %v, got: %v", ErrPeerProviderReq, err)
+	}
+	peers := &staticPeers{
+		peers:   []string{"self", "known"},
+		changes: make(chan struct{}),
+	}
+	node, err := New(ci, hand, rpc, log, WithPeerProvider(peers), WithKnownCandidatesOnly())
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+	// Delay elections
+	node.mu.Lock()
+	node.electTimer.Reset(10 * time.Second)
+	node.mu.Unlock()
+
+	rogue, known := fakeNode("rogue"), fakeNode("known")
+	for _, fake := range []*Node{rogue, known} {
+		mockRegisterPeer(fake)
+		defer mockUnregisterPeer(fake.id)
+	}
+
+	// The unknown candidate is denied, without adopting its term.
+	node.VoteRequests <- &pb.VoteRequest{Term: 5, Candidate: rogue.id}
+	if vresp := <-rogue.VoteResponses; vresp.Granted {
+		t.Fatal("Expected the VoteResponse to have Granted of false")
+	}
+	if err := errWait(t, errCh); !errors.Is(err, ErrUnknownCandidate) {
+		t.Fatalf("Expected %v, got: %v", ErrUnknownCandidate, err)
+	}
+	if term := node.CurrentTerm(); term != 0 {
+		t.Fatalf("Expected the term to stay 0, got %d", term)
+	}
+
+	// A member gets our vote.
+	node.VoteRequests <- &pb.VoteRequest{Term: 1, Candidate: known.id}
+	if vresp := <-known.VoteResponses; !vresp.Granted {
+		t.Fatal("Expected the VoteResponse to have been Granted")
+	}
+}
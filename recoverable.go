@@ -0,0 +1,148 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// StateSource is where a RecoverableState was found.
+type StateSource int8
+
+// Sources of a RecoverableState.
+const (
+	// The log file itself.
+	STATE_SOURCE_PRIMARY StateSource = iota
+	// A temporary file left by a write interrupted before it replaced
+	// the log file. It may hold a newer state.
+	STATE_SOURCE_TEMP
+	// A copy of a corrupt log file, see WithResetOnCorruptLog.
+	STATE_SOURCE_QUARANTINE
+	// A record of a state history, see WithStateHistory.
+	STATE_SOURCE_HISTORY
+)
+
+// Convenience for printing, etc.
+func (s StateSource) String() string {
+	switch s {
+	case STATE_SOURCE_PRIMARY:
+		return "Primary"
+	case STATE_SOURCE_TEMP:
+		return "Temp"
+	case STATE_SOURCE_QUARANTINE:
+		return "Quarantine"
+	case STATE_SOURCE_HISTORY:
+		return "History"
+	default:
+		return fmt.Sprintf("Unknown[%d]", s)
+	}
+}
+
+// RecoverableState is a state of a node found on disk, see
+// ListRecoverableStates.
+type RecoverableState struct {
+	Source StateSource
+	Path   string
+	// Line of the record in a history file, else 0.
+	Line int
+
+	Term uint64
+	Vote string
+	// Time of a history record, else when the file was last modified.
+	Time time.Time
+
+	// Err is why the state can not be recovered, nil if it is valid.
+	// Term and Vote are only set for a valid state.
+	Err error
+}
+
+// ListRecoverableStates enumerates the states of a node available on
+// disk, to choose what to restore while the node is stopped: the log
+// file at path, the temporary files of writes interrupted before
+// replacing it, the copies of the corrupt log kept in quarantineDir, see
+// WithQuarantineDir, or in the directory of the log file if empty, and
+// the records of the given state history files, in that order. The log
+// file is always listed, with its error if it is missing, the others
+// only when found. Each state is verified, and reported with the error
+// found if it is not valid.
+func ListRecoverableStates(path, quarantineDir string, histories ...string) ([]RecoverableState, error) {
+	states := []RecoverableState{fileState(STATE_SOURCE_PRIMARY, path)}
+
+	dir, base := filepath.Split(path)
+	entries, err := readDir(dir)
+	if err != nil {
+		return states, err
+	}
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), base+".tmp") {
+			states = append(states, fileState(STATE_SOURCE_TEMP, filepath.Join(dir, e.Name())))
+		}
+	}
+	if quarantineDir != "" {
+		dir = quarantineDir
+		if entries, err = readDir(dir); err != nil {
+			return states, err
+		}
+	}
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), base+".corrupt.") {
+			states = append(states, fileState(STATE_SOURCE_QUARANTINE, filepath.Join(dir, e.Name())))
+		}
+	}
+
+	for _, hpath := range histories {
+		records, err := ReadStateHistory(hpath)
+		for _, rec := range records {
+			rs := RecoverableState{Source: STATE_SOURCE_HISTORY, Path: hpath, Line: rec.Line, Time: rec.Time}
+			if rec.Corrupt {
+				rs.Err = newLogError("read", hpath, ErrLogCorrupt)
+			} else {
+				rs.Term, rs.Vote = rec.Term, rec.Vote
+			}
+			states = append(states, rs)
+		}
+		if err != nil {
+			return states, err
+		}
+	}
+	return states, nil
+}
+
+// fileState loads the state of the file at path.
+func fileState(source StateSource, path string) RecoverableState {
+	rs := RecoverableState{Source: source, Path: path}
+	if fi, err := os.Stat(path); err == nil {
+		rs.Time = fi.ModTime()
+	}
+	ps, _, err := loadState(path)
+	if err != nil {
+		rs.Err = err
+		return rs
+	}
+	rs.Term, rs.Vote = ps.CurrentTerm, ps.VotedFor
+	return rs
+}
+
+// readDir lists the directory at dir, which is empty if missing.
+func readDir(dir string) ([]os.DirEntry, error) {
+	entries, err := os.ReadDir(filepath.Clean(dir))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, newLogError("list", dir, err)
+	}
+	return entries, nil
+}
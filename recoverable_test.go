@@ -0,0 +1,191 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeStateFile writes a valid state of term and vote to path, or one
+// not matching its digest if term is 0.
+func writeStateFile(t *testing.T, path string, term uint64, vote string) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := EncodeState(&buf, PersistentState{Version: STATE_VERSION, CurrentTerm: term, VotedFor: vote}); err != nil {
+		t.Fatalf("Unexpected error encoding state: %v", err)
+	}
+	data := buf.Bytes()
+	if term == 0 {
+		env := &envelope{}
+		if err := json.Unmarshal(data, env); err != nil {
+			t.Fatalf("Error unmarshalling envelope: %v", err)
+		}
+		env.SHA[0]++
+		data, _ = json.Marshal(env)
+	}
+	if err := os.WriteFile(path, data, 0660); err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+}
+
+func TestListRecoverableStates(t *testing.T) {
+	type file struct {
+		// 0 for a corrupt file.
+		term uint64
+		vote string
+	}
+	tests := []struct {
+		name        string
+		primary     *file
+		temp        *file
+		quarantined *file
+	}{
+		{"valid primary", &file{3, "a"}, nil, nil},
+		{"corrupt primary", &file{}, nil, nil},
+		{"missing primary", nil, nil, nil},
+		{"valid primary and temp", &file{3, "a"}, &file{4, "b"}, nil},
+		{"corrupt primary and valid temp", &file{}, &file{4, "b"}, nil},
+		{"valid primary and corrupt temp", &file{3, "a"}, &file{}, nil},
+		{"corrupt primary and temp", &file{}, &file{}, nil},
+		{"quarantined", &file{3, "a"}, nil, &file{}},
+		{"all", &file{3, "a"}, &file{4, "b"}, &file{}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "graft.log")
+			// Unrelated files are not listed.
+			writeStateFile(t, filepath.Join(dir, "other.log"), 1, "z")
+
+			expected := []RecoverableState{{Source: STATE_SOURCE_PRIMARY, Path: path}}
+			add := func(source StateSource, path string, f *file) {
+				writeStateFile(t, path, f.term, f.vote)
+				if source == STATE_SOURCE_PRIMARY {
+					expected[0] = RecoverableState{Source: source, Path: path, Term: f.term, Vote: f.vote}
+				} else {
+					expected = append(expected, RecoverableState{Source: source, Path: path, Term: f.term, Vote: f.vote})
+				}
+			}
+			if tc.primary != nil {
+				add(STATE_SOURCE_PRIMARY, path, tc.primary)
+			}
+			if tc.temp != nil {
+				add(STATE_SOURCE_TEMP, path+".tmp123", tc.temp)
+			}
+			if tc.quarantined != nil {
+				add(STATE_SOURCE_QUARANTINE, path+".corrupt.42", tc.quarantined)
+			}
+
+			states, err := ListRecoverableStates(path, "")
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if len(states) != len(expected) {
+				t.Fatalf("Expected %d states, got %+v", len(expected), states)
+			}
+			for i, s := range states {
+				e := expected[i]
+				if s.Source != e.Source || s.Path != e.Path {
+					t.Fatalf("Expected state %d from %v %q, got %+v", i, e.Source, e.Path, s)
+				}
+				switch {
+				case tc.primary == nil && i == 0:
+					if !errors.Is(s.Err, fs.ErrNotExist) || !s.Time.IsZero() {
+						t.Fatalf("Expected a missing primary, got %+v", s)
+					}
+				case e.Term == 0:
+					if !errors.Is(s.Err, ErrLogCorrupt) || s.Time.IsZero() {
+						t.Fatalf("Expected state %d to be corrupt, got %+v", i, s)
+					}
+				default:
+					if s.Err != nil || s.Term != e.Term || s.Vote != e.Vote || s.Time.IsZero() {
+						t.Fatalf("Expected state %d to be %d/%q, got %+v", i, e.Term, e.Vote, s)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestListRecoverableStatesQuarantineDir(t *testing.T) {
+	dir, quarantine := t.TempDir(), t.TempDir()
+	path := filepath.Join(dir, "graft.log")
+	writeStateFile(t, path, 3, "a")
+	// Copies left in the log directory are not listed.
+	writeStateFile(t, path+".corrupt.1", 0, "")
+	writeStateFile(t, filepath.Join(quarantine, "graft.log.corrupt.2"), 0, "")
+	writeStateFile(t, filepath.Join(quarantine, "other.log.corrupt.3"), 0, "")
+
+	states, err := ListRecoverableStates(path, quarantine)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(states) != 2 {
+		t.Fatalf("Expected the log and 1 copy, got %+v", states)
+	}
+	if s := states[1]; s.Source != STATE_SOURCE_QUARANTINE || s.Path != filepath.Join(quarantine, "graft.log.corrupt.2") || !errors.Is(s.Err, ErrLogCorrupt) {
+		t.Fatalf("Expected the quarantined copy, got %+v", s)
+	}
+}
+
+func TestListRecoverableStatesHistory(t *testing.T) {
+	_, _, log := genNodeArgs(t)
+	history := filepath.Join(t.TempDir(), "history")
+	node := &Node{logPath: log, opts: options{historyPath: history, metrics: nopMetrics{}}}
+	for term := uint64(1); term <= 2; term++ {
+		node.term, node.vote = term, "a"
+		if err := node.writeState(); err != nil {
+			t.Fatalf("Unexpected error writing state: %v", err)
+		}
+	}
+	f, err := os.OpenFile(history, os.O_WRONLY|os.O_APPEND, 0660)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	f.WriteString("garbage\n")
+	f.Close()
+
+	states, err := ListRecoverableStates(log, "", history)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(states) != 4 {
+		t.Fatalf("Expected the log and 3 records, got %+v", states)
+	}
+	if s := states[0]; s.Source != STATE_SOURCE_PRIMARY || s.Err != nil || s.Term != 2 {
+		t.Fatalf("Expected the log at term 2, got %+v", s)
+	}
+	for i, s := range states[1:] {
+		if s.Source != STATE_SOURCE_HISTORY || s.Path != history || s.Line != i+1 {
+			t.Fatalf("Expected record %d from the history, got %+v", i+1, s)
+		}
+	}
+	if s := states[1]; s.Err != nil || s.Term != 1 || s.Vote != "a" || s.Time.IsZero() {
+		t.Fatalf("Expected the record of term 1, got %+v", s)
+	}
+	if s := states[3]; !errors.Is(s.Err, ErrLogCorrupt) {
+		t.Fatalf("Expected a corrupt record, got %+v", s)
+	}
+
+	// A missing history is an error.
+	if _, err := ListRecoverableStates(log, "", history+".missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected %v, got: %v", fs.ErrNotExist, err)
+	}
+}
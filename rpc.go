@@ -22,6 +22,10 @@ import (
 // passed to Init() to call back into the Node when VoteRequests,
 // VoteResponses and Heartbeat RPCs are received. They will be
 // placed on the appropriate node's channels.
+//
+// Init must return an error if the driver can not be used, in which case
+// New fails. Drivers that connect asynchronously report failures that
+// happen after Init returned with Node.ReportRPCError.
 type RPCDriver interface {
 	// Used to initialize the driver
 	Init(*Node) error
@@ -34,3 +38,28 @@ type RPCDriver interface {
 	// Used by Leader Nodes to Heartbeat
 	HeartBeat(*pb.Heartbeat) error
 }
+
+// A HeartbeatResponder is an RPCDriver that can also deliver heartbeat
+// responses back to a LEADER. The responses are placed on the LEADER
+// node's HeartBeatResponses channel. It is required for CheckQuorum.
+type HeartbeatResponder interface {
+	// Used by Nodes to acknowledge a Leader's Heartbeat
+	SendHeartbeatResponse(leader string, hbresp *pb.HeartbeatResponse) error
+}
+
+// A PeerVoteRequester is an RPCDriver that can send a VoteRequest to a
+// single peer. It is required to bound the vote requests in flight.
+type PeerVoteRequester interface {
+	// Used to list the ids of the other members
+	Peers() []string
+	// Used by Candidate Nodes to request the vote of a single member
+	RequestVoteFrom(peer string, vr *pb.VoteRequest) error
+}
+
+// A MembershipReporter is an RPCDriver that knows the members it can ever
+// reach, e.g. from a static list of addresses. It is required by
+// WithMembershipCheck.
+type MembershipReporter interface {
+	// Used to count the configured members, ourselves included
+	ConfiguredMembers() int
+}
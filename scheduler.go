@@ -0,0 +1,102 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+import "sync"
+
+// A Scheduler runs the handler notifications of nodes, i.e. the calls
+// to their Handler for state changes, errors and leadership churn. Many
+// nodes can share one, e.g. a WorkerPool, to bound the go routines
+// those notifications use. Go must not block. See WithScheduler.
+//
+// Only notifications go through a Scheduler. The loop of a node, its
+// PeerProvider watcher and its lease mirror live as long as the node,
+// so they would hold a worker of a bounded pool forever; they keep
+// their own go routines, as do the timers and the RPCDriver.
+type Scheduler interface {
+	Go(task func())
+}
+
+// goScheduler is the default Scheduler, running each task on its own
+// go routine.
+type goScheduler struct{}
+
+func (goScheduler) Go(task func()) { go task() }
+
+// WorkerPool is a Scheduler running tasks on a fixed number of go
+// routines, in the order they were submitted. Go never blocks, tasks
+// wait in a queue for a free worker, so a Handler blocking for long
+// delays the notifications of all the nodes sharing the pool.
+type WorkerPool struct {
+	mu     sync.Mutex
+	ready  *sync.Cond
+	tasks  []func()
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// NewWorkerPool returns a WorkerPool of workers go routines, at least
+// one.
+func NewWorkerPool(workers int) *WorkerPool {
+	p := &WorkerPool{}
+	p.ready = sync.NewCond(&p.mu)
+	workers = max(workers, 1)
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// Go queues task to run on a worker. Once the pool is closed, task
+// runs on its own go routine instead, so that no notification is lost.
+func (p *WorkerPool) Go(task func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		go task()
+		return
+	}
+	p.tasks = append(p.tasks, task)
+	p.ready.Signal()
+}
+
+// Close stops the workers once the queued tasks ran.
+func (p *WorkerPool) Close() {
+	p.mu.Lock()
+	p.closed = true
+	p.ready.Broadcast()
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+func (p *WorkerPool) work() {
+	defer p.wg.Done()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for {
+		for len(p.tasks) == 0 && !p.closed {
+			p.ready.Wait()
+		}
+		if len(p.tasks) == 0 {
+			return
+		}
+		task := p.tasks[0]
+		p.tasks[0] = nil
+		p.tasks = p.tasks[1:]
+		p.mu.Unlock()
+		task()
+		p.mu.Lock()
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPool(t *testing.T) {
+	pool := NewWorkerPool(2)
+
+	var running, peak, ran atomic.Int64
+	for i := 0; i < 100; i++ {
+		pool.Go(func() {
+			if r := running.Add(1); r > peak.Load() {
+				peak.Store(r)
+			}
+			time.Sleep(time.Millisecond)
+			running.Add(-1)
+			ran.Add(1)
+		})
+	}
+	// Close waits for the queued tasks.
+	pool.Close()
+	if r := ran.Load(); r != 100 {
+		t.Fatalf("Expected 100 tasks to run, got %d", r)
+	}
+	if p := peak.Load(); p > 2 {
+		t.Fatalf("Expected at most 2 tasks running at once, got %d", p)
+	}
+
+	// Tasks still run once closed.
+	done := make(chan struct{})
+	pool.Go(func() { close(done) })
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the task to run after Close")
+	}
+
+	if _, err := New(ClusterInfo{Name: "pool", Size: 1}, &dummyHandler{}, NewMockRpc(), "log",
+		WithScheduler(nil)); err != ErrInvalidOption {
+		t.Fatalf("Expected %v, got: %v", ErrInvalidOption, err)
+	}
+}
+
+func TestSharedScheduler(t *testing.T) {
+	pool := NewWorkerPool(1)
+	defer pool.Close()
+
+	// Two single node clusters notified on one worker.
+	var chans []chan StateChange
+	for i := 0; i < 2; i++ {
+		ci := ClusterInfo{Name: fmt.Sprintf("shared%d", i), Size: 1}
+		_, rpc, log := genNodeArgs(t)
+		scCh := make(chan StateChange, 2)
+		node, err := New(ci, NewChanHandler(scCh, make(chan error, 1)), rpc, log, WithScheduler(pool))
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		defer node.Close()
+		chans = append(chans, scCh)
+	}
+	for _, scCh := range chans {
+		if sc := wait(t, scCh); sc.From != FOLLOWER || sc.To != CANDIDATE {
+			t.Fatalf("Did not receive correct states for state change: %+v", sc)
+		}
+		if sc := wait(t, scCh); sc.From != CANDIDATE || sc.To != LEADER {
+			t.Fatalf("Did not receive correct states for state change: %+v", sc)
+		}
+	}
+}
+
+// blockedHandler blocks its state change notifications until released.
+type blockedHandler struct {
+	dummyHandler
+	release chan struct{}
+}
+
+func (h *blockedHandler) StateChange(from, to State) {
+	<-h.release
+}
+
+// Compare the go routines used by many nodes whose Handler is slow,
+// with and without a shared Scheduler.
+func BenchmarkSchedulerGoroutines(b *testing.B) {
+	const nodes = 100
+	run := func(b *testing.B, pool *WorkerPool) {
+		for i := 0; i < b.N; i++ {
+			base := runtime.NumGoroutine()
+			hand := &blockedHandler{release: make(chan struct{})}
+			all := make([]*Node, 0, nodes)
+			for j := 0; j < nodes; j++ {
+				log, err := os.CreateTemp(b.TempDir(), "_grafty_log")
+				if err != nil {
+					b.Fatal("Could not create the log file")
+				}
+				log.Close()
+				opts := []Option{WithHeartbeatInterval(5*time.Millisecond, 10, 20)}
+				if pool != nil {
+					opts = append(opts, WithScheduler(pool))
+				}
+				ci := ClusterInfo{Name: fmt.Sprintf("bench%d", j), Size: 1}
+				node, err := New(ci, hand, NewMockRpc(), log.Name(), opts...)
+				if err != nil {
+					b.Fatalf("Expected no error, got: %v", err)
+				}
+				all = append(all, node)
+			}
+			for _, node := range all {
+				if state := waitForState(node, LEADER); state != LEADER {
+					b.Fatalf("Expected Node to be in Leader state, got: %s", state)
+				}
+			}
+			b.ReportMetric(float64(runtime.NumGoroutine()-base)/nodes, "goroutines/node")
+
+			close(hand.release)
+			var wg sync.WaitGroup
+			for _, node := range all {
+				wg.Add(1)
+				go func(node *Node) {
+					defer wg.Done()
+					node.Close()
+				}(node)
+			}
+			wg.Wait()
+		}
+	}
+
+	b.Run("PerNode", func(b *testing.B) {
+		run(b, nil)
+	})
+	b.Run("WorkerPool", func(b *testing.B) {
+		pool := NewWorkerPool(4)
+		defer pool.Close()
+		run(b, pool)
+	})
+}
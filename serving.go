@@ -0,0 +1,61 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+// StartServing starts a node created with WithDeferredServing: its
+// RPCDriver is initialized, so peers can reach it, and it starts
+// answering them and running elections, with a fresh election timeout.
+// Its persisted state was already loaded by New. If the driver fails to
+// initialize, the node is not started and StartServing can be retried.
+// It does nothing if the node is already serving.
+func (n *Node) StartServing() error {
+	n.serveMu.Lock()
+	defer n.serveMu.Unlock()
+	if n.isClosing() {
+		return ErrNodeClosed
+	}
+	if n.isServing() {
+		return nil
+	}
+	if err := n.transport().Init(n); err != nil {
+		return &RPCError{Kind: KindTransport, Op: "init", Err: err}
+	}
+	n.mu.Lock()
+	n.resetElectionTimeout()
+	n.mu.Unlock()
+	close(n.serving)
+	return nil
+}
+
+// isServing returns whether the node answers RPCs and runs elections.
+func (n *Node) isServing() bool {
+	select {
+	case <-n.serving:
+		return true
+	default:
+		return false
+	}
+}
+
+// waitForServing blocks the loop until StartServing, and returns false
+// if we are closed first.
+func (n *Node) waitForServing() bool {
+	select {
+	case <-n.serving:
+		return true
+	case q := <-n.quit:
+		n.processQuit(q)
+		return false
+	}
+}
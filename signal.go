@@ -0,0 +1,83 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// HandleSignals closes the node gracefully when it receives one of the
+// signals, os.Interrupt and SIGTERM by default, e.g. on deploys. A LEADER
+// first pauses, so it neither sends heartbeats nor campaigns again while
+// closing. Pausing tells the peers nothing: they elect a new LEADER once
+// their election timeout expires, unless WithSignalHandoff hands the
+// leadership off first. It returns immediately.
+// Cancelling ctx stops handling the signals, and bounds the close once a
+// signal was received.
+func (n *Node) HandleSignals(ctx context.Context, signals ...os.Signal) {
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, signals...)
+	go func() {
+		defer signal.Stop(ch)
+		n.closeOnSignal(ctx, ch)
+	}()
+}
+
+// closeOnSignal closes the node gracefully once a signal is received on
+// ch, unless ctx is done first.
+func (n *Node) closeOnSignal(ctx context.Context, ch <-chan os.Signal) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-ch:
+	}
+	// Hand off our leadership if asked to. Else pause, which stops our
+	// heartbeats and campaigns, and the peers elect a new LEADER after
+	// their election timeout.
+	if n.State() == LEADER && n.opts.signalHandoff > 0 {
+		hctx, cancel := context.WithTimeout(ctx, n.opts.signalHandoff)
+		if err := n.TransferLeadership(hctx, NO_LEADER); err != nil {
+			n.handleError(err)
+		}
+		cancel()
+	}
+	if n.State() == LEADER {
+		n.Pause()
+	}
+	if err := n.CloseWithContext(ctx); err != nil {
+		n.handleError(err)
+	}
+}
+
+// CloseWithContext closes the node as Close does, but returns the error
+// of ctx if it is done first. The close then completes in the background.
+func (n *Node) CloseWithContext(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		n.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
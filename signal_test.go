@@ -0,0 +1,146 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCloseOnSignal(t *testing.T) {
+	ci := ClusterInfo{Name: "signal", Size: 1}
+	_, rpc, log := genNodeArgs(t)
+	scCh := make(chan StateChange, 8)
+	errCh := make(chan error, 8)
+	node, err := New(ci, NewChanHandler(scCh, errCh), rpc, log)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+
+	if state := waitForState(node, LEADER); state != LEADER {
+		t.Fatalf("Expected Node to be in Leader state, got: %s", state)
+	}
+
+	ch := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	go func() {
+		node.closeOnSignal(context.Background(), ch)
+		close(done)
+	}()
+	ch <- syscall.SIGTERM
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the node to be closed on the signal")
+	}
+	if state := node.State(); state != CLOSED {
+		t.Fatalf("Expected node to be in Closed state, got: %s", state)
+	}
+
+	// The LEADER stepped down before closing.
+	for {
+		select {
+		case sc := <-scCh:
+			if sc.From != LEADER {
+				continue
+			}
+			if sc.To != PAUSED || sc.Reason != REASON_PAUSED {
+				t.Fatalf("Expected the Leader to step down, got %+v", sc)
+			}
+			return
+		case <-time.After(time.Second):
+			t.Fatal("Expected the Leader to step down")
+		}
+	}
+}
+
+func TestHandoffOnSignal(t *testing.T) {
+	ci := ClusterInfo{Name: "handoff", Size: 3}
+	hub := NewMockHub()
+	nodes := make([]*Node, 3)
+	for i := range nodes {
+		hand, _, log := genNodeArgs(t)
+		node, err := New(ci, hand, hub.NewRpc(), log, WithSignalHandoff(time.Second))
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		defer node.Close()
+		nodes[i] = node
+	}
+	expectedClusterState(t, nodes, 1, 2, 0)
+	leader := findLeader(nodes)
+	// Let the followers acknowledge some heartbeats.
+	time.Sleep(3 * HEARTBEAT_INTERVAL)
+
+	var rest []*Node
+	for _, n := range nodes {
+		if n != leader {
+			rest = append(rest, n)
+		}
+	}
+
+	ch := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	go func() {
+		leader.closeOnSignal(context.Background(), ch)
+		close(done)
+	}()
+	start := time.Now()
+	ch <- syscall.SIGTERM
+
+	// A follower took over, without an election timeout.
+	var successor *Node
+	for successor == nil && time.Since(start) < 5*time.Second {
+		successor = findLeader(rest)
+		time.Sleep(5 * time.Millisecond)
+	}
+	if successor == nil {
+		t.Fatal("Expected a follower to take the leadership over")
+	}
+	if elapsed := time.Since(start); elapsed >= MIN_ELECTION_TIMEOUT {
+		t.Fatalf("Expected the handoff to take less than %v, took %v", MIN_ELECTION_TIMEOUT, elapsed)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the node to be closed on the signal")
+	}
+	if state := leader.State(); state != CLOSED {
+		t.Fatalf("Expected node to be in Closed state, got: %s", state)
+	}
+
+	if _, err := New(ci, &dummyHandler{}, hub.NewRpc(), "log", WithSignalHandoff(0)); err != ErrInvalidOption {
+		t.Fatalf("Expected %v, got: %v", ErrInvalidOption, err)
+	}
+}
+
+func TestCloseWithContext(t *testing.T) {
+	ci := ClusterInfo{Name: "signal", Size: 3}
+	hand, rpc, log := genNodeArgs(t)
+	node, err := New(ci, hand, rpc, log)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if err := node.CloseWithContext(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if state := node.State(); state != CLOSED {
+		t.Fatalf("Expected node to be in Closed state, got: %s", state)
+	}
+}
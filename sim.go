@@ -0,0 +1,309 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nats-io/graft/pb"
+)
+
+// SimConfig describes an election scenario for Simulate.
+// Zero values select the defaults.
+type SimConfig struct {
+	// Number of nodes in the cluster. Defaults to 3.
+	Nodes int
+	// Number of independent runs of the scenario. Defaults to 10.
+	Trials int
+	// Simulated time of each run. Defaults to 5*MAX_ELECTION_TIMEOUT.
+	Duration time.Duration
+	// One-way latency of every link.
+	Latency time.Duration
+	// Upper bound of a random delay added to the latency.
+	Jitter time.Duration
+	// Probability, between 0 and 1, that a message is lost.
+	DropRate float64
+	// Election timeouts of the nodes. Defaults to the node default.
+	Timeouts TimeoutStrategy
+	// Resolution of the simulated time. Defaults to 1ms.
+	Step time.Duration
+	// Seed of the network randomness.
+	Seed int64
+}
+
+// SimResult reports the outcome of Simulate.
+type SimResult struct {
+	// Number of runs.
+	Trials int
+	// Runs that elected a LEADER within the configured duration.
+	Elected int
+	// Time to elect the first LEADER of each elected run, sorted.
+	TimeToElect []time.Duration
+	// Changes of LEADER after the first election, over all runs.
+	LeadershipChanges int
+}
+
+// Percentile returns the p-th percentile, with p between 0 and 100,
+// of the times to elect. It returns 0 if no run elected a LEADER.
+func (r SimResult) Percentile(p float64) time.Duration {
+	if len(r.TimeToElect) == 0 {
+		return 0
+	}
+	i := int(p / 100 * float64(len(r.TimeToElect)-1))
+	i = max(0, min(i, len(r.TimeToElect)-1))
+	return r.TimeToElect[i]
+}
+
+// Simulate runs an election scenario with real nodes connected by an
+// in-memory network and driven by a FakeClock, and reports how long
+// elections take and how often leadership changes. It is meant for
+// capacity planning; runs take a fraction of the simulated time and
+// results vary with scheduling.
+func Simulate(config SimConfig) SimResult {
+	cfg := config.withDefaults()
+	rng := rand.New(rand.NewSource(cfg.Seed))
+	res := SimResult{Trials: cfg.Trials}
+
+	for i := 0; i < cfg.Trials; i++ {
+		elapsed, changes, ok := simulateTrial(cfg, rng)
+		if ok {
+			res.Elected++
+			res.TimeToElect = append(res.TimeToElect, elapsed)
+		}
+		res.LeadershipChanges += changes
+	}
+	sort.Slice(res.TimeToElect, func(i, j int) bool {
+		return res.TimeToElect[i] < res.TimeToElect[j]
+	})
+	return res
+}
+
+func (c SimConfig) withDefaults() SimConfig {
+	if c.Nodes <= 0 {
+		c.Nodes = 3
+	}
+	if c.Trials <= 0 {
+		c.Trials = 10
+	}
+	if c.Duration <= 0 {
+		c.Duration = 5 * MAX_ELECTION_TIMEOUT
+	}
+	if c.Timeouts == nil {
+		c.Timeouts = defaultOptions().timeouts
+	}
+	if c.Step <= 0 {
+		c.Step = time.Millisecond
+	}
+	return c
+}
+
+// simulateTrial runs one scenario and returns the time to elect the
+// first LEADER and the number of later leadership changes.
+func simulateTrial(cfg SimConfig, rng *rand.Rand) (time.Duration, int, bool) {
+	dir, err := os.MkdirTemp("", "graft_sim")
+	if err != nil {
+		return 0, 0, false
+	}
+	defer os.RemoveAll(dir)
+
+	clock := NewFakeClock(time.Unix(0, 0))
+	net := &simNetwork{cfg: cfg, clock: clock, rng: rng, nodes: make(map[string]*Node)}
+	ci := ClusterInfo{Name: "sim", Size: cfg.Nodes}
+
+	nodes := make([]*Node, 0, cfg.Nodes)
+	defer func() {
+		for _, n := range nodes {
+			n.Close()
+		}
+	}()
+	for i := 0; i < cfg.Nodes; i++ {
+		logPath := filepath.Join(dir, genUUID())
+		n, err := New(ci, &simHandler{}, &simRpc{net: net}, logPath,
+			WithClock(clock), WithTimeoutStrategy(cfg.Timeouts))
+		if err != nil {
+			return 0, 0, false
+		}
+		nodes = append(nodes, n)
+	}
+
+	var elapsed time.Duration
+	elected := false
+	changes := 0
+	leader := NO_LEADER
+	for t := time.Duration(0); t < cfg.Duration; t += cfg.Step {
+		// Let the nodes process what fired.
+		if clock.advance(cfg.Step) > 0 {
+			net.settle(nodes)
+		}
+
+		for _, n := range nodes {
+			if n.State() != LEADER || n.Id() == leader {
+				continue
+			}
+			if !elected {
+				elected = true
+				elapsed = t + cfg.Step
+			} else {
+				changes++
+			}
+			leader = n.Id()
+		}
+	}
+	return elapsed, changes, elected
+}
+
+// simSettleYields bounds the wait of simNetwork.settle.
+const simSettleYields = 1000
+
+// simHandler ignores the callbacks of simulated nodes.
+type simHandler struct {
+	defaultStateMachineHandler
+}
+
+func (*simHandler) AsyncError(err error)       {}
+func (*simHandler) StateChange(from, to State) {}
+
+// simNetwork connects simulated nodes, delaying and dropping messages
+// according to the SimConfig.
+type simNetwork struct {
+	mu    sync.Mutex
+	cfg   SimConfig
+	clock *FakeClock
+	rng   *rand.Rand
+	nodes map[string]*Node
+}
+
+// settle yields until the nodes received what the clock fired and
+// were delivered, or for at most simSettleYields times, e.g. if a
+// node does not read one of its timers in its current state.
+func (net *simNetwork) settle(nodes []*Node) {
+	for i := 0; i < simSettleYields; i++ {
+		runtime.Gosched()
+		if net.clock.idle() && simDrained(nodes) {
+			return
+		}
+	}
+}
+
+// simDrained reports whether the nodes received all the messages
+// delivered to them.
+func simDrained(nodes []*Node) bool {
+	for _, n := range nodes {
+		if len(n.VoteRequests) > 0 || len(n.VoteResponses) > 0 ||
+			len(n.HeartBeats) > 0 || len(n.HeartBeatResponses) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (net *simNetwork) peers(except string) []*Node {
+	net.mu.Lock()
+	defer net.mu.Unlock()
+	nodes := make([]*Node, 0, len(net.nodes))
+	for id, n := range net.nodes {
+		if id != except {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+func (net *simNetwork) peer(id string) *Node {
+	net.mu.Lock()
+	defer net.mu.Unlock()
+	return net.nodes[id]
+}
+
+// send schedules deliver after the link delay, unless the message is lost.
+func (net *simNetwork) send(deliver func()) {
+	net.mu.Lock()
+	if net.rng.Float64() < net.cfg.DropRate {
+		net.mu.Unlock()
+		return
+	}
+	delay := net.cfg.Latency
+	if net.cfg.Jitter > 0 {
+		delay += time.Duration(net.rng.Int63n(int64(net.cfg.Jitter)))
+	}
+	net.mu.Unlock()
+	net.clock.AfterFunc(delay, deliver)
+}
+
+// simRpc is the RPCDriver of a simulated node.
+type simRpc struct {
+	net  *simNetwork
+	node *Node
+}
+
+func (rpc *simRpc) Init(n *Node) error {
+	// Buffered so delivery never blocks the network.
+	n.VoteRequests = make(chan *pb.VoteRequest, 64)
+	n.VoteResponses = make(chan *pb.VoteResponse, 64)
+	n.HeartBeats = make(chan *pb.Heartbeat, 64)
+	n.HeartBeatResponses = make(chan *pb.HeartbeatResponse, 64)
+	rpc.node = n
+	rpc.net.mu.Lock()
+	rpc.net.nodes[n.id] = n
+	rpc.net.mu.Unlock()
+	return nil
+}
+
+func (rpc *simRpc) Close() {
+	rpc.net.mu.Lock()
+	delete(rpc.net.nodes, rpc.node.id)
+	rpc.net.mu.Unlock()
+}
+
+func (rpc *simRpc) RequestVote(vr *pb.VoteRequest) error {
+	for _, p := range rpc.net.peers(rpc.node.id) {
+		rpc.net.send(func() { simDeliver(p.VoteRequests, vr) })
+	}
+	return nil
+}
+
+func (rpc *simRpc) HeartBeat(hb *pb.Heartbeat) error {
+	for _, p := range rpc.net.peers(rpc.node.id) {
+		rpc.net.send(func() { simDeliver(p.HeartBeats, hb) })
+	}
+	return nil
+}
+
+func (rpc *simRpc) SendVoteResponse(candidate string, vresp *pb.VoteResponse) error {
+	if p := rpc.net.peer(candidate); p != nil {
+		rpc.net.send(func() { simDeliver(p.VoteResponses, vresp) })
+	}
+	return nil
+}
+
+func (rpc *simRpc) SendHeartbeatResponse(leader string, hbresp *pb.HeartbeatResponse) error {
+	if p := rpc.net.peer(leader); p != nil {
+		rpc.net.send(func() { simDeliver(p.HeartBeatResponses, hbresp) })
+	}
+	return nil
+}
+
+// simDeliver drops the message if the receiver is overwhelmed.
+func simDeliver[T any](ch chan T, msg T) {
+	select {
+	case ch <- msg:
+	default:
+	}
+}
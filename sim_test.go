@@ -0,0 +1,146 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSimulateConverges(t *testing.T) {
+	res := Simulate(SimConfig{
+		Nodes:    3,
+		Trials:   3,
+		Duration: 3 * MAX_ELECTION_TIMEOUT,
+		Latency:  time.Millisecond,
+		Jitter:   time.Millisecond,
+	})
+	if res.Trials != 3 {
+		t.Fatalf("Expected 3 trials, got %d", res.Trials)
+	}
+	if res.Elected != res.Trials {
+		t.Fatalf("Expected every trial to elect a leader, got %d of %d", res.Elected, res.Trials)
+	}
+	if len(res.TimeToElect) != res.Elected {
+		t.Fatalf("Expected %d times to elect, got %d", res.Elected, len(res.TimeToElect))
+	}
+	// A leader can not be elected before the first election timeout.
+	if min := res.Percentile(0); min < MIN_ELECTION_TIMEOUT {
+		t.Fatalf("Expected time to elect of at least %v, got %v", MIN_ELECTION_TIMEOUT, min)
+	}
+	if max := res.Percentile(100); max > 3*MAX_ELECTION_TIMEOUT {
+		t.Fatalf("Expected time to elect below %v, got %v", 3*MAX_ELECTION_TIMEOUT, max)
+	}
+	// The network is stable, the leader should stay.
+	if res.LeadershipChanges != 0 {
+		t.Fatalf("Expected no leadership change, got %d", res.LeadershipChanges)
+	}
+}
+
+func TestSimulateWithDrops(t *testing.T) {
+	res := Simulate(SimConfig{
+		Nodes:    5,
+		Trials:   2,
+		Duration: 5 * MAX_ELECTION_TIMEOUT,
+		Latency:  2 * time.Millisecond,
+		DropRate: 0.1,
+		Seed:     42,
+	})
+	if res.Elected != res.Trials {
+		t.Fatalf("Expected every trial to elect a leader, got %d of %d", res.Elected, res.Trials)
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	start := time.Unix(0, 0)
+	clock := NewFakeClock(start)
+	timer := clock.NewTimer(10 * time.Millisecond)
+	ticker := clock.NewTicker(4 * time.Millisecond)
+	defer ticker.Stop()
+
+	clock.Advance(5 * time.Millisecond)
+	select {
+	case <-timer.C():
+		t.Fatalf("Expected the timer not to fire yet")
+	case <-ticker.C():
+	default:
+		t.Fatalf("Expected the ticker to fire")
+	}
+
+	clock.Advance(5 * time.Millisecond)
+	select {
+	case now := <-timer.C():
+		if d := now.Sub(start); d != 10*time.Millisecond {
+			t.Fatalf("Expected the timer to fire at 10ms, got %v", d)
+		}
+	default:
+		t.Fatalf("Expected the timer to fire")
+	}
+	if d := clock.Now().Sub(start); d != 10*time.Millisecond {
+		t.Fatalf("Expected the clock to be at 10ms, got %v", d)
+	}
+
+	// A reset timer fires again, a stopped one does not.
+	timer.Reset(time.Millisecond)
+	if !timer.Stop() {
+		t.Fatalf("Expected Stop to report an active timer")
+	}
+	clock.Advance(time.Millisecond)
+	select {
+	case <-timer.C():
+		t.Fatalf("Expected a stopped timer not to fire")
+	default:
+	}
+	timer.Reset(time.Millisecond)
+	clock.Advance(time.Millisecond)
+	select {
+	case <-timer.C():
+	default:
+		t.Fatalf("Expected a reset timer to fire")
+	}
+
+	fired := make(chan struct{})
+	release := make(chan struct{})
+	clock.AfterFunc(time.Millisecond, func() {
+		close(fired)
+		<-release
+	})
+	clock.Advance(time.Millisecond)
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected AfterFunc to run")
+	}
+
+	// The clock is idle once the expirations are received and the
+	// AfterFunc calls returned.
+	if clock.idle() {
+		t.Fatalf("Expected the clock not to be idle while AfterFunc runs")
+	}
+	close(release)
+	for deadline := time.Now().Add(time.Second); !clock.idle(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the clock to be idle once AfterFunc returned")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(4 * time.Millisecond)
+	if clock.idle() {
+		t.Fatalf("Expected the clock not to be idle with a tick not received")
+	}
+	<-ticker.C()
+	if !clock.idle() {
+		t.Fatalf("Expected the clock to be idle once the tick was received")
+	}
+}
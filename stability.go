@@ -0,0 +1,86 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+import (
+	"time"
+)
+
+// Heartbeat rounds a LEADER scores its stability over.
+const stabilityRounds = 10
+
+// stability tracks how well a LEADER reaches a quorum.
+type stability struct {
+	// Whether each of the last heartbeat rounds reached a quorum.
+	rounds []bool
+	next   int
+	// Last time a quorum acknowledged a heartbeat, or we were elected.
+	lastQuorum time.Time
+}
+
+// resetStability starts the stability of a new leadership.
+func (n *Node) resetStability() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.stability = stability{lastQuorum: n.opts.clock.Now()}
+}
+
+// recordRound records whether our last heartbeat round, now over,
+// reached a quorum.
+func (n *Node) recordRound(quorum bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	s := &n.stability
+	if len(s.rounds) < stabilityRounds {
+		s.rounds = append(s.rounds, quorum)
+		return
+	}
+	s.rounds[s.next] = quorum
+	s.next = (s.next + 1) % stabilityRounds
+}
+
+// LeaderStability scores from 0 to 1 how likely we are to keep our
+// leadership, e.g. for load balancers to drain a LEADER that is about to
+// lose its quorum. It is the share of our last heartbeat rounds that a
+// quorum acknowledged, lowered once no quorum acknowledged one for more
+// than two heartbeat intervals, down to 0 after as many intervals as
+// rounds are scored. A LEADER in full contact with its peers scores 1.
+// It is 0 unless we are LEADER. Heartbeats are only acknowledged with an
+// RPCDriver implementing HeartbeatResponder; without one the score can
+// not be measured, and a LEADER always scores 1.
+func (n *Node) LeaderStability() float64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.state != LEADER {
+		return 0
+	}
+	if _, ok := n.rpc.(HeartbeatResponder); !ok {
+		return 1
+	}
+	s := &n.stability
+	rate := 1.0
+	if len(s.rounds) > 0 {
+		reached := 0
+		for _, quorum := range s.rounds {
+			if quorum {
+				reached++
+			}
+		}
+		rate = float64(reached) / float64(len(s.rounds))
+	}
+	hb := n.opts.heartbeat
+	since := n.opts.clock.Now().Sub(s.lastQuorum) - 2*hb
+	recency := 1 - float64(since)/float64((stabilityRounds-2)*hb)
+	return rate * min(max(recency, 0), 1)
+}
@@ -0,0 +1,106 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLeaderStability(t *testing.T) {
+	ci := ClusterInfo{Name: "stability", Size: 3}
+	hub := NewMockHub()
+	interval := 20 * time.Millisecond
+	nodes := make([]*Node, 3)
+	for i := range nodes {
+		hand, _, log := genNodeArgs(t)
+		node, err := New(ci, hand, hub.NewRpc(), log, WithHeartbeatInterval(interval, 10, 20))
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		defer node.Close()
+		nodes[i] = node
+	}
+	var leader *Node
+	for deadline := time.Now().Add(5 * time.Second); leader == nil && time.Now().Before(deadline); {
+		time.Sleep(interval)
+		leader = findLeader(nodes)
+	}
+	if leader == nil {
+		t.Fatal("Expected a LEADER to be elected")
+	}
+	for _, n := range nodes {
+		if n != leader && n.LeaderStability() != 0 {
+			t.Fatalf("Expected a FOLLOWER to score 0, got %v", n.LeaderStability())
+		}
+	}
+
+	// In full contact with its peers, the LEADER is stable.
+	time.Sleep(stabilityRounds * interval)
+	for i := 0; i < 5; i++ {
+		if s := leader.LeaderStability(); s != 1 {
+			t.Fatalf("Expected a stability of 1, got %v", s)
+		}
+		time.Sleep(interval)
+	}
+
+	// Losing its peers, it is about to lose its quorum.
+	for _, n := range nodes {
+		if n != leader {
+			n.Pause()
+		}
+	}
+	time.Sleep(3 * interval)
+	degraded := leader.LeaderStability()
+	if degraded >= 1 {
+		t.Fatalf("Expected a stability below 1, got %v", degraded)
+	}
+	time.Sleep(stabilityRounds * interval)
+	if state := leader.State(); state != LEADER {
+		t.Fatalf("Expected to remain LEADER, got %s", state)
+	}
+	if s := leader.LeaderStability(); s != 0 {
+		t.Fatalf("Expected the stability to drop to 0 from %v, got %v", degraded, s)
+	}
+}
+
+func TestLeaderStabilityWithoutResponses(t *testing.T) {
+	ci := ClusterInfo{Name: "stability_noresp", Size: 3}
+	hub := NewMockHub()
+	interval := 5 * time.Millisecond
+	nodes := make([]*Node, 3)
+	for i := range nodes {
+		hand, _, log := genNodeArgs(t)
+		node, err := New(ci, hand, &noResponseRpc{hub.NewRpc()}, log, WithHeartbeatInterval(interval, 10, 20))
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		defer node.Close()
+		nodes[i] = node
+	}
+	var leader *Node
+	for deadline := time.Now().Add(5 * time.Second); leader == nil && time.Now().Before(deadline); {
+		time.Sleep(interval)
+		leader = findLeader(nodes)
+	}
+	if leader == nil {
+		t.Fatal("Expected a LEADER to be elected")
+	}
+
+	// Its heartbeats are never acknowledged, which tells nothing.
+	time.Sleep(2 * stabilityRounds * interval)
+	if s := leader.LeaderStability(); leader.State() == LEADER && s != 1 {
+		t.Fatalf("Expected a stability of 1, got %v", s)
+	}
+}
@@ -25,6 +25,8 @@ const (
 	LEADER
 	CANDIDATE
 	CLOSED
+	PAUSED
+	GAVE_UP
 )
 
 // Convenience for printing, etc.
@@ -38,7 +40,83 @@ func (s State) String() string {
 		return "Candidate"
 	case CLOSED:
 		return "Closed"
+	case PAUSED:
+		return "Paused"
+	case GAVE_UP:
+		return "GaveUp"
 	default:
 		return fmt.Sprintf("Unknown[%d]", s)
 	}
 }
+
+// Reason tells why a node changed state.
+type Reason int8
+
+// Reasons for a state change.
+const (
+	REASON_UNKNOWN Reason = iota
+	// A FOLLOWER did not hear from a LEADER and started a campaign.
+	REASON_ELECTION_TIMEOUT
+	// A CANDIDATE won its campaign.
+	REASON_WON_ELECTION
+	// A newer term was seen in a vote request, heartbeat or response.
+	REASON_HIGHER_TERM
+	// A CANDIDATE heard from the LEADER elected for its term.
+	REASON_NEW_LEADER
+	// A LEADER using CheckQuorum could no longer reach a quorum.
+	REASON_QUORUM_LOST
+	// The handler vetoed our leadership after we won a campaign.
+	REASON_VETOED
+	// A CANDIDATE could not write its state.
+	REASON_WRITE_FAILED
+	// The node was paused or resumed.
+	REASON_PAUSED
+	REASON_RESUMED
+	// Leadership was assumed or relinquished under external leadership.
+	REASON_EXTERNAL
+	// A CANDIDATE deferred to a competing CANDIDATE it prefers.
+	REASON_TIEBREAK
+	// A LEADER was demoted to observer.
+	REASON_DEMOTED
+	// A node ran out of election attempts, or was told to retry.
+	REASON_GAVE_UP
+	REASON_RETRY
+)
+
+// Convenience for printing, etc.
+func (r Reason) String() string {
+	switch r {
+	case REASON_UNKNOWN:
+		return "Unknown"
+	case REASON_ELECTION_TIMEOUT:
+		return "ElectionTimeout"
+	case REASON_WON_ELECTION:
+		return "WonElection"
+	case REASON_HIGHER_TERM:
+		return "HigherTerm"
+	case REASON_NEW_LEADER:
+		return "NewLeader"
+	case REASON_QUORUM_LOST:
+		return "QuorumLost"
+	case REASON_VETOED:
+		return "Vetoed"
+	case REASON_WRITE_FAILED:
+		return "WriteFailed"
+	case REASON_PAUSED:
+		return "Paused"
+	case REASON_RESUMED:
+		return "Resumed"
+	case REASON_EXTERNAL:
+		return "External"
+	case REASON_TIEBREAK:
+		return "Tiebreak"
+	case REASON_DEMOTED:
+		return "Demoted"
+	case REASON_GAVE_UP:
+		return "GaveUp"
+	case REASON_RETRY:
+		return "Retry"
+	default:
+		return fmt.Sprintf("Unknown[%d]", r)
+	}
+}
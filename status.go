@@ -0,0 +1,80 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Status is the document served by the StatusHandler.
+type Status struct {
+	Id           string     `json:"id"`
+	Cluster      string     `json:"cluster"`
+	State        string     `json:"state"`
+	Term         uint64     `json:"term"`
+	Vote         string     `json:"vote,omitempty"`
+	Leader       string     `json:"leader,omitempty"`
+	Peers        []string   `json:"peers"`
+	LastElection *time.Time `json:"last_election,omitempty"`
+}
+
+// Status returns a snapshot of the node's state. The peers are those
+// known to an RPCDriver implementing PeerVoteRequester, or else the
+// peers that answered our heartbeats while LEADER.
+func (n *Node) Status() Status {
+	n.mu.Lock()
+	st := Status{
+		Id:      n.id,
+		Cluster: n.info.Name,
+		State:   n.state.String(),
+		Term:    n.term,
+		Vote:    n.vote,
+		Leader:  n.leader,
+	}
+	if !n.electedAt.IsZero() {
+		at := n.electedAt
+		st.LastElection = &at
+	}
+	var peers []string
+	if n.state == LEADER {
+		for peer := range n.latencies {
+			peers = append(peers, peer)
+		}
+	}
+	n.mu.Unlock()
+
+	if pvr, ok := n.transport().(PeerVoteRequester); ok {
+		peers = pvr.Peers()
+	}
+	sort.Strings(peers)
+	st.Peers = append([]string{}, peers...)
+	return st
+}
+
+// StatusHandler returns an http.Handler serving the node's Status as
+// JSON, to be mounted on an existing mux. It never blocks the node.
+func (n *Node) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(n.Status())
+	})
+}
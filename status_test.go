@@ -0,0 +1,175 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nats-io/graft/pb"
+)
+
+func TestStatusHandler(t *testing.T) {
+	nodes := createNodes(t, "status", 3)
+	for _, n := range nodes {
+		defer n.Close()
+	}
+	expectedClusterState(t, nodes, 1, 2, 0)
+	leader := findLeader(nodes)
+	follower := firstFollower(nodes)
+	waitForLeader(follower, leader.Id())
+
+	rec := httptest.NewRecorder()
+	follower.StatusHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("Expected JSON content, got %q", ct)
+	}
+
+	var st struct {
+		Id           string   `json:"id"`
+		Cluster      string   `json:"cluster"`
+		State        string   `json:"state"`
+		Term         uint64   `json:"term"`
+		Leader       string   `json:"leader"`
+		Peers        []string `json:"peers"`
+		LastElection string   `json:"last_election"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil {
+		t.Fatalf("Error decoding status: %v", err)
+	}
+	if st.Id != follower.Id() || st.Cluster != "status" {
+		t.Fatalf("Unexpected identity: %q %q", st.Id, st.Cluster)
+	}
+	if st.State != FOLLOWER.String() {
+		t.Fatalf("Expected state %s, got %s", FOLLOWER, st.State)
+	}
+	if st.Term != follower.CurrentTerm() {
+		t.Fatalf("Expected term %d, got %d", follower.CurrentTerm(), st.Term)
+	}
+	if st.Leader != leader.Id() {
+		t.Fatalf("Expected leader %s, got %s", leader.Id(), st.Leader)
+	}
+	if len(st.Peers) != 2 {
+		t.Fatalf("Expected 2 peers, got %v", st.Peers)
+	}
+	if st.LastElection == "" {
+		t.Fatal("Expected the last election time to be set")
+	}
+
+	rec = httptest.NewRecorder()
+	follower.StatusHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/status", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Expected status 405, got %d", rec.Code)
+	}
+}
+
+func TestDebugDump(t *testing.T) {
+	ci := ClusterInfo{Name: "debug", Size: 3}
+	hand, rpc, log := genNodeArgs(t)
+	clock := NewFakeClock(time.Unix(0, 0))
+	timeout := FixedTimeout(MIN_ELECTION_TIMEOUT)
+	node, err := New(ci, hand, rpc, log, WithClock(clock), WithTimeoutStrategy(timeout))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+
+	leader := fakeNode("leader")
+	leader.HeartBeatResponses = make(chan *pb.HeartbeatResponse, 1)
+	mockRegisterPeer(leader)
+	defer mockUnregisterPeer(leader.id)
+
+	// A known state: following a LEADER at term 4, with a failing peer.
+	node.HeartBeats <- &pb.Heartbeat{Term: 4, Leader: leader.id}
+	<-leader.HeartBeatResponses
+	errDown := errors.New("peer is down")
+	node.ReportPeerError("down", errDown)
+	clock.Advance(100 * time.Millisecond)
+
+	info := node.DebugDump()
+	if info.Id != node.Id() || info.Cluster != ci.Name || info.ClusterSize != ci.Size {
+		t.Fatalf("Unexpected identity in %+v", info)
+	}
+	if info.State != FOLLOWER.String() || info.Term != 4 || info.Leader != leader.id || info.Vote != NO_VOTE {
+		t.Fatalf("Expected to follow %q at term 4, got %+v", leader.id, info)
+	}
+	if info.ElectedAt == nil || !info.ElectedAt.Equal(time.Unix(0, 0)) {
+		t.Fatalf("Expected the leader to be known since the start, got %v", info.ElectedAt)
+	}
+	if remaining := MIN_ELECTION_TIMEOUT - 100*time.Millisecond; info.ElectionTimerRemaining != remaining {
+		t.Fatalf("Expected %v before the election timeout, got %v", remaining, info.ElectionTimerRemaining)
+	}
+	peer, ok := info.Peers["down"]
+	if !ok || peer.LastError != errDown.Error() || peer.LastErrorAt == nil || !peer.LastErrorAt.Equal(time.Unix(0, 0)) {
+		t.Fatalf("Expected the error of the peer, got %+v", info.Peers)
+	}
+	if info.LogPath != log || info.StateFormat != node.StateFormat().String() {
+		t.Fatalf("Unexpected store in %+v", info)
+	}
+
+	// Dumping changes nothing.
+	if again := node.DebugDump(); !reflect.DeepEqual(again, info) {
+		t.Fatalf("Expected the same dump, got %+v and %+v", info, again)
+	}
+
+	// It serializes to JSON.
+	data, err := json.Marshal(info)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	var decoded DebugInfo
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if decoded.Term != info.Term || decoded.Peers["down"].LastError != errDown.Error() {
+		t.Fatalf("Expected the dump to round trip, got %s", data)
+	}
+}
+
+func TestDebugDumpDuringElections(t *testing.T) {
+	ci := ClusterInfo{Name: "debug_elections", Size: 3}
+	hub := NewMockHub()
+	nodes := make([]*Node, 3)
+	for i := range nodes {
+		hand, _, log := genNodeArgs(t)
+		node, err := New(ci, hand, hub.NewRpc(), log)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		defer node.Close()
+		nodes[i] = node
+	}
+
+	// Snapshots are taken while the loops change terms, votes and
+	// leaders, which the race detector checks.
+	deadline := time.Now().Add(200 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		for _, n := range nodes {
+			n.DebugDump()
+		}
+		if leader := findLeader(nodes); leader != nil {
+			leader.Pause()
+			leader.Resume()
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
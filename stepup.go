@@ -0,0 +1,89 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+import (
+	"context"
+)
+
+// StepUp makes a FOLLOWER campaign right away, e.g. to choose the LEADER
+// in tests and tools, and returns once it is LEADER. The campaign is a
+// regular election: it only succeeds with the votes of a majority. It
+// returns ErrLostElection if the node went back to FOLLOWER, and the
+// error of ctx if it is done first, e.g. while the node keeps
+// campaigning after split votes. A CANDIDATE is waited for, and a LEADER
+// returns right away. A witness, an observer, a node whose handler
+// vetoes its leadership, one that can not safely campaign yet after
+// losing its state, or one using WithExternalLeadership returns
+// ErrCannotLead, also when that is only found once the campaign was
+// requested.
+func (n *Node) StepUp(ctx context.Context) error {
+	if n.opts.externalLeadership || !n.canBecomeLeader() {
+		return ErrCannotLead
+	}
+	requested := false
+	for {
+		n.mu.Lock()
+		state, changed := n.state, n.nextStateChange()
+		n.mu.Unlock()
+
+		switch state {
+		case LEADER:
+			return nil
+		case CLOSED:
+			return ErrNodeClosed
+		case PAUSED:
+			return ErrNodePaused
+		case GAVE_UP:
+			return ErrGaveUp
+		case FOLLOWER:
+			if requested {
+				return ErrLostElection
+			}
+			if n.isCatchingUp() || !n.recovered() {
+				return ErrCannotLead
+			}
+			s := make(chan error, 1)
+			select {
+			case n.stepUp <- s:
+				if err := <-s; err != nil {
+					return err
+				}
+				requested = true
+			case <-changed:
+			case <-n.closing:
+				return ErrNodeClosed
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		case CANDIDATE:
+			select {
+			case <-changed:
+			case <-n.closing:
+				return ErrNodeClosed
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// nextStateChange returns a channel closed at our next state change.
+// Lock should be held.
+func (n *Node) nextStateChange() <-chan struct{} {
+	if n.stateChanged == nil {
+		n.stateChanged = make(chan struct{})
+	}
+	return n.stateChanged
+}
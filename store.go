@@ -0,0 +1,144 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// renameFile renames a file. Tests replace it to simulate failures.
+var renameFile = os.Rename
+
+// writeFileAtomic replaces the content of the file at path with data, so
+// a crash leaves either the previous or the new content: data is written
+// to a temporary file next to path, synced, and renamed over it, and the
+// directory is synced for the rename to survive a crash. The file keeps
+// its permissions.
+//
+// A rename can not cross filesystems, e.g. when path is a file bind
+// mounted in a container. On EXDEV it falls back to rewriting path in
+// place and syncing it, and returns degraded. A crash can then leave
+// path torn, which the digest of its content detects.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) (degraded bool, err error) {
+	// Fail as writing in place would, e.g. on a read-only file, and
+	// keep its permissions.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, perm)
+	if err != nil {
+		return false, err
+	}
+	fi, err := f.Stat()
+	f.Close()
+	if err != nil {
+		return false, err
+	}
+	perm = fi.Mode().Perm()
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return false, err
+	}
+	// Nothing is left to remove once renamed.
+	defer os.Remove(tmp.Name())
+	if err := tmp.Close(); err != nil {
+		return false, err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return false, err
+	}
+	if err := writeFileSync(tmp.Name(), data, perm); err != nil {
+		return false, err
+	}
+	err = renameFile(tmp.Name(), path)
+	if err == nil {
+		// The rename is only durable once its directory is synced.
+		return false, syncDir(filepath.Dir(path))
+	}
+	if !errors.Is(err, syscall.EXDEV) {
+		return false, err
+	}
+	return true, writeFileSync(path, data, perm)
+}
+
+// syncDir syncs the directory at path to disk, with the entries renamed
+// in it.
+func syncDir(path string) error {
+	d, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+// writeFileSync writes data to the file at path and syncs it to disk.
+func writeFileSync(path string, data []byte, perm os.FileMode) error {
+	if err := writeFile(path, data, perm); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeWithRetry writes data atomically to the log at path, retrying
+// the transient errors as configured with WithWriteRetry. The retries
+// block our loop, so they wait at most half the shortest election
+// timeout in total, and stop once we are closing.
+func (n *Node) writeWithRetry(path string, data []byte) (degraded bool, err error) {
+	backoff := n.opts.writeBackoff
+	var budget time.Duration
+	for retry := 0; ; retry++ {
+		degraded, err = writeFileAtomic(path, data, 0660)
+		if err == nil || retry >= n.opts.writeRetries || !transientWriteError(err) {
+			return degraded, err
+		}
+		if retry == 0 {
+			budget = minElectionTimeout(n.opts.timeouts) / 2
+		}
+		if budget <= 0 {
+			return degraded, err
+		}
+		wait := min(backoff, budget)
+		budget -= wait
+		n.opts.metrics.IncrCounter(METRIC_STATE_SAVE_RETRIES, 1)
+		timer := n.opts.clock.NewTimer(wait)
+		select {
+		case <-timer.C():
+		case <-n.closing:
+			timer.Stop()
+			return degraded, err
+		}
+		backoff *= 2
+	}
+}
+
+// transientWriteError returns whether err may not happen again if the
+// write is retried, e.g. EINTR or EAGAIN.
+func transientWriteError(err error) bool {
+	var t interface{ Temporary() bool }
+	return errors.As(err, &t) && t.Temporary()
+}
@@ -0,0 +1,130 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"math"
+	mrand "math/rand"
+	"time"
+)
+
+// A TimeoutStrategy selects the election timeouts of a node.
+type TimeoutStrategy interface {
+	// NextElectionTimeout returns the next election timeout. The attempt
+	// is the number of consecutive elections started by this node that
+	// failed to elect a leader. It is 0 while a leader is known.
+	NextElectionTimeout(attempt int) time.Duration
+}
+
+// UniformTimeout picks election timeouts uniformly at random between
+// Min and Max. This is the default strategy, using MIN_ELECTION_TIMEOUT
+// and MAX_ELECTION_TIMEOUT.
+type UniformTimeout struct {
+	Min, Max time.Duration
+}
+
+// NextElectionTimeout implements TimeoutStrategy.
+func (u UniformTimeout) NextElectionTimeout(attempt int) time.Duration {
+	return uniformTimeout(u.Min, u.Max)
+}
+
+// FixedTimeout always uses the same election timeout. Without any
+// randomness split votes are likely, so it should only be used when the
+// timeouts differ per node.
+type FixedTimeout time.Duration
+
+// NextElectionTimeout implements TimeoutStrategy.
+func (f FixedTimeout) NextElectionTimeout(attempt int) time.Duration {
+	return time.Duration(f)
+}
+
+// ExponentialTimeout picks election timeouts at random between Min and
+// Max, doubling both bounds for each failed election attempt, up to Cap.
+// Backing off helps highly contended clusters converge on a leader. A
+// zero Cap defaults to 8 times Max.
+type ExponentialTimeout struct {
+	Min, Max, Cap time.Duration
+}
+
+// NextElectionTimeout implements TimeoutStrategy.
+func (e ExponentialTimeout) NextElectionTimeout(attempt int) time.Duration {
+	limit := e.Cap
+	if limit <= 0 {
+		limit = 8 * e.Max
+	}
+	min, max := e.Min, e.Max
+	for i := 0; i < attempt && max < limit; i++ {
+		min, max = 2*min, 2*max
+	}
+	if max > limit {
+		max = limit
+	}
+	if min > max {
+		min = max
+	}
+	return uniformTimeout(min, max)
+}
+
+// affinityTimeout picks election timeouts between min and max according
+// to the affinity of a node for leading its cluster, with some jitter to
+// break ties. A higher affinity gives shorter timeouts.
+type affinityTimeout struct {
+	min, max time.Duration
+	affinity float64
+}
+
+// NextElectionTimeout implements TimeoutStrategy.
+func (a affinityTimeout) NextElectionTimeout(attempt int) time.Duration {
+	span := a.max - a.min
+	base := a.min + time.Duration((1-a.affinity)*float64(span))
+	return base + uniformTimeout(0, span/20)
+}
+
+// leaderAffinity returns the affinity, between 0 and 1, of the node id
+// for leading the named cluster.
+func leaderAffinity(id, cluster string) float64 {
+	sum := sha1.Sum([]byte(id + "\x00" + cluster))
+	return float64(binary.BigEndian.Uint64(sum[:8])) / math.MaxUint64
+}
+
+// minElectionTimeout returns the shortest election timeout ts picks
+// while a leader is known. For a strategy of its own, it is the shortest
+// of a few timeouts picked.
+func minElectionTimeout(ts TimeoutStrategy) time.Duration {
+	switch t := ts.(type) {
+	case UniformTimeout:
+		return t.Min
+	case FixedTimeout:
+		return time.Duration(t)
+	case ExponentialTimeout:
+		return t.Min
+	case affinityTimeout:
+		return t.min
+	}
+	shortest := ts.NextElectionTimeout(0)
+	for i := 0; i < 8; i++ {
+		shortest = min(shortest, ts.NextElectionTimeout(0))
+	}
+	return shortest
+}
+
+// Generate a random timeout between min and max.
+func uniformTimeout(min, max time.Duration) time.Duration {
+	if max <= min {
+		return min
+	}
+	return min + time.Duration(mrand.Int63n(int64(max-min)))
+}
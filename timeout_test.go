@@ -0,0 +1,249 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUniformTimeout(t *testing.T) {
+	ts := UniformTimeout{Min: 10 * time.Millisecond, Max: 20 * time.Millisecond}
+	for i := 0; i < 100; i++ {
+		if et := ts.NextElectionTimeout(i); et < ts.Min || et >= ts.Max {
+			t.Fatalf("Expected timeout between %v-%v, got %v", ts.Min, ts.Max, et)
+		}
+	}
+}
+
+func TestFixedTimeout(t *testing.T) {
+	ts := FixedTimeout(15 * time.Millisecond)
+	for i := 0; i < 5; i++ {
+		if et := ts.NextElectionTimeout(i); et != 15*time.Millisecond {
+			t.Fatalf("Expected timeout of %v, got %v", time.Duration(ts), et)
+		}
+	}
+}
+
+func TestExponentialTimeout(t *testing.T) {
+	min, max := 10*time.Millisecond, 20*time.Millisecond
+	ts := ExponentialTimeout{Min: min, Max: max, Cap: 100 * time.Millisecond}
+
+	type test struct {
+		attempt  int
+		min, max time.Duration
+	}
+	tests := []test{
+		{0, min, max},
+		{1, 2 * min, 2 * max},
+		{2, 4 * min, 4 * max},
+		// Capped
+		{3, 8 * min, ts.Cap},
+		{10, 8 * min, ts.Cap},
+	}
+	for _, tc := range tests {
+		for i := 0; i < 100; i++ {
+			if et := ts.NextElectionTimeout(tc.attempt); et < tc.min || et > tc.max {
+				t.Fatalf("Attempt %d: expected timeout between %v-%v, got %v",
+					tc.attempt, tc.min, tc.max, et)
+			}
+		}
+	}
+
+	// Default cap
+	ts.Cap = 0
+	if et := ts.NextElectionTimeout(100); et > 8*max {
+		t.Fatalf("Expected timeout to be capped at %v, got %v", 8*max, et)
+	}
+}
+
+func TestHeartbeatInterval(t *testing.T) {
+	hb := 10 * time.Millisecond
+	o := defaultOptions()
+	if err := WithHeartbeatInterval(hb, 10, 20)(&o); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if o.heartbeat != hb {
+		t.Fatalf("Expected heartbeat interval of %v, got %v", hb, o.heartbeat)
+	}
+	for i := 0; i < 100; i++ {
+		if et := o.timeouts.NextElectionTimeout(0); et < 10*hb || et >= 20*hb {
+			t.Fatalf("Expected timeout between %v-%v, got %v", 10*hb, 20*hb, et)
+		}
+	}
+
+	// Unsafe ratios are rejected.
+	for _, mult := range [][2]float64{{1, 2}, {1.5, 20}, {10, 5}} {
+		if err := WithHeartbeatInterval(hb, mult[0], mult[1])(&o); err != ErrInvalidOption {
+			t.Fatalf("Expected %v for %v, got: %v", ErrInvalidOption, mult, err)
+		}
+	}
+	if err := WithHeartbeatInterval(0, 10, 20)(&o); err != ErrInvalidOption {
+		t.Fatalf("Expected %v, got: %v", ErrInvalidOption, err)
+	}
+}
+
+// recordingTimeout records the attempts it is asked about.
+type recordingTimeout struct {
+	mu       sync.Mutex
+	attempts []int
+}
+
+func (r *recordingTimeout) NextElectionTimeout(attempt int) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.attempts = append(r.attempts, attempt)
+	return 10 * time.Millisecond
+}
+
+func (r *recordingTimeout) maxAttempt() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	max := 0
+	for _, a := range r.attempts {
+		if a > max {
+			max = a
+		}
+	}
+	return max
+}
+
+func TestCustomTimeoutStrategy(t *testing.T) {
+	if _, err := New(ClusterInfo{Name: "foo", Size: 3}, &dummyHandler{}, NewMockRpc(), "log",
+		WithTimeoutStrategy(nil)); err != ErrInvalidOption {
+		t.Fatalf("Expected %v, got: %v", ErrInvalidOption, err)
+	}
+
+	// No peers will answer, so each election fails.
+	ci := ClusterInfo{Name: "foo", Size: 3}
+	hand, rpc, log := genNodeArgs(t)
+	ts := &recordingTimeout{}
+	node, err := New(ci, hand, rpc, log, WithTimeoutStrategy(ts))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+
+	// With 10ms timeouts we will quickly go through several elections,
+	// well before the default timeouts would have expired once.
+	time.Sleep(MIN_ELECTION_TIMEOUT / 2)
+	if state := node.State(); state != CANDIDATE {
+		t.Fatalf("Expected node to be in Candidate state, got: %s", state)
+	}
+	if term := node.CurrentTerm(); term < 3 {
+		t.Fatalf("Expected several elections, got term %d", term)
+	}
+	if a := ts.maxAttempt(); a < 2 {
+		t.Fatalf("Expected the failed attempts to be counted, got %d", a)
+	}
+}
+
+func TestMinElectionTimeout(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		ts  TimeoutStrategy
+		min time.Duration
+	}{
+		{UniformTimeout{Min: 10 * ms, Max: 20 * ms}, 10 * ms},
+		{FixedTimeout(15 * ms), 15 * ms},
+		{ExponentialTimeout{Min: 30 * ms, Max: 60 * ms}, 30 * ms},
+		{affinityTimeout{min: 40 * ms, max: 80 * ms, affinity: 0.5}, 40 * ms},
+		{&recordingTimeout{}, 10 * ms},
+	}
+	for _, tc := range tests {
+		if m := minElectionTimeout(tc.ts); m != tc.min {
+			t.Fatalf("Expected %v for %T, got %v", tc.min, tc.ts, m)
+		}
+	}
+}
+
+func TestLeaderAffinity(t *testing.T) {
+	ids := []string{"n1", "n2", "n3"}
+	hand, rpc, log := genNodeArgs(t)
+	if _, err := New(ClusterInfo{Name: "a", Size: 3}, hand, rpc, log,
+		WithTimeoutStrategy(FixedTimeout(time.Second)), WithLeaderAffinity()); err != ErrInvalidOption {
+		t.Fatalf("Expected %v, got: %v", ErrInvalidOption, err)
+	}
+
+	led := make(map[string]int)
+	for i := 0; i < 24; i++ {
+		ci := ClusterInfo{Name: fmt.Sprintf("shard-%d", i), Size: len(ids)}
+		nodes := make([]*Node, len(ids))
+		for j, id := range ids {
+			hand, rpc, log := genNodeArgs(t)
+			node, err := New(ci, hand, rpc, log, WithId(id),
+				WithHeartbeatInterval(5*time.Millisecond, 10, 20), WithLeaderAffinity())
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			nodes[j] = node
+		}
+		expectedClusterState(t, nodes, 1, len(ids)-1, 0)
+		led[findLeader(nodes).Id()]++
+		for _, node := range nodes {
+			node.Close()
+		}
+	}
+
+	// Every node leads some of the clusters.
+	for _, id := range ids {
+		if led[id] == 0 {
+			t.Fatalf("Expected %s to lead some clusters, got %v", id, led)
+		}
+	}
+}
+
+func TestCampaignJitter(t *testing.T) {
+	hb := 5 * time.Millisecond
+	opts := []Option{
+		WithHeartbeatInterval(hb, 10, 20),
+		// The herd times out at once.
+		WithTimeoutStrategy(FixedTimeout(10 * hb)),
+		WithCampaignJitter(5 * hb),
+	}
+	ci := ClusterInfo{Name: "herd", Size: 5}
+	hand, rpc, log := genNodeArgs(t)
+	if _, err := New(ci, hand, rpc, log, WithCampaignJitter(6*HEARTBEAT_INTERVAL)); err != ErrInvalidOption {
+		t.Fatalf("Expected %v, got: %v", ErrInvalidOption, err)
+	}
+
+	nodes := make([]*Node, ci.Size)
+	for i := range nodes {
+		hand, rpc, log := genNodeArgs(t)
+		node, err := New(ci, hand, rpc, log, opts...)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		defer node.Close()
+		nodes[i] = node
+	}
+	expectedClusterState(t, nodes, 1, len(nodes)-1, 0)
+	leader := findLeader(nodes)
+	term := leader.CurrentTerm()
+
+	// The followers lose their LEADER at once.
+	leader.Close()
+	var followers []*Node
+	for _, n := range nodes {
+		if n != leader {
+			followers = append(followers, n)
+		}
+	}
+	expectedClusterState(t, followers, 1, len(followers)-1, 0)
+	if rounds := findLeader(followers).CurrentTerm() - term; rounds > 3 {
+		t.Fatalf("Expected to converge in few rounds, took %d", rounds)
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+import (
+	"sync/atomic"
+)
+
+// traceEvent is an event whose order matters for safety, e.g. the state
+// must be written before a vote is sent, or a crash in between could
+// lead to voting twice in a term.
+type traceEvent int8
+
+const (
+	traceStateWritten traceEvent = iota
+	traceVoteRequested
+	traceVoteGranted
+)
+
+// traceFunc records a traceEvent of a node, with the term it applies to.
+type traceFunc func(n *Node, ev traceEvent, term uint64)
+
+// traceHook is only set by tests.
+var traceHook atomic.Pointer[traceFunc]
+
+// trace records the event if a traceHook is set.
+func (n *Node) trace(ev traceEvent, term uint64) {
+	if hook := traceHook.Load(); hook != nil {
+		(*hook)(n, ev, term)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+import (
+	"context"
+)
+
+// handoffReq asks the LEADER's loop to hand its leadership off to peer,
+// or to withdraw a handoff.
+type handoffReq struct {
+	peer     string
+	withdraw bool
+	done     chan error
+}
+
+// TransferLeadership makes a LEADER hand its leadership off to peer, e.g.
+// before a deploy, so the cluster does not wait for an election timeout
+// to replace it. Our heartbeats ask peer to campaign right away, and it
+// wins as any candidate does, with the votes of a majority. An empty peer
+// picks the one that most recently acknowledged our heartbeats, which
+// requires an RPCDriver implementing HeartbeatResponder. It returns once
+// we stepped down, ErrNotLeader if we are not LEADER, ErrNoSuccessor
+// without a peer to pick, and the error of ctx if it is done first, in
+// which case we are still LEADER and stop asking peer to campaign.
+func (n *Node) TransferLeadership(ctx context.Context, peer string) error {
+	n.mu.Lock()
+	state, changed := n.state, n.nextStateChange()
+	n.mu.Unlock()
+	if state != LEADER {
+		return ErrNotLeader
+	}
+
+	req := &handoffReq{peer: peer, done: make(chan error, 1)}
+	select {
+	case n.handoff <- req:
+	case <-changed:
+		return ErrNotLeader
+	case <-n.closing:
+		return ErrNodeClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if err := <-req.done; err != nil {
+		return err
+	}
+
+	select {
+	case <-changed:
+		return nil
+	case <-n.closing:
+		return ErrNodeClosed
+	case <-ctx.Done():
+		// Withdraw the handoff if we are still LEADER.
+		select {
+		case n.handoff <- &handoffReq{withdraw: true, done: make(chan error, 1)}:
+		case <-changed:
+		case <-n.closing:
+		}
+		return ctx.Err()
+	}
+}
+
+// successor returns the peer a LEADER hands its leadership off to when
+// none is given: the one that acknowledged our heartbeats last.
+func (n *Node) successor() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var peer string
+	latest := n.peerCutoff(n.opts.clock.Now())
+	for p, pl := range n.latencies {
+		if p != n.id && pl.at.After(latest) {
+			peer, latest = p, pl.at
+		}
+	}
+	return peer
+}
+
+// canSucceed returns whether we can campaign when a LEADER hands its
+// leadership off to us, as StepUp would.
+func (n *Node) canSucceed() bool {
+	return !n.opts.externalLeadership && !n.isCatchingUp() && n.recovered() && n.canBecomeLeader()
+}
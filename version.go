@@ -0,0 +1,70 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// peerVersion is the protocol version a peer advertised, and when.
+type peerVersion struct {
+	version uint32
+	at      time.Time
+}
+
+// IncompatiblePeers returns the protocol version advertised by each peer
+// older than the minimum set with WithProtocolVersion, e.g. to spot a
+// botched rolling upgrade. Peers are pruned as described in
+// WithPeerStateRetention.
+func (n *Node) IncompatiblePeers() map[string]uint32 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	cutoff := n.peerCutoff(n.opts.clock.Now())
+	peers := make(map[string]uint32, len(n.incompatible))
+	for peer, pv := range n.incompatible {
+		if pv.at.After(cutoff) {
+			peers[peer] = pv.version
+		}
+	}
+	return peers
+}
+
+// compatiblePeer returns whether the protocol version a peer advertised
+// is compatible with ours. The handler is notified with
+// ErrIncompatiblePeer the first time a peer is found incompatible.
+func (n *Node) compatiblePeer(peer string, version uint32) bool {
+	if version >= n.opts.minProtocolVersion {
+		return true
+	}
+	n.mu.Lock()
+	now := n.opts.clock.Now()
+	if n.incompatible == nil {
+		n.incompatible = make(map[string]peerVersion)
+	}
+	pv, seen := n.incompatible[peer]
+	seen = seen && pv.version == version && pv.at.After(n.peerCutoff(now))
+	n.incompatible[peer] = peerVersion{version: version, at: now}
+	prunePeers(n.incompatible, n.peerCutoff(now), func(pv peerVersion) time.Time { return pv.at })
+	n.mu.Unlock()
+
+	if !seen {
+		n.opts.metrics.IncrCounter(METRIC_INCOMPATIBLE_PEERS, 1,
+			Label{Name: "peer", Value: peer}, Label{Name: "version", Value: strconv.FormatUint(uint64(version), 10)})
+		n.handleError(fmt.Errorf("%w: %q at version %d, expected at least %d",
+			ErrIncompatiblePeer, peer, version, n.opts.minProtocolVersion))
+	}
+	return false
+}
@@ -14,8 +14,13 @@
 package graft
 
 import (
+	"cmp"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"os"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
@@ -60,9 +65,9 @@ func fakeNode(name string) *Node {
 
 func TestQuorum(t *testing.T) {
 	type test struct{ cluster, quorum int }
-	tests := []test{{0, 0}, {1, 1}, {2, 2}, {3, 2}, {4, 3}, {9, 5}, {12, 7}}
+	tests := []test{{0, 0}, {1, 1}, {2, 2}, {3, 2}, {4, 3}, {5, 3}, {6, 4}, {7, 4}, {9, 5}, {12, 7}}
 	for _, tc := range tests {
-		if q := quorumNeeded(tc.cluster); q != tc.quorum {
+		if q := Quorum(tc.cluster); q != tc.quorum {
 			t.Fatalf("Expected quorum size of %d with cluster size %d, got %d\n",
 				tc.quorum, tc.cluster, q)
 		}
@@ -520,3 +525,847 @@ func TestVoteRequestAsLeaderStepsDownDueToErrorOnSave(t *testing.T) {
 		t.Fatalf("Expected Node to be in Follower state, got: %s", state)
 	}
 }
+
+func TestMaxInflightVoteRequests(t *testing.T) {
+	const numPeers = 20
+	const maxInflight = 3
+
+	ci := ClusterInfo{Name: "waves", Size: numPeers + 1}
+	hand, rpc, log := genNodeArgs(t)
+	if _, err := New(ci, hand, &noResponseRpc{NewMockRpc()}, log, WithMaxInflightVoteRequests(maxInflight)); err != ErrPeerVoteRequesterReq {
+		t.Fatalf("Expected %v, got: %v", ErrPeerVoteRequesterReq, err)
+	}
+	if _, err := New(ci, hand, rpc, log, WithMaxInflightVoteRequests(0)); err != ErrInvalidOption {
+		t.Fatalf("Expected %v, got: %v", ErrInvalidOption, err)
+	}
+
+	node, err := New(ci, hand, rpc, log, WithMaxInflightVoteRequests(maxInflight))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+
+	fakes := make([]*Node, numPeers)
+	for i := range fakes {
+		fakes[i] = fakeNode(fmt.Sprintf("fake%d", i))
+		mockRegisterPeer(fakes[i])
+		defer mockUnregisterPeer(fakes[i].id)
+	}
+
+	node.mu.Lock()
+	node.electTimer.Reset(time.Millisecond)
+	node.mu.Unlock()
+
+	// Deny every vote so the campaign reaches all the peers, and check
+	// the requests awaiting a response never exceed the bound.
+	requested := make(map[string]bool)
+	var term uint64
+	deadline := time.Now().Add(MIN_ELECTION_TIMEOUT)
+	for len(requested) < numPeers && time.Now().Before(deadline) {
+		var pending []*pb.VoteRequest
+		for _, fake := range fakes {
+			select {
+			case vreq := <-fake.VoteRequests:
+				if term == 0 {
+					term = vreq.Term
+				}
+				if vreq.Term != term || requested[fake.id] {
+					t.Fatalf("Expected a single request per peer for term %d", term)
+				}
+				requested[fake.id] = true
+				pending = append(pending, vreq)
+			default:
+			}
+		}
+		if len(pending) > maxInflight {
+			t.Fatalf("Expected at most %d requests in flight, got %d", maxInflight, len(pending))
+		}
+		time.Sleep(5 * time.Millisecond)
+		for _, vreq := range pending {
+			node.VoteResponses <- &pb.VoteResponse{Term: vreq.Term, Granted: false}
+		}
+	}
+	if len(requested) != numPeers {
+		t.Fatalf("Expected all %d peers to be asked for their vote, got %d", numPeers, len(requested))
+	}
+}
+
+func TestLostStateGuard(t *testing.T) {
+	ci := ClusterInfo{Name: "wiped", Size: 3}
+	hand, rpc, log := genNodeArgs(t)
+	if _, err := New(ci, hand, rpc, log, WithLostStateGuard()); err != ErrInvalidOption {
+		t.Fatalf("Expected %v, got: %v", ErrInvalidOption, err)
+	}
+
+	// The log is empty, as after a wiped disk.
+	node, err := New(ci, hand, rpc, log, WithId("node1"), WithLostStateGuard())
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+	if id := node.Id(); id != "node1" {
+		t.Fatalf("Expected id node1, got %s", id)
+	}
+
+	fake := fakeNode("fake")
+	fake.VoteResponses = make(chan *pb.VoteResponse, 1)
+	mockRegisterPeer(fake)
+	defer mockUnregisterPeer(fake.id)
+
+	// We should not campaign.
+	node.mu.Lock()
+	node.electTimer.Reset(time.Millisecond)
+	node.mu.Unlock()
+	select {
+	case <-fake.VoteRequests:
+		t.Fatal("Expected no campaign before catching up")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	expectVote := func(term uint64, granted bool) {
+		t.Helper()
+		node.VoteRequests <- &pb.VoteRequest{Term: term, Candidate: fake.id}
+		vresp := <-fake.VoteResponses
+		if vresp.Granted != granted {
+			t.Fatalf("Expected vote for term %d to be granted=%v", term, granted)
+		}
+	}
+
+	// No vote before hearing the current term.
+	expectVote(3, false)
+
+	// The heartbeat of an older term does not tell the current one.
+	node.setTerm(4)
+	node.HeartBeats <- &pb.Heartbeat{Term: 2, Leader: "old"}
+	expectVote(3, false)
+	if !node.isCatchingUp() {
+		t.Fatal("Expected Node to still be catching up")
+	}
+
+	// The cluster is at term 5.
+	node.HeartBeats <- &pb.Heartbeat{Term: 5, Leader: "leader"}
+	if leader := waitForLeader(node, "leader"); leader != "leader" {
+		t.Fatalf("Expected leader to be set, got %q", leader)
+	}
+	if term := node.CurrentTerm(); term != 5 {
+		t.Fatalf("Expected term 5, got %d", term)
+	}
+
+	// We may have voted up to term 5 before losing our state.
+	expectVote(5, false)
+	expectVote(6, true)
+}
+
+func TestMinElectionPeers(t *testing.T) {
+	// A misconfigured cluster of size 1 would elect itself.
+	ci := ClusterInfo{Name: "minpeers", Size: 1}
+	hand, rpc, log := genNodeArgs(t)
+	if _, err := New(ci, hand, rpc, log, WithMinElectionPeers(-1)); err != ErrInvalidOption {
+		t.Fatalf("Expected %v, got: %v", ErrInvalidOption, err)
+	}
+	node, err := New(ci, hand, rpc, log, WithMinElectionPeers(1))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+
+	fake := fakeNode("fake")
+	mockRegisterPeer(fake)
+	defer mockUnregisterPeer(fake.id)
+
+	node.mu.Lock()
+	node.electTimer.Reset(time.Millisecond)
+	node.mu.Unlock()
+
+	// Without an answer from a peer we can not win.
+	vreq := <-fake.VoteRequests
+	time.Sleep(50 * time.Millisecond)
+	if state := node.State(); state != CANDIDATE {
+		t.Fatalf("Expected Node to stay a Candidate, got: %s", state)
+	}
+
+	// An anonymous answer does not count.
+	node.VoteResponses <- &pb.VoteResponse{Term: vreq.Term, Granted: true}
+	time.Sleep(50 * time.Millisecond)
+	if state := node.State(); state != CANDIDATE {
+		t.Fatalf("Expected Node to stay a Candidate, got: %s", state)
+	}
+
+	// Once the peer answered, we win.
+	node.VoteResponses <- &pb.VoteResponse{Term: vreq.Term, Granted: false, Voter: fake.id}
+	if state := waitForState(node, LEADER); state != LEADER {
+		t.Fatalf("Expected Node to be in Leader state, got: %s", state)
+	}
+}
+
+func TestVoteRecord(t *testing.T) {
+	node := vreqNode(t, 3)
+	defer node.Close()
+
+	if term, vote, at := node.VoteRecord(); term != 0 || vote != NO_VOTE || !at.IsZero() {
+		t.Fatalf("Expected no vote record, got %d, %q, %v", term, vote, at)
+	}
+
+	fake := fakeNode("fake")
+	mockRegisterPeer(fake)
+	defer mockUnregisterPeer(fake.id)
+
+	before := time.Now()
+	node.VoteRequests <- &pb.VoteRequest{Term: 5, Candidate: fake.id}
+	if vresp := <-fake.VoteResponses; !vresp.Granted {
+		t.Fatal("Expected the vote to be granted")
+	}
+	term, vote, at := node.VoteRecord()
+	if term != 5 || vote != fake.id {
+		t.Fatalf("Expected a vote for %s in term 5, got %q in term %d", fake.id, vote, term)
+	}
+	if at.Before(before) || at.After(time.Now()) {
+		t.Fatalf("Expected a recent vote time, got %v", at)
+	}
+}
+
+func TestVoters(t *testing.T) {
+	node := vreqNode(t, 5)
+	defer node.Close()
+
+	if voters := node.Voters(); voters != nil {
+		t.Fatalf("Expected no voters as Follower, got %v", voters)
+	}
+
+	fakes := []*Node{fakeNode("fake1"), fakeNode("fake2"), fakeNode("fake3")}
+	for _, fake := range fakes {
+		mockRegisterPeer(fake)
+		defer mockUnregisterPeer(fake.id)
+	}
+
+	node.mu.Lock()
+	node.electTimer.Reset(time.Millisecond)
+	node.mu.Unlock()
+
+	var vreq *pb.VoteRequest
+	for _, fake := range fakes {
+		vreq = <-fake.VoteRequests
+	}
+	node.VoteResponses <- &pb.VoteResponse{Term: vreq.Term, Granted: true, Voter: "fake2"}
+	node.VoteResponses <- &pb.VoteResponse{Term: vreq.Term, Granted: false, Voter: "fake3"}
+	node.VoteResponses <- &pb.VoteResponse{Term: vreq.Term, Granted: true, Voter: "fake1"}
+	if state := waitForState(node, LEADER); state != LEADER {
+		t.Fatalf("Expected Node to be in Leader state, got: %s", state)
+	}
+	if voters := node.Voters(); !slices.Equal(voters, []string{"fake2", "fake1"}) {
+		t.Fatalf("Expected voters [fake2 fake1], got %v", voters)
+	}
+}
+
+func TestLeaderOnMajority(t *testing.T) {
+	ci := ClusterInfo{Name: "majority", Size: 5}
+	scCh := make(chan StateChange, 8)
+	_, rpc, log := genNodeArgs(t)
+	node, err := New(ci, NewChanHandler(scCh, make(chan error, 1)), rpc, log,
+		WithTimeoutStrategy(FixedTimeout(time.Second)))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+
+	fakes := make([]*Node, 4)
+	for i := range fakes {
+		fakes[i] = fakeNode(fmt.Sprintf("fake%d", i))
+		mockRegisterPeer(fakes[i])
+		defer mockUnregisterPeer(fakes[i].id)
+	}
+
+	node.mu.Lock()
+	node.electTimer.Reset(time.Millisecond)
+	node.mu.Unlock()
+	var vreq *pb.VoteRequest
+	for _, fake := range fakes {
+		vreq = <-fake.VoteRequests
+	}
+
+	// Two grants and our own vote are a majority of 5, the other
+	// peers never answer.
+	start := time.Now()
+	for _, fake := range fakes[:2] {
+		node.VoteResponses <- &pb.VoteResponse{Term: vreq.Term, Granted: true, Voter: fake.id}
+	}
+	for {
+		select {
+		case sc := <-scCh:
+			if sc.To != LEADER {
+				continue
+			}
+			if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+				t.Fatalf("Expected the leadership as soon as a majority granted, took %v", elapsed)
+			}
+			return
+		case <-time.After(time.Second):
+			t.Fatal("Expected the node to become LEADER before its election timeout")
+		}
+	}
+}
+
+func TestCandidateTiebreak(t *testing.T) {
+	ci := ClusterInfo{Name: "tiebreak", Size: 3}
+	hand, rpc, log := genNodeArgs(t)
+	node, err := New(ci, hand, rpc, log, WithId("b"),
+		WithTimeoutStrategy(FixedTimeout(time.Second)), WithCandidateTiebreak(cmp.Less[string]))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+
+	// Competing candidates, on either side of our id.
+	preferred, other := fakeNode("a"), fakeNode("c")
+	for _, fake := range []*Node{preferred, other} {
+		mockRegisterPeer(fake)
+		defer mockUnregisterPeer(fake.id)
+	}
+
+	node.mu.Lock()
+	node.electTimer.Reset(time.Millisecond)
+	node.mu.Unlock()
+	if state := waitForState(node, CANDIDATE); state != CANDIDATE {
+		t.Fatalf("Expected Node to be in Candidate state, got: %s", state)
+	}
+	term := node.CurrentTerm()
+
+	// We do not defer to a competitor we do not prefer.
+	node.VoteRequests <- &pb.VoteRequest{Term: term, Candidate: other.id}
+	if vresp := <-other.VoteResponses; vresp.Granted {
+		t.Fatal("Expected the VoteResponse to have Granted of false")
+	}
+	time.Sleep(10 * time.Millisecond)
+	if state := node.State(); state != CANDIDATE {
+		t.Fatalf("Expected Node to be in Candidate state, got: %s", state)
+	}
+
+	// We defer to the preferred one, without changing our vote.
+	node.VoteRequests <- &pb.VoteRequest{Term: term, Candidate: preferred.id}
+	if vresp := <-preferred.VoteResponses; vresp.Granted {
+		t.Fatal("Expected the VoteResponse to have Granted of false")
+	}
+	if state := waitForState(node, FOLLOWER); state != FOLLOWER {
+		t.Fatalf("Expected Node to be in Follower state, got: %s", state)
+	}
+	if vote := node.CurrentVote(); vote != node.id {
+		t.Fatalf("Expected Node to have still cast vote for itself, got: %s", vote)
+	}
+
+	// The preferred one wins its next campaign before ours.
+	node.VoteRequests <- &pb.VoteRequest{Term: term + 1, Candidate: preferred.id}
+	if vresp := <-preferred.VoteResponses; !vresp.Granted {
+		t.Fatal("Expected the VoteResponse to have been Granted")
+	}
+	if vote := node.CurrentVote(); vote != preferred.id {
+		t.Fatalf("Expected a vote for %s, got: %s", preferred.id, vote)
+	}
+}
+
+func TestQuorumPredicate(t *testing.T) {
+	// A quorum must span two zones, the prefix of the ids.
+	zones := func(granted []string) bool {
+		seen := make(map[string]bool)
+		for _, id := range granted {
+			zone, _, _ := strings.Cut(id, "-")
+			seen[zone] = true
+		}
+		return len(seen) >= 2
+	}
+	ci := ClusterInfo{Name: "zones", Size: 3}
+	hand, rpc, log := genNodeArgs(t)
+	node, err := New(ci, hand, rpc, log, WithId("east-1"),
+		WithTimeoutStrategy(FixedTimeout(time.Second)), WithQuorumPredicate(zones))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+
+	east, west := fakeNode("east-2"), fakeNode("west-1")
+	for _, fake := range []*Node{east, west} {
+		mockRegisterPeer(fake)
+		defer mockUnregisterPeer(fake.id)
+	}
+
+	node.mu.Lock()
+	node.electTimer.Reset(time.Millisecond)
+	node.mu.Unlock()
+	vreq := <-east.VoteRequests
+	<-west.VoteRequests
+
+	// A majority from a single zone is not enough.
+	node.VoteResponses <- &pb.VoteResponse{Term: vreq.Term, Granted: true, Voter: east.id}
+	time.Sleep(20 * time.Millisecond)
+	if state := node.State(); state != CANDIDATE {
+		t.Fatalf("Expected Node to be in Candidate state, got: %s", state)
+	}
+
+	// A vote from the other zone wins.
+	node.VoteResponses <- &pb.VoteResponse{Term: vreq.Term, Granted: true, Voter: west.id}
+	if state := waitForState(node, LEADER); state != LEADER {
+		t.Fatalf("Expected Node to be in Leader state, got: %s", state)
+	}
+}
+
+// failingRpc fails the vote responses sent to a peer.
+type failingRpc struct {
+	*MockRpcDriver
+	peer string
+	err  error
+}
+
+func (rpc *failingRpc) SendVoteResponse(candidate string, vresp *pb.VoteResponse) error {
+	if candidate == rpc.peer {
+		return rpc.err
+	}
+	return rpc.MockRpcDriver.SendVoteResponse(candidate, vresp)
+}
+
+func TestLastPeerError(t *testing.T) {
+	ci := ClusterInfo{Name: "peererr", Size: 3}
+	hand, _, log := genNodeArgs(t)
+	errNoResponders := errors.New("no responders")
+	rpc := &failingRpc{MockRpcDriver: NewMockRpc(), peer: "bad", err: errNoResponders}
+	node, err := New(ci, hand, rpc, log)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+	// Delay elections
+	node.mu.Lock()
+	node.electTimer.Reset(10 * time.Second)
+	node.mu.Unlock()
+
+	good := fakeNode("good")
+	mockRegisterPeer(good)
+	defer mockUnregisterPeer(good.id)
+
+	before := time.Now()
+	node.VoteRequests <- &pb.VoteRequest{Term: 1, Candidate: "bad"}
+	node.VoteRequests <- &pb.VoteRequest{Term: 2, Candidate: good.id}
+	<-good.VoteResponses
+
+	// Only the failing peer has an error.
+	err, at := node.LastPeerError("bad")
+	if err != errNoResponders {
+		t.Fatalf("Expected %v, got: %v", errNoResponders, err)
+	}
+	if at.Before(before) {
+		t.Fatalf("Expected the error time after %v, got %v", before, at)
+	}
+	if err, at := node.LastPeerError(good.id); err != nil || !at.IsZero() {
+		t.Fatalf("Expected no error for %s, got: %v at %v", good.id, err, at)
+	}
+}
+
+func TestVoteRequestObserver(t *testing.T) {
+	ci := ClusterInfo{Name: "observer", Size: 3}
+	observed := make(chan *pb.VoteRequest, 1)
+	hand, rpc, log := genNodeArgs(t)
+	node, err := New(ci, hand, rpc, log, WithVoteRequestObserver(observed))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+	// Never read, which must not block its node.
+	hand, rpc, log = genNodeArgs(t)
+	blocked, err := New(ci, hand, rpc, log, WithVoteRequestObserver(make(chan *pb.VoteRequest)))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer blocked.Close()
+	mockBlockLink(node, blocked)
+
+	// Force a campaign.
+	node.setTerm(4)
+	for _, n := range []*Node{node, blocked} {
+		n.mu.Lock()
+		n.electTimer.Reset(time.Millisecond)
+		n.mu.Unlock()
+	}
+	select {
+	case vreq := <-observed:
+		if vreq.Term != 5 || vreq.Candidate != node.Id() {
+			t.Fatalf("Expected a request for term 5 from %s, got %+v", node.Id(), vreq)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the VoteRequest to be observed")
+	}
+	if state := waitForState(blocked, CANDIDATE); state != CANDIDATE {
+		t.Fatalf("Expected Node to be in Candidate state, got: %s", state)
+	}
+
+	if _, err := New(ci, hand, rpc, log, WithVoteRequestObserver(nil)); err != ErrInvalidOption {
+		t.Fatalf("Expected %v, got: %v", ErrInvalidOption, err)
+	}
+}
+
+func TestIncompatibleProtocolVersion(t *testing.T) {
+	ci := ClusterInfo{Name: "version", Size: 3}
+	_, rpc, log := genNodeArgs(t)
+	if _, err := New(ci, &dummyHandler{}, rpc, log, WithProtocolVersion(1, 2)); err != ErrInvalidOption {
+		t.Fatalf("Expected %v, got: %v", ErrInvalidOption, err)
+	}
+	errCh := make(chan error, 4)
+	metrics := &counterMetrics{counters: make(map[string]int64)}
+	node, err := New(ci, NewChanHandler(make(chan StateChange, 4), errCh), rpc, log,
+		WithMetrics(metrics), WithProtocolVersion(2, 2), WithIncompatibleVoteRefusal())
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+	// Delay elections
+	node.mu.Lock()
+	node.electTimer.Reset(10 * time.Second)
+	node.mu.Unlock()
+
+	old := fakeNode("old")
+	mockRegisterPeer(old)
+	defer mockUnregisterPeer(old.id)
+	current := fakeNode("current")
+	mockRegisterPeer(current)
+	defer mockUnregisterPeer(current.id)
+
+	// A candidate at an older version is detected and denied.
+	node.VoteRequests <- &pb.VoteRequest{Term: 1, Candidate: old.id, Version: 1}
+	if vresp := <-old.VoteResponses; vresp.Granted || vresp.Term != 0 {
+		t.Fatalf("Expected the vote to be denied at term 0, got %+v", vresp)
+	}
+	if err := errWait(t, errCh); !errors.Is(err, ErrIncompatiblePeer) {
+		t.Fatalf("Expected %v, got: %v", ErrIncompatiblePeer, err)
+	}
+	if peers := node.IncompatiblePeers(); len(peers) != 1 || peers[old.id] != 1 {
+		t.Fatalf("Expected %q at version 1 to be incompatible, got %v", old.id, peers)
+	}
+
+	// Flagged once.
+	node.VoteRequests <- &pb.VoteRequest{Term: 2, Candidate: old.id, Version: 1}
+	<-old.VoteResponses
+	if c := metrics.counter(METRIC_INCOMPATIBLE_PEERS); c != 1 {
+		t.Fatalf("Expected the incompatible peers counter to be 1, got %d", c)
+	}
+
+	// A compatible candidate gets our vote.
+	node.VoteRequests <- &pb.VoteRequest{Term: 3, Candidate: current.id, Version: 2}
+	if vresp := <-current.VoteResponses; !vresp.Granted {
+		t.Fatalf("Expected the vote to be granted, got %+v", vresp)
+	}
+	select {
+	case err := <-errCh:
+		t.Fatalf("Expected no error, got: %v", err)
+	default:
+	}
+
+	// Our vote requests advertise our version.
+	node.mu.Lock()
+	node.electTimer.Reset(time.Millisecond)
+	node.mu.Unlock()
+	if vreq := <-current.VoteRequests; vreq.Version != 2 {
+		t.Fatalf("Expected the vote request to advertise version 2, got %d", vreq.Version)
+	}
+}
+
+func TestCandidateAbandonsOnHigherTermResponse(t *testing.T) {
+	ci := ClusterInfo{Name: "abandon", Size: 3}
+	_, rpc, log := genNodeArgs(t)
+	scCh := make(chan StateChange, 4)
+	node, err := New(ci, NewChanHandler(scCh, make(chan error, 4)), rpc, log)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+
+	fake := fakeNode("fake")
+	mockRegisterPeer(fake)
+	defer mockUnregisterPeer(fake.id)
+
+	node.mu.Lock()
+	node.electTimer.Reset(time.Millisecond)
+	node.mu.Unlock()
+	vreq := <-fake.VoteRequests
+	if sc := wait(t, scCh); sc.To != CANDIDATE {
+		t.Fatalf("Expected Node to be a Candidate, got: %+v", sc)
+	}
+
+	// A peer answers from a newer term.
+	newTerm := vreq.Term + 5
+	node.VoteResponses <- &pb.VoteResponse{Term: newTerm, Granted: false, Voter: fake.id}
+	sc := wait(t, scCh)
+	if sc.From != CANDIDATE || sc.To != FOLLOWER || sc.Reason != REASON_HIGHER_TERM {
+		t.Fatalf("Expected Candidate to Follower for %s, got %+v", REASON_HIGHER_TERM, sc)
+	}
+	if term, vote, _ := node.VoteRecord(); term != newTerm || vote != NO_VOTE {
+		t.Fatalf("Expected term %d without a vote, got term %d and vote %q", newTerm, term, vote)
+	}
+	ps, err := node.readState(log)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if ps.CurrentTerm != newTerm || ps.VotedFor != NO_VOTE {
+		t.Fatalf("Expected term %d without a vote to be written, got %+v", newTerm, ps)
+	}
+}
+
+func TestHasVotedThisTerm(t *testing.T) {
+	ci := ClusterInfo{Name: "voted", Size: 3}
+	hand, rpc, log := genNodeArgs(t)
+	node, err := New(ci, hand, rpc, log)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+	// Delay elections
+	node.mu.Lock()
+	node.electTimer.Reset(10 * time.Second)
+	node.mu.Unlock()
+
+	fake := fakeNode("fake")
+	fake.HeartBeatResponses = make(chan *pb.HeartbeatResponse, 1)
+	mockRegisterPeer(fake)
+	defer mockUnregisterPeer(fake.id)
+
+	if node.HasVotedThisTerm() {
+		t.Fatal("Expected no vote in a fresh term")
+	}
+	node.VoteRequests <- &pb.VoteRequest{Term: 1, Candidate: fake.id}
+	if vresp := <-fake.VoteResponses; !vresp.Granted {
+		t.Fatal("Expected the vote to be granted")
+	}
+	if !node.HasVotedThisTerm() {
+		t.Fatal("Expected a vote after voting")
+	}
+
+	// A newer term clears the vote.
+	node.HeartBeats <- &pb.Heartbeat{Term: 2, Leader: fake.id}
+	<-fake.HeartBeatResponses
+	if term := node.CurrentTerm(); term != 2 {
+		t.Fatalf("Expected term 2, got %d", term)
+	}
+	if node.HasVotedThisTerm() {
+		t.Fatal("Expected no vote once the term advanced")
+	}
+}
+
+func TestCampaignId(t *testing.T) {
+	ci := ClusterInfo{Name: "campaign", Size: 3}
+	_, rpc, log := genNodeArgs(t)
+	scCh := make(chan StateChange, 4)
+	node, err := New(ci, NewChanHandler(scCh, make(chan error, 4)), rpc, log)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+	if id := node.Campaign(); id != "" {
+		t.Fatalf("Expected no campaign yet, got %q", id)
+	}
+
+	fake := fakeNode("fake")
+	mockRegisterPeer(fake)
+	defer mockUnregisterPeer(fake.id)
+
+	node.mu.Lock()
+	node.electTimer.Reset(time.Millisecond)
+	node.mu.Unlock()
+	first := <-fake.VoteRequests
+	sc := wait(t, scCh)
+	if sc.To != CANDIDATE {
+		t.Fatalf("Expected Node to be a Candidate, got: %+v", sc)
+	}
+	if first.Campaign == "" || sc.Campaign != first.Campaign {
+		t.Fatalf("Expected the state change to carry campaign %q, got %+v", first.Campaign, sc)
+	}
+
+	// Start a new campaign, and hold off the next one.
+	node.mu.Lock()
+	node.electTimer.Reset(time.Millisecond)
+	node.mu.Unlock()
+	var second *pb.VoteRequest
+	for second == nil {
+		vreq := <-fake.VoteRequests
+		switch {
+		case vreq.Term == first.Term && vreq.Campaign != first.Campaign:
+			t.Fatalf("Expected campaign %q for term %d, got %q", first.Campaign, vreq.Term, vreq.Campaign)
+		case vreq.Term > first.Term:
+			node.mu.Lock()
+			node.electTimer.Reset(10 * time.Second)
+			node.mu.Unlock()
+			second = vreq
+		}
+	}
+	if second.Campaign == "" || second.Campaign == first.Campaign {
+		t.Fatalf("Expected a new campaign id, got %q after %q", second.Campaign, first.Campaign)
+	}
+	if id := node.Campaign(); id != second.Campaign {
+		t.Fatalf("Expected campaign %q, got %q", second.Campaign, id)
+	}
+
+	// Voters echo the campaign, and stepping down reports the last one.
+	node.VoteRequests <- &pb.VoteRequest{Term: second.Term + 1, Candidate: fake.id, Campaign: "theirs"}
+	select {
+	case vresp := <-fake.VoteResponses:
+		if !vresp.Granted || vresp.Campaign != "theirs" {
+			t.Fatalf("Expected a granted vote for campaign %q, got %+v", "theirs", vresp)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for the vote response")
+	}
+	sc = wait(t, scCh)
+	if sc.From != CANDIDATE || sc.To != FOLLOWER || sc.Campaign != second.Campaign {
+		t.Fatalf("Expected Candidate to Follower for campaign %q, got %+v", second.Campaign, sc)
+	}
+}
+
+// leaderWithFake elects node LEADER with the vote of fake.
+func leaderWithFake(t *testing.T, node *Node, fake *Node) uint64 {
+	node.mu.Lock()
+	node.electTimer.Reset(time.Millisecond)
+	node.mu.Unlock()
+	vreq := <-fake.VoteRequests
+	node.VoteResponses <- &pb.VoteResponse{Term: vreq.Term, Granted: true, Voter: fake.id}
+	if state := waitForState(node, LEADER); state != LEADER {
+		t.Fatalf("Expected Node to be in Leader state, got: %s", state)
+	}
+	return vreq.Term
+}
+
+func TestVoteRequestsAsLeaderReported(t *testing.T) {
+	ci := ClusterInfo{Name: "leadervotes", Size: 3}
+	_, rpc, log := genNodeArgs(t)
+	errCh := make(chan error, 8)
+	node, err := New(ci, NewChanHandler(make(chan StateChange, 8), errCh), rpc, log, WithLeaderVoteReports())
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+
+	fake := fakeNode("fake")
+	mockRegisterPeer(fake)
+	defer mockUnregisterPeer(fake.id)
+	term := leaderWithFake(t, node, fake)
+
+	// A request of our term is denied, and we keep leading.
+	node.VoteRequests <- &pb.VoteRequest{Term: term, Candidate: fake.id}
+	vresp := <-fake.VoteResponses
+	if vresp.Granted || vresp.Term != term {
+		t.Fatalf("Expected a denied vote at term %d, got %+v", term, vresp)
+	}
+	if err := errWait(t, errCh); !errors.Is(err, ErrVoteRequestAsLeader) || !strings.Contains(err.Error(), fake.id) {
+		t.Fatalf("Expected %v naming %q, got: %v", ErrVoteRequestAsLeader, fake.id, err)
+	}
+	if state := node.State(); state != LEADER {
+		t.Fatalf("Expected Node to be in Leader state, got: %s", state)
+	}
+
+	// A request of a newer term makes us step down and grant it.
+	node.VoteRequests <- &pb.VoteRequest{Term: term + 1, Candidate: fake.id}
+	vresp = <-fake.VoteResponses
+	if !vresp.Granted || vresp.Term != term+1 {
+		t.Fatalf("Expected a granted vote at term %d, got %+v", term+1, vresp)
+	}
+	if err := errWait(t, errCh); !errors.Is(err, ErrVoteRequestAsLeader) {
+		t.Fatalf("Expected %v, got: %v", ErrVoteRequestAsLeader, err)
+	}
+	if state := waitForState(node, FOLLOWER); state != FOLLOWER {
+		t.Fatalf("Expected Node to be in Follower state, got: %s", state)
+	}
+	if cur, vote, _ := node.VoteRecord(); cur != term+1 || vote != fake.id {
+		t.Fatalf("Expected a vote for %q at term %d, got %q at term %d", fake.id, term+1, vote, cur)
+	}
+}
+
+func TestLeaderStepsDownOnRefusedNewerTerm(t *testing.T) {
+	ci := ClusterInfo{Name: "leaderrefuses", Size: 3}
+	_, rpc, log := genNodeArgs(t)
+	scCh := make(chan StateChange, 8)
+	hand := NewChanHandlerWithStateMachine(&stateMachineHandler{logIndex: 5}, scCh, make(chan error, 8))
+	node, err := New(ci, hand, rpc, log)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer node.Close()
+
+	fake := fakeNode("fake")
+	mockRegisterPeer(fake)
+	defer mockUnregisterPeer(fake.id)
+	term := leaderWithFake(t, node, fake)
+
+	// The candidate is behind, but its term is newer than ours.
+	behind := (&stateMachineHandler{logIndex: 1}).CurrentState()
+	node.VoteRequests <- &pb.VoteRequest{Term: term + 1, Candidate: fake.id, CurrentState: behind}
+	vresp := <-fake.VoteResponses
+	if vresp.Granted || vresp.Term != term+1 {
+		t.Fatalf("Expected a denied vote at term %d, got %+v", term+1, vresp)
+	}
+	// The newer term was persisted before answering.
+	ps, err := node.readState(node.LogPath())
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if ps.CurrentTerm != term+1 || ps.VotedFor != NO_VOTE {
+		t.Fatalf("Expected term %d without a vote to be persisted, got %+v", term+1, ps)
+	}
+	if state := waitForState(node, FOLLOWER); state != FOLLOWER {
+		t.Fatalf("Expected Node to be in Follower state, got: %s", state)
+	}
+	if cur, vote, _ := node.VoteRecord(); cur != term+1 || vote != NO_VOTE {
+		t.Fatalf("Expected term %d without a vote, got %q at term %d", term+1, vote, cur)
+	}
+	if node.Leader() != NO_LEADER {
+		t.Fatalf("Expected no leader, got: %s", node.Leader())
+	}
+}
+
+// baggageHandler records the baggage of the vote requests it handles.
+type baggageHandler struct {
+	dummyHandler
+	baggage chan []string
+}
+
+func (h *baggageHandler) OnBaggage(candidate, campaign string, baggage map[string]string) {
+	h.baggage <- []string{candidate, campaign, baggage["correlation"]}
+}
+
+func TestCampaignBaggage(t *testing.T) {
+	ci := ClusterInfo{Name: "baggage", Size: 3}
+	hub := NewMockHub()
+	nodes := make([]*Node, 3)
+	handlers := make([]*baggageHandler, 3)
+	for i := range nodes {
+		_, _, log := genNodeArgs(t)
+		handlers[i] = &baggageHandler{baggage: make(chan []string, 8)}
+		node, err := New(ci, handlers[i], hub.NewRpc(), log)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		defer node.Close()
+		// Delay elections
+		node.mu.Lock()
+		node.electTimer.Reset(10 * time.Second)
+		node.mu.Unlock()
+		nodes[i] = node
+	}
+
+	candidate := nodes[0]
+	baggage := map[string]string{"correlation": "req-42"}
+	candidate.SetBaggage(baggage)
+	// Later changes to the map are not sent.
+	baggage["correlation"] = "changed"
+	candidate.mu.Lock()
+	candidate.electTimer.Reset(time.Millisecond)
+	candidate.mu.Unlock()
+
+	for _, h := range handlers[1:] {
+		select {
+		case got := <-h.baggage:
+			want := []string{candidate.Id(), candidate.Campaign(), "req-42"}
+			if !slices.Equal(got, want) {
+				t.Fatalf("Expected baggage %q, got %q", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timeout waiting for the baggage")
+		}
+	}
+	if len(handlers[0].baggage) != 0 {
+		t.Fatal("Expected the candidate not to receive its own baggage")
+	}
+}
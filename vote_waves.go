@@ -0,0 +1,130 @@
+// Copyright 2013-2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graft
+
+import (
+	"time"
+
+	"github.com/nats-io/graft/pb"
+)
+
+// voteWaves sends the VoteRequest of a campaign to the peers in waves,
+// keeping at most max requests without a response in flight. Requests
+// still unanswered after interval are considered lost, so the campaign
+// completes within the election timeout. A nil *voteWaves is a campaign
+// that was broadcast at once.
+type voteWaves struct {
+	node     *Node
+	sender   PeerVoteRequester
+	vreq     *pb.VoteRequest
+	queue    []string
+	inflight int
+	max      int
+	interval time.Duration
+	timer    Timer
+}
+
+// requestVotes sends the VoteRequest to the other members, in waves if
+// configured to bound the requests in flight.
+func (n *Node) requestVotes(vreq *pb.VoteRequest) *voteWaves {
+	if vreq = intercept(n, vreq, true); vreq == nil {
+		return nil
+	}
+	n.trace(traceVoteRequested, vreq.Term)
+	if n.opts.voteRequests != nil {
+		select {
+		case n.opts.voteRequests <- vreq:
+		default:
+		}
+	}
+	rpc := n.transport()
+	sender, ok := rpc.(PeerVoteRequester)
+	if n.opts.maxInflightVotes <= 0 || !ok {
+		rpc.RequestVote(vreq)
+		return nil
+	}
+	peers := sender.Peers()
+	waves := (len(peers) + n.opts.maxInflightVotes - 1) / n.opts.maxInflightVotes
+	vw := &voteWaves{
+		node:   n,
+		sender: sender,
+		vreq:   vreq,
+		queue:  peers,
+		max:    n.opts.maxInflightVotes,
+		// Leave room for all the waves within the shortest election timeout.
+		interval: min(n.opts.heartbeat, minElectionTimeout(n.opts.timeouts)/time.Duration(waves+1)),
+	}
+	vw.send()
+	if len(vw.queue) > 0 {
+		vw.timer = n.opts.clock.NewTimer(vw.interval)
+	}
+	return vw
+}
+
+// send fills the free slots with requests to the next peers.
+func (vw *voteWaves) send() {
+	for vw.inflight < vw.max && len(vw.queue) > 0 {
+		peer := vw.queue[0]
+		vw.queue = vw.queue[1:]
+		vw.node.ReportPeerError(peer, vw.sender.RequestVoteFrom(peer, vw.vreq))
+		vw.inflight++
+	}
+}
+
+// C returns the channel signaling that the requests in flight should
+// be considered lost. It is nil when there is nothing left to send.
+func (vw *voteWaves) C() <-chan time.Time {
+	if vw == nil || vw.timer == nil {
+		return nil
+	}
+	return vw.timer.C()
+}
+
+// responded frees the slot of an answered request.
+func (vw *voteWaves) responded() {
+	if vw == nil {
+		return
+	}
+	if vw.inflight > 0 {
+		vw.inflight--
+	}
+	vw.next()
+}
+
+// expired frees the slots of the unanswered requests.
+func (vw *voteWaves) expired() {
+	vw.inflight = 0
+	vw.next()
+}
+
+func (vw *voteWaves) next() {
+	vw.send()
+	if vw.timer == nil {
+		return
+	}
+	if len(vw.queue) == 0 {
+		vw.stop()
+		return
+	}
+	vw.timer.Reset(vw.interval)
+}
+
+// stop releases the timer.
+func (vw *voteWaves) stop() {
+	if vw == nil || vw.timer == nil {
+		return
+	}
+	vw.timer.Stop()
+	vw.timer = nil
+}